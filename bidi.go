@@ -0,0 +1,97 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Unicode directional formatting characters
+const (
+	leftToRightMark     = "\u200e"
+	rightToLeftMark     = "\u200f"
+	leftToRightIsolate  = "\u2066"
+	rightToLeftIsolate  = "\u2067"
+	firstStrongIsolate  = "\u2068"
+	popDirectionIsolate = "\u2069"
+	leftToRightOverride = "\u202d"
+	rightToLeftOverride = "\u202e"
+	popDirectionFormat  = "\u202c"
+)
+
+// markDirection carries the text direction declared through `dir` attributes, `<bdi>` and
+// `<bdo>` over to the text with Unicode directional formatting characters. Every line of plain
+// text is its own bidi paragraph, so blocks with an explicit direction only need a mark at the
+// start of their text, while inline changes of direction are isolated from the surrounding
+// text so that mixed direction lines display in the right order. Blocks that are explicitly
+// left-to-right are only marked within right-to-left content. inherited is the explicit
+// direction of n, if any
+func markDirection(n *html.Node, inherited string) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+
+		dir := strings.ToLower(strings.TrimSpace(getAttr(c, "dir")))
+		if dir != "ltr" && dir != "rtl" && dir != "auto" {
+			dir = ""
+		}
+
+		effective := dir
+		if dir == "" || dir == "auto" {
+			effective = inherited
+		}
+
+		switch {
+		case c.DataAtom == atom.Bdo && dir == "rtl":
+			surroundText(c, rightToLeftOverride, popDirectionFormat)
+		case c.DataAtom == atom.Bdo && dir == "ltr":
+			surroundText(c, leftToRightOverride, popDirectionFormat)
+		case blockElements[c.Data]:
+			if containsBlock(c) {
+				break
+			}
+			if effective == "rtl" {
+				surroundText(c, rightToLeftMark, "")
+			} else if dir == "ltr" && inherited == "rtl" {
+				surroundText(c, leftToRightMark, "")
+			}
+		case c.DataAtom == atom.Bdi, dir == "auto", dir != "" && dir != orLTR(inherited):
+			surroundText(c, isolateFor(dir), popDirectionIsolate)
+		}
+
+		markDirection(c, effective)
+	}
+}
+
+// documentDirection returns the direction declared on the body or html element, if any
+func documentDirection(body *html.Node) string {
+	for n := body; n != nil && n.Type == html.ElementNode; n = n.Parent {
+		switch dir := strings.ToLower(strings.TrimSpace(getAttr(n, "dir"))); dir {
+		case "ltr", "rtl":
+			return dir
+		}
+	}
+	return ""
+}
+
+// orLTR returns dir, defaulting to the left-to-right direction of an unmarked document
+func orLTR(dir string) string {
+	if dir == "" {
+		return "ltr"
+	}
+	return dir
+}
+
+// isolateFor returns the isolate initiator for a direction, leaving it to the first strong
+// character when the direction isn't explicit
+func isolateFor(dir string) string {
+	switch dir {
+	case "ltr":
+		return leftToRightIsolate
+	case "rtl":
+		return rightToLeftIsolate
+	}
+	return firstStrongIsolate
+}
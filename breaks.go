@@ -0,0 +1,77 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// BreakRunPolicy sets how a run of consecutive `<br>` elements is placed in the text version
+type BreakRunPolicy int
+
+const (
+	// ParagraphBreakRuns ends a line at each `<br>`, so that two or more in a row leave a blank
+	// line, up to the limit set by WithMaxBlankLines. This is the default
+	ParagraphBreakRuns BreakRunPolicy = iota
+	// SingleBreakRuns ends a line once for the whole run, so that a run never leaves a blank line
+	SingleBreakRuns
+	// ExactBreakRuns ends a line at each `<br>` and keeps every blank line a run leaves, however
+	// many WithMaxBlankLines allows elsewhere
+	ExactBreakRuns
+)
+
+// blankLineMarker stands in for the blank lines left by runs of `<br>` elements with the
+// ExactBreakRuns policy, so that none of the whitespace clean up can collapse them
+const blankLineMarker = "\ue007"
+
+// apply applies the policy to the runs of `<br>` elements below n
+func (p BreakRunPolicy) apply(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Br {
+			p.apply(c)
+			continue
+		}
+
+		// the whitespace between the elements of a run is dropped, as it would otherwise form
+		// lines of its own in the RegexpConverter
+		run := breakRun(c)
+		for sep := c.NextSibling; len(run) > 1 && sep != run[len(run)-1]; {
+			next := sep.NextSibling
+			if sep.Type != html.ElementNode {
+				n.RemoveChild(sep)
+			}
+			sep = next
+		}
+
+		for _, br := range run[1:] {
+			switch p {
+			case SingleBreakRuns:
+				n.RemoveChild(br)
+			case ExactBreakRuns:
+				n.InsertBefore(&html.Node{Type: html.TextNode, Data: blankLineMarker}, br)
+				c = br
+			}
+		}
+	}
+}
+
+// breakRun returns the `<br>` elements of the run starting at br, separated from each other
+// by nothing but comments and whitespace other than no-break spaces
+func breakRun(br *html.Node) []*html.Node {
+	run := []*html.Node{br}
+	for c := br.NextSibling; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Br {
+			run = append(run, c)
+		} else if c.Type != html.CommentNode && (c.Type != html.TextNode || strings.Trim(c.Data, " \t\r\n\f") != "") {
+			break
+		}
+	}
+	return run
+}
+
+// restoreBlankLines swaps the markers of blank lines left by runs of `<br>` elements back for
+// empty lines
+func restoreBlankLines(text string) string {
+	return strings.Replace(text, blankLineMarker, "", -1)
+}
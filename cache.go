@@ -0,0 +1,182 @@
+package textplain
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// CachingConverter remembers the results of the most recent conversions made by another
+// converter, keyed by a hash of the document, the line length and the options of the
+// converter, so that converting the same document again is a lookup. As merge tags such as
+// `{{ name }}` pass through conversion intact, campaign sends are best served by converting the
+// template before its merge fields are filled in, see ConvertMerged, so that every recipient's
+// conversion hits the cache
+type CachingConverter struct {
+	converter   Converter
+	fingerprint [sha256.Size]byte
+	cache       *cache
+}
+
+// cache is the store of results shared by the CachingConverters wrapping different converters
+type cache struct {
+	size int
+
+	mu      sync.Mutex
+	recent  *list.List
+	entries map[cacheKey]*list.Element
+}
+
+type cacheKey struct {
+	document    [sha256.Size]byte
+	lineLength  int
+	fingerprint [sha256.Size]byte
+}
+
+type cacheEntry struct {
+	key    cacheKey
+	result string
+}
+
+// NewCachingConverter returns a converter that caches up to size results of converter,
+// evicting the least recently used once full
+func NewCachingConverter(converter Converter, size int) *CachingConverter {
+	if size < 1 {
+		size = 1
+	}
+	return &CachingConverter{
+		converter:   converter,
+		fingerprint: fingerprint(converter),
+		cache: &cache{
+			size:    size,
+			recent:  list.New(),
+			entries: make(map[cacheKey]*list.Element),
+		},
+	}
+}
+
+// Wrap returns a converter caching the results of converter alongside those of cc, so that a
+// single cache serves converters configured differently. Converters of this package with the
+// same options share their results, while those of any other converter are kept apart
+func (cc *CachingConverter) Wrap(converter Converter) *CachingConverter {
+	return &CachingConverter{converter: converter, fingerprint: fingerprint(converter), cache: cc.cache}
+}
+
+// Convert returns the cached result for document if there is one, and otherwise converts it
+// with the underlying converter. Failed conversions are not cached
+func (cc *CachingConverter) Convert(document string, lineLength int) (string, error) {
+	key := cacheKey{document: sha256.Sum256([]byte(document)), lineLength: lineLength, fingerprint: cc.fingerprint}
+	if result, ok := cc.cache.lookup(key); ok {
+		return result, nil
+	}
+
+	result, err := cc.converter.Convert(document, lineLength)
+	if err != nil {
+		return result, err
+	}
+	cc.cache.store(key, result)
+	return result, nil
+}
+
+// ConvertMerged converts template, caching the result like Convert, before filling in its
+// merge fields, so that the conversions of a campaign sent to many recipients hit the cache.
+// Each merge tag whose name, eg. `first_name` for `{{ first_name }}` or `FNAME` for
+// `*|FNAME|*`, is among fields is replaced by its value, while any other tag is left as it is.
+// The text is wrapped before the fields are filled in, so lines holding values longer than
+// their tags may run past lineLength
+func (cc *CachingConverter) ConvertMerged(template string, fields map[string]string, lineLength int) (string, error) {
+	text, err := cc.Convert(template, lineLength)
+	if err != nil {
+		return text, err
+	}
+
+	var b strings.Builder
+	var last int
+	for _, span := range mergeTagSpans(text) {
+		if value, ok := fields[mergeTagName(text[span[0]:span[1]])]; ok {
+			b.WriteString(text[last:span[0]])
+			b.WriteString(value)
+			last = span[1]
+		}
+	}
+	b.WriteString(text[last:])
+	return b.String(), nil
+}
+
+// mergeTagName returns the name within a merge tag, without its delimiters and whitespace
+func mergeTagName(tag string) string {
+	for _, delim := range mergeTagDelimiters {
+		if strings.HasPrefix(tag, delim[0]) && strings.HasSuffix(tag, delim[1]) {
+			return strings.TrimSpace(tag[len(delim[0]) : len(tag)-len(delim[1])])
+		}
+	}
+	return tag
+}
+
+// opaqueConverters counts the converters told apart by identity rather than by their options
+var opaqueConverters uint64
+
+// nextFunctions returns a new identity for options holding functions, which can't be compared
+func nextFunctions() uint64 {
+	return atomic.AddUint64(&opaqueConverters, 1)
+}
+
+// fingerprint returns a hash of the kind of converter and the options that shape its results.
+// Converters of other packages are each given a fingerprint of their own
+func fingerprint(converter Converter) [sha256.Size]byte {
+	configured, ok := converter.(interface{ configuration() *options })
+	if !ok {
+		return sha256.Sum256([]byte(fmt.Sprint(nextFunctions())))
+	}
+	o := configured.configuration()
+
+	// the functions, and the options that have no bearing on the text, are left out, with the
+	// identity given to any functions standing in for them
+	shaping := *o
+	shaping.spacingRules = nil
+	shaping.stageHook = nil
+	shaping.timeout = 0
+	shaping.parallelism = 0
+	shaping.languageRules = make(map[string]LanguageRules, len(o.languageRules))
+	for lang, rules := range o.languageRules {
+		rules.Transform = nil
+		shaping.languageRules[lang] = rules
+	}
+	return sha256.Sum256([]byte(fmt.Sprintf("%T %#v", converter, shaping)))
+}
+
+// configuration returns the options of the converters embedding them
+func (o *options) configuration() *options {
+	return o
+}
+
+func (cc *cache) lookup(key cacheKey) (string, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	elem, ok := cc.entries[key]
+	if !ok {
+		return "", false
+	}
+	cc.recent.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).result, true
+}
+
+func (cc *cache) store(key cacheKey, result string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if elem, ok := cc.entries[key]; ok {
+		cc.recent.MoveToFront(elem)
+		return
+	}
+	cc.entries[key] = cc.recent.PushFront(&cacheEntry{key: key, result: result})
+	for cc.recent.Len() > cc.size {
+		oldest := cc.recent.Back()
+		cc.recent.Remove(oldest)
+		delete(cc.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,32 @@
+package textplain
+
+import (
+	"bytes"
+	"io"
+
+	"golang.org/x/net/html/charset"
+)
+
+// ConvertEncoded converts a document that may not be UTF-8 encoded. The encoding is
+// determined from the optional Content-Type header value, any `<meta charset>` declaration
+// in the document or, failing both, by sniffing the content
+func ConvertEncoded(document []byte, contentType string, lineLength int) (string, error) {
+	decoded, err := decodeCharset(document, contentType)
+	if err != nil {
+		return "", err
+	}
+	return Convert(decoded, lineLength)
+}
+
+// decodeCharset transcodes document to UTF-8
+func decodeCharset(document []byte, contentType string) (string, error) {
+	reader, err := charset.NewReader(bytes.NewReader(document), contentType)
+	if err != nil {
+		return "", err
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
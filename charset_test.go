@@ -0,0 +1,45 @@
+package textplain_test
+
+import (
+	"testing"
+
+	"github.com/mailproto/textplain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertEncoded(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		document    []byte
+		contentType string
+		expect      string
+	}{
+		{
+			name:        "latin-1 from content type",
+			document:    []byte("<p>gar\xe7on</p>"),
+			contentType: "text/html; charset=iso-8859-1",
+			expect:      "garçon",
+		},
+		{
+			name:     "latin-1 from meta charset",
+			document: []byte("<html><head><meta charset=\"iso-8859-1\"></head><body><p>c\xe9dille</p></body></html>"),
+			expect:   "cédille",
+		},
+		{
+			name:     "shift-jis from meta http-equiv",
+			document: []byte(`<html><head><meta http-equiv="Content-Type" content="text/html; charset=Shift_JIS"></head><body><p>` + "\x82\xb1\x82\xf1\x82\xc9\x82\xbf\x82\xcd" + `</p></body></html>`),
+			expect:   "こんにちは",
+		},
+		{
+			name:     "utf-8 passes through",
+			document: []byte("<p>garçon</p>"),
+			expect:   "garçon",
+		},
+	} {
+		t.Run(tc.name, func(tt *testing.T) {
+			result, err := textplain.ConvertEncoded(tc.document, tc.contentType, textplain.DefaultLineLength)
+			assert.Nil(tt, err)
+			assert.Equal(tt, tc.expect, result)
+		})
+	}
+}
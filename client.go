@@ -0,0 +1,129 @@
+package textplain
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Viewport widths, in css pixels, assumed when evaluating media queries for a target client
+const (
+	desktopViewport = 800
+	mobileViewport  = 375
+)
+
+var (
+	// mobileOnlyClasses are the class names email builders commonly use for content that is
+	// hidden from desktop clients
+	mobileOnlyClasses = []string{
+		"mobile-only", "show-on-mobile", "show-mobile", "mobile-show", "visible-mobile",
+		"hide-on-desktop", "hide-desktop", "desktop-hide", "hidden-desktop",
+	}
+
+	// desktopOnlyClasses are the class names email builders commonly use for content that is
+	// hidden from mobile clients
+	desktopOnlyClasses = []string{
+		"desktop-only", "show-on-desktop", "show-desktop", "desktop-show", "visible-desktop",
+		"hide-on-mobile", "hide-mobile", "mobile-hide", "hidden-mobile",
+	}
+)
+
+// hideOtherVariants hides the content that the conventional class names reserve for the
+// clients other than the target client
+func (o *options) hideOtherVariants(body *html.Node) {
+	var classes []string
+	switch o.targetClient {
+	case ClientDesktop:
+		classes = mobileOnlyClasses
+	case ClientMobile:
+		classes = desktopOnlyClasses
+	default:
+		return
+	}
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && hasAnyClass(c, classes) {
+				inlineDeclaration(c, "display:none", true)
+			}
+			walk(c)
+		}
+	}
+	walk(body)
+}
+
+// matchesMedia evaluates a media query list against the client's viewport. Only screen
+// media with width conditions in px or em are understood; queries using any other feature or
+// the `not` modifier never match
+func (c Client) matchesMedia(list string) bool {
+	viewport := desktopViewport
+	if c == ClientMobile {
+		viewport = mobileViewport
+	}
+
+	for _, query := range strings.Split(strings.ToLower(list), ",") {
+		if mediaQueryMatches(query, viewport) {
+			return true
+		}
+	}
+	return false
+}
+
+func mediaQueryMatches(query string, viewport int) bool {
+	query = strings.NewReplacer("(", " ( ", ")", " ) ").Replace(query)
+	var feature []string
+	var inFeature, any bool
+	for _, token := range strings.Fields(query) {
+		switch {
+		case token == "(":
+			inFeature, feature = true, nil
+		case token == ")":
+			if !inFeature || !widthConditionMatches(strings.Join(feature, ""), viewport) {
+				return false
+			}
+			inFeature, any = false, true
+		case inFeature:
+			feature = append(feature, token)
+		case token == "only", token == "and", token == "screen", token == "all":
+			any = true
+		default:
+			return false
+		}
+	}
+	return any && !inFeature
+}
+
+// widthConditionMatches evaluates a single `min-width`/`max-width` media feature, including
+// the device-width variants
+func widthConditionMatches(feature string, viewport int) bool {
+	idx := strings.Index(feature, ":")
+	if idx < 0 {
+		return false
+	}
+	name, value := feature[:idx], feature[idx+1:]
+
+	var width float64
+	var err error
+	switch {
+	case strings.HasSuffix(value, "px"):
+		width, err = strconv.ParseFloat(strings.TrimSuffix(value, "px"), 64)
+	case strings.HasSuffix(value, "em"):
+		width, err = strconv.ParseFloat(strings.TrimSuffix(strings.TrimSuffix(value, "em"), "r"), 64)
+		width *= 16
+	default:
+		return false
+	}
+	if err != nil {
+		return false
+	}
+
+	switch name {
+	case "max-width", "max-device-width":
+		return float64(viewport) <= width
+	case "min-width", "min-device-width":
+		return float64(viewport) >= width
+	}
+	return false
+}
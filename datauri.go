@@ -0,0 +1,45 @@
+package textplain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isDataURI reports whether the supplied href/src is an inline `data:` URI
+func isDataURI(uri string) bool {
+	return len(uri) >= 5 && strings.EqualFold(uri[:5], "data:")
+}
+
+// dataURIPlaceholder summarizes a `data:` URI, which can easily be tens of KB, as a short
+// placeholder such as `[inline image]`, which is kept on a single line
+func (o *options) dataURIPlaceholder(uri string) string {
+	header, payload := strings.TrimSpace(uri[5:]), ""
+	if idx := strings.Index(header, ","); idx >= 0 {
+		header, payload = header[:idx], header[idx+1:]
+	}
+
+	kind := "inline data"
+	if strings.HasPrefix(strings.ToLower(header), "image/") {
+		kind = "inline image"
+	}
+
+	if !o.dataURISizes {
+		return NoWrap("[" + kind + "]")
+	}
+
+	size := len(payload)
+	if strings.HasSuffix(strings.ToLower(header), ";base64") {
+		size = len(strings.TrimRight(payload, "=")) * 3 / 4
+	}
+	return NoWrap("[" + kind + ", " + formatSize(size) + "]")
+}
+
+func formatSize(size int) string {
+	switch {
+	case size < 1024:
+		return fmt.Sprintf("%d B", size)
+	case size < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	}
+	return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+}
@@ -0,0 +1,92 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// dedupeThreshold is the proportion of words two sibling blocks must share to be considered
+// variants of the same content
+const dedupeThreshold = 0.9
+
+// dedupeSiblings removes blocks that are near-identical to the block immediately preceding
+// them, as produced by builders that duplicate whole sections as light/dark or mobile/desktop
+// variants toggled through css
+func dedupeSiblings(n *html.Node) {
+	var prev *html.Node
+	var prevSignature []string
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if !significant(c) {
+			c = next
+			continue
+		}
+
+		if c.Type != html.ElementNode || !blockElements[c.Data] || isTablePart(c) {
+			dedupeSiblings(c)
+			prev, prevSignature = nil, nil
+			c = next
+			continue
+		}
+
+		signature := blockSignature(c)
+		if prev != nil && len(signature) > 0 && similarWords(prevSignature, signature) {
+			n.RemoveChild(c)
+		} else {
+			dedupeSiblings(c)
+			prev, prevSignature = c, signature
+		}
+		c = next
+	}
+}
+
+// blockSignature returns the normalized words of a block's text and image alt attributes
+func blockSignature(n *html.Node) []string {
+	var text strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.Type {
+			case html.TextNode:
+				text.WriteString(c.Data)
+				text.WriteByte(' ')
+			case html.ElementNode:
+				if alt := getAttr(c, "alt"); alt != "" {
+					text.WriteString(alt)
+					text.WriteByte(' ')
+				}
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	return strings.Fields(normalizeWords(text.String()))
+}
+
+// similarWords reports whether two word lists are of similar length and share most words
+func similarWords(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	shorter, longer := len(a), len(b)
+	if shorter > longer {
+		shorter, longer = longer, shorter
+	}
+	if float64(shorter)/float64(longer) < dedupeThreshold {
+		return false
+	}
+
+	counts := make(map[string]int, len(a))
+	for _, word := range a {
+		counts[word]++
+	}
+	var shared int
+	for _, word := range b {
+		if counts[word] > 0 {
+			counts[word]--
+			shared++
+		}
+	}
+	return float64(shared)/float64(longer) >= dedupeThreshold
+}
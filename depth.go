@@ -0,0 +1,139 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxNestingDepth is the depth beyond which the tree is flattened unless configured otherwise
+// with WithMaxDepth, as browsers limit the depth of the trees they build, so that adversarially
+// deep documents can't exhaust the stack of the recursive walks made while converting them
+const maxNestingDepth = 512
+
+// WithMaxDepth sets the depth of the parsed document beyond which its elements are flattened,
+// in place of the default of 512. The content nested deeper is moved up to the element at the
+// limit, in document order, keeping the subtrees that hold paragraphs, links and the like
+// whole. Lower limits bound the stack used by the walks made while converting a document more
+// tightly. A limit below one leaves the default in place
+func WithMaxDepth(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.maxDepth = n
+		}
+	}
+}
+
+// keptHeight is the height of the subtrees kept whole when the tree is flattened, so that the
+// paragraphs, links and the like at the bottom of a deep document still convert as usual
+const keptHeight = 32
+
+// parse parses document, flattening the tree below maxDepth
+func parse(document string, maxDepth int) (*html.Node, error) {
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return nil, err
+	}
+	limitDepth(root, maxDepth)
+	return root, nil
+}
+
+// limitDepth flattens the content of each node at maxDepth, so that nothing is nested much
+// deeper
+func limitDepth(root *html.Node, maxDepth int) {
+	type entry struct {
+		n     *html.Node
+		depth int
+	}
+	stack := []entry{{root, 0}}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if e.depth == maxDepth {
+			flattenDescendants(e.n)
+			continue
+		}
+		for c := e.n.FirstChild; c != nil; c = c.NextSibling {
+			stack = append(stack, entry{c, e.depth + 1})
+		}
+	}
+}
+
+// flattenDescendants lists the content below n as its children, in document order. Subtrees
+// no taller than keptHeight are moved up whole, while the elements above them are left empty
+// to mark where they were
+func flattenDescendants(n *html.Node) {
+	descendants := preorder(n)
+	heights := make(map[*html.Node]int, len(descendants))
+	for i := len(descendants) - 1; i >= 0; i-- {
+		if c := descendants[i]; c.Parent != n && heights[c]+1 > heights[c.Parent] {
+			heights[c.Parent] = heights[c] + 1
+		}
+	}
+
+	var stack []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		stack = append(stack, c)
+	}
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		var children []*html.Node
+		if heights[c] > keptHeight {
+			for gc := c.FirstChild; gc != nil; gc = gc.NextSibling {
+				children = append(children, gc)
+			}
+			for _, gc := range children {
+				c.RemoveChild(gc)
+			}
+		}
+		if c.Parent != nil {
+			c.Parent.RemoveChild(c)
+		}
+		n.AppendChild(c)
+		for i := len(children) - 1; i >= 0; i-- {
+			stack = append(stack, children[i])
+		}
+	}
+}
+
+// preorder lists the nodes below n in document order
+func preorder(n *html.Node) []*html.Node {
+	var nodes, stack []*html.Node
+	for c := n.LastChild; c != nil; c = c.PrevSibling {
+		stack = append(stack, c)
+	}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		nodes = append(nodes, c)
+		for gc := c.LastChild; gc != nil; gc = gc.PrevSibling {
+			stack = append(stack, gc)
+		}
+	}
+	return nodes
+}
+
+// findBody searches the tree below n for a `<body>` element with content
+func findBody(n *html.Node) *html.Node {
+	stack := []*html.Node{n}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if n.FirstChild == nil {
+			continue
+		}
+		if n.Type == html.ElementNode && n.DataAtom == atom.Body {
+			return n
+		}
+		for c := n.LastChild; c != nil; c = c.PrevSibling {
+			stack = append(stack, c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package textplain
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Document is a document parsed and prepared by a TreeConverter, from which any number of
+// texts can be rendered without parsing it again. Rendering leaves the document as it is, so
+// a Document is safe to render from many goroutines at once
+type Document struct {
+	converter *TreeConverter
+	body      *html.Node
+	conv      *conversion
+}
+
+// Parse parses and prepares document once, for rendering as any of the texts the Document
+// supplies. The limits set with WithTimeout and WithMemoryLimit apply to parsing and to each
+// rendering separately
+func (t *TreeConverter) Parse(document string) (*Document, error) {
+	return t.parse(document, t.startBudget())
+}
+
+// parse parses and prepares document within budget
+func (t *TreeConverter) parse(document string, budget *budget) (*Document, error) {
+	document, conv := t.preprocess(document)
+
+	root, err := parse(document, t.maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	if err := budget.check("parse", len(document), nil); err != nil {
+		return nil, err
+	}
+	if err := t.checkParsed(document, root); err != nil {
+		return nil, err
+	}
+
+	body := findBody(root)
+	if body == nil {
+		return nil, ErrBodyNotFound
+	}
+
+	d := &Document{converter: t, body: body, conv: conv}
+	t.prepare(d.body, conv)
+	if err := budget.check("prepare", len(document), nil); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Text renders the document as Convert would, wrapped to lineLength, or unwrapped where
+// lineLength is zero
+func (d *Document) Text(lineLength int) (string, error) {
+	return d.converter.render(d, lineLength, d.converter.startBudget())
+}
+
+// Markdown renders the document as Text does, but with headings in the ATX style and links as
+// `[text](href)`, for consumers that render Markdown. The rest of the text is not escaped
+func (d *Document) Markdown(lineLength int) (string, error) {
+	markdown := &TreeConverter{options: d.converter.options}
+	markdown.headingStyles = ATXHeadingStyles()
+	markdown.linkMode = LinkMarkdown
+	return markdown.render(d, lineLength, markdown.startBudget())
+}
+
+// Snippet renders the start of the document's text on a single line, with undecorated
+// headings and without link targets, for previews such as those shown in an inbox. Text past
+// length characters is cut at the last word that fits and replaced with an ellipsis. A length
+// below one gives an empty snippet
+func (d *Document) Snippet(length int) (string, error) {
+	if length < 1 {
+		return "", nil
+	}
+
+	snippet := &TreeConverter{options: d.converter.options}
+	snippet.headingStyles = [6]HeadingStyle{}
+	snippet.linkMode = LinkTextOnly
+	text, err := snippet.render(d, 0, snippet.startBudget())
+	if err != nil {
+		return "", err
+	}
+
+	text = strings.Join(strings.Fields(text), " ")
+	clusters := graphemes(text)
+	if len(clusters) <= length {
+		return text, nil
+	}
+
+	cut := clusterOffsets(clusters[:length])[length]
+	if idx := strings.LastIndexByte(text[:cut+1], ' '); idx > 0 {
+		cut = idx
+	}
+	return strings.TrimRight(text[:cut], " ") + "…", nil
+}
+
+// render converts the prepared body of d to text, wrapped to lineLength
+func (t *TreeConverter) render(d *Document, lineLength int, budget *budget) (string, error) {
+	if n := t.fanOutNode(d.body); n != nil {
+		t = &TreeConverter{options: t.options, fanOut: n}
+	}
+
+	var b bytes.Buffer
+	if err := t.doConvert(&b, d.body); err != nil {
+		return "", err
+	}
+
+	text := t.tidyWhitespace(b.String())
+	text = joinControlBlocks(text)
+	if err := budget.check("convert", len(text), func() string { return d.conv.finish(t.trimText(text)) }); err != nil {
+		return "", err
+	}
+
+	wrapped := t.wrapText(t.trimText(text), lineLength)
+	if !t.wrapping.hard { // the brace fixes can push lines past a strict limit
+		wrapped = fixWrappedOpenBraces(wrapped)               // XXX: cheap fix for wrapping open braces. move into WordWrap
+		wrapped = strings.Replace(wrapped, "\n)", " )\n", -1) // XXX: cheap fix for wrapping closed braces. move into WordWrap
+	}
+	budget.record("wrap", len(wrapped))
+
+	return d.conv.finish(wrapped), nil
+}
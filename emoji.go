@@ -0,0 +1,292 @@
+package textplain
+
+import (
+	"strings"
+	"unicode"
+)
+
+// EmojiMode sets how emoji are rendered in the text version
+type EmojiMode int
+
+const (
+	// EmojiKeep leaves emoji as they are. This is the default
+	EmojiKeep EmojiMode = iota
+	// EmojiShortcodes replaces emoji with their `:shortcode:`, eg. `:thumbsup:` or `:tada:`,
+	// ignoring any skin tone. Flags become `:flag_xx:`, with their region code, while emoji
+	// without a known shortcode are removed
+	EmojiShortcodes
+	// EmojiStrip removes emoji altogether
+	EmojiStrip
+)
+
+func (m EmojiMode) apply(text string) string {
+	if m == EmojiKeep || !mayContainEmoji(text) {
+		return text
+	}
+
+	var b strings.Builder
+	for _, cluster := range graphemes(text) {
+		if !isEmoji(cluster) {
+			b.WriteString(cluster)
+		} else if m == EmojiShortcodes {
+			if code := shortcode(cluster); code != "" {
+				b.WriteString(":" + code + ":")
+			}
+		}
+	}
+	return b.String()
+}
+
+// mayContainEmoji reports whether text contains any character at or beyond the first emoji
+func mayContainEmoji(text string) bool {
+	for _, r := range text {
+		if r >= '\u231a' {
+			return true
+		}
+	}
+	return false
+}
+
+// emojiPresentation holds the characters that render as emoji by default. Other emoji, such as
+// `\u2764`, only do so when followed by the emoji variation selector
+var emojiPresentation = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x231a, Hi: 0x231b, Stride: 1},
+		{Lo: 0x23e9, Hi: 0x23ec, Stride: 1},
+		{Lo: 0x23f0, Hi: 0x23f3, Stride: 3},
+		{Lo: 0x25fd, Hi: 0x25fe, Stride: 1},
+		{Lo: 0x2614, Hi: 0x2615, Stride: 1},
+		{Lo: 0x2648, Hi: 0x2653, Stride: 1},
+		{Lo: 0x267f, Hi: 0x2693, Stride: 20},
+		{Lo: 0x26a1, Hi: 0x26a1, Stride: 1},
+		{Lo: 0x26aa, Hi: 0x26ab, Stride: 1},
+		{Lo: 0x26bd, Hi: 0x26be, Stride: 1},
+		{Lo: 0x26c4, Hi: 0x26c5, Stride: 1},
+		{Lo: 0x26ce, Hi: 0x26d4, Stride: 6},
+		{Lo: 0x26ea, Hi: 0x26ea, Stride: 1},
+		{Lo: 0x26f2, Hi: 0x26f3, Stride: 1},
+		{Lo: 0x26f5, Hi: 0x26fa, Stride: 5},
+		{Lo: 0x26fd, Hi: 0x26fd, Stride: 1},
+		{Lo: 0x2705, Hi: 0x2705, Stride: 1},
+		{Lo: 0x270a, Hi: 0x270b, Stride: 1},
+		{Lo: 0x2728, Hi: 0x2728, Stride: 1},
+		{Lo: 0x274c, Hi: 0x274e, Stride: 2},
+		{Lo: 0x2753, Hi: 0x2755, Stride: 1},
+		{Lo: 0x2757, Hi: 0x2757, Stride: 1},
+		{Lo: 0x2795, Hi: 0x2797, Stride: 1},
+		{Lo: 0x27b0, Hi: 0x27bf, Stride: 15},
+		{Lo: 0x2b1b, Hi: 0x2b1c, Stride: 1},
+		{Lo: 0x2b50, Hi: 0x2b55, Stride: 5},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f004, Hi: 0x1f004, Stride: 1},
+		{Lo: 0x1f0cf, Hi: 0x1f0cf, Stride: 1},
+		{Lo: 0x1f18e, Hi: 0x1f18e, Stride: 1},
+		{Lo: 0x1f191, Hi: 0x1f19a, Stride: 1},
+		{Lo: 0x1f1e6, Hi: 0x1f1ff, Stride: 1},
+		{Lo: 0x1f300, Hi: 0x1f64f, Stride: 1},
+		{Lo: 0x1f680, Hi: 0x1f6ff, Stride: 1},
+		{Lo: 0x1f900, Hi: 0x1f9ff, Stride: 1},
+		{Lo: 0x1fa70, Hi: 0x1faff, Stride: 1},
+	},
+}
+
+const (
+	emojiVariation = '\ufe0f'
+	keycap         = '\u20e3'
+)
+
+// isEmoji reports whether the grapheme cluster renders as an emoji
+func isEmoji(cluster string) bool {
+	for _, r := range cluster {
+		return unicode.Is(emojiPresentation, r) || strings.ContainsRune(cluster, emojiVariation) || strings.ContainsRune(cluster, keycap)
+	}
+	return false
+}
+
+// shortcode returns the shortcode of an emoji cluster, without its colons, or an empty string
+// if it has none
+func shortcode(cluster string) string {
+	base := strings.Map(func(r rune) rune {
+		if r == emojiVariation || r >= 0x1f3fb && r <= 0x1f3ff {
+			return -1
+		}
+		return r
+	}, cluster)
+
+	if code, ok := emojiShortcodes[base]; ok {
+		return code
+	}
+
+	var region []rune
+	for _, r := range base {
+		if !isRegionalIndicator(r) {
+			return ""
+		}
+		region = append(region, 'a'+r-0x1f1e6)
+	}
+	if len(region) == 2 {
+		return "flag_" + string(region)
+	}
+	return ""
+}
+
+// emojiShortcodes maps common emoji, stripped of variation selectors and skin tones, to their
+// shortcodes as used by GitHub and Slack
+var emojiShortcodes = map[string]string{
+	"\U0001F600":                 "grinning",
+	"\U0001F603":                 "smiley",
+	"\U0001F604":                 "smile",
+	"\U0001F601":                 "grin",
+	"\U0001F606":                 "laughing",
+	"\U0001F605":                 "sweat_smile",
+	"\U0001F602":                 "joy",
+	"\U0001F923":                 "rofl",
+	"\U0001F60A":                 "blush",
+	"\U0001F607":                 "innocent",
+	"\U0001F642":                 "slightly_smiling_face",
+	"\U0001F643":                 "upside_down_face",
+	"\U0001F609":                 "wink",
+	"\U0001F60D":                 "heart_eyes",
+	"\U0001F970":                 "smiling_face_with_three_hearts",
+	"\U0001F618":                 "kissing_heart",
+	"\U0001F60B":                 "yum",
+	"\U0001F60E":                 "sunglasses",
+	"\U0001F929":                 "star_struck",
+	"\U0001F973":                 "partying_face",
+	"\U0001F60F":                 "smirk",
+	"\U0001F612":                 "unamused",
+	"\U0001F61E":                 "disappointed",
+	"\U0001F614":                 "pensive",
+	"\U0001F61F":                 "worried",
+	"\U0001F615":                 "confused",
+	"\U0001F641":                 "slightly_frowning_face",
+	"\U0001F622":                 "cry",
+	"\U0001F62D":                 "sob",
+	"\U0001F624":                 "triumph",
+	"\U0001F620":                 "angry",
+	"\U0001F621":                 "rage",
+	"\U0001F92F":                 "exploding_head",
+	"\U0001F633":                 "flushed",
+	"\U0001F631":                 "scream",
+	"\U0001F628":                 "fearful",
+	"\U0001F914":                 "thinking",
+	"\U0001F917":                 "hugs",
+	"\U0001F92B":                 "shushing_face",
+	"\U0001F634":                 "sleeping",
+	"\U0001F644":                 "roll_eyes",
+	"\U0001F62C":                 "grimacing",
+	"\U0001F91D":                 "handshake",
+	"\U0001F44D":                 "thumbsup",
+	"\U0001F44E":                 "thumbsdown",
+	"\U0001F44F":                 "clap",
+	"\U0001F64C":                 "raised_hands",
+	"\U0001F64F":                 "pray",
+	"\U0001F44B":                 "wave",
+	"\U0001F44C":                 "ok_hand",
+	"\u270c":                     "v",
+	"\U0001F91E":                 "crossed_fingers",
+	"\U0001F4AA":                 "muscle",
+	"\U0001F440":                 "eyes",
+	"\u2764":                     "heart",
+	"\U0001F9E1":                 "orange_heart",
+	"\U0001F49B":                 "yellow_heart",
+	"\U0001F49A":                 "green_heart",
+	"\U0001F499":                 "blue_heart",
+	"\U0001F49C":                 "purple_heart",
+	"\U0001F5A4":                 "black_heart",
+	"\U0001F494":                 "broken_heart",
+	"\U0001F4AF":                 "100",
+	"\U0001F525":                 "fire",
+	"\u2728":                     "sparkles",
+	"\u2b50":                     "star",
+	"\U0001F31F":                 "star2",
+	"\u26a1":                     "zap",
+	"\U0001F389":                 "tada",
+	"\U0001F38A":                 "confetti_ball",
+	"\U0001F381":                 "gift",
+	"\U0001F388":                 "balloon",
+	"\U0001F382":                 "birthday",
+	"\U0001F3C6":                 "trophy",
+	"\U0001F947":                 "1st_place_medal",
+	"\U0001F680":                 "rocket",
+	"\u2705":                     "white_check_mark",
+	"\u2714":                     "heavy_check_mark",
+	"\u274c":                     "x",
+	"\u2757":                     "exclamation",
+	"\u2753":                     "question",
+	"\u26a0":                     "warning",
+	"\U0001F514":                 "bell",
+	"\U0001F4E3":                 "mega",
+	"\U0001F4E2":                 "loudspeaker",
+	"\U0001F4E7":                 "e-mail",
+	"\u2709":                     "envelope",
+	"\U0001F4E9":                 "envelope_with_arrow",
+	"\U0001F4E6":                 "package",
+	"\U0001F6D2":                 "shopping_cart",
+	"\U0001F4B3":                 "credit_card",
+	"\U0001F4B0":                 "moneybag",
+	"\U0001F4B8":                 "money_with_wings",
+	"\U0001F3F7":                 "label",
+	"\U0001F4C5":                 "date",
+	"\U0001F4C6":                 "calendar",
+	"\u23f0":                     "alarm_clock",
+	"\u23f3":                     "hourglass_flowing_sand",
+	"\u231b":                     "hourglass",
+	"\U0001F4CD":                 "round_pushpin",
+	"\U0001F4CC":                 "pushpin",
+	"\U0001F517":                 "link",
+	"\U0001F4F1":                 "iphone",
+	"\U0001F4BB":                 "computer",
+	"\U0001F4DE":                 "telephone_receiver",
+	"\u260e":                     "phone",
+	"\U0001F512":                 "lock",
+	"\U0001F511":                 "key",
+	"\U0001F4A1":                 "bulb",
+	"\U0001F4DD":                 "memo",
+	"\U0001F4DA":                 "books",
+	"\U0001F393":                 "mortar_board",
+	"\u2615":                     "coffee",
+	"\U0001F355":                 "pizza",
+	"\U0001F354":                 "hamburger",
+	"\U0001F370":                 "cake",
+	"\U0001F377":                 "wine_glass",
+	"\U0001F37A":                 "beer",
+	"\U0001F942":                 "clinking_glasses",
+	"\U0001F30D":                 "earth_africa",
+	"\U0001F30E":                 "earth_americas",
+	"\U0001F30F":                 "earth_asia",
+	"\u2600":                     "sunny",
+	"\U0001F31E":                 "sun_with_face",
+	"\U0001F308":                 "rainbow",
+	"\u2744":                     "snowflake",
+	"\U0001F384":                 "christmas_tree",
+	"\U0001F383":                 "jack_o_lantern",
+	"\U0001F436":                 "dog",
+	"\U0001F431":                 "cat",
+	"\U0001F449":                 "point_right",
+	"\U0001F448":                 "point_left",
+	"\U0001F446":                 "point_up_2",
+	"\U0001F447":                 "point_down",
+	"\u27a1":                     "arrow_right",
+	"\u2b05":                     "arrow_left",
+	"\u2b06":                     "arrow_up",
+	"\u2b07":                     "arrow_down",
+	"\U0001F195":                 "new",
+	"\U0001F193":                 "free",
+	"\U0001F51D":                 "top",
+	"0\u20e3":                    "zero",
+	"1\u20e3":                    "one",
+	"2\u20e3":                    "two",
+	"3\u20e3":                    "three",
+	"4\u20e3":                    "four",
+	"5\u20e3":                    "five",
+	"6\u20e3":                    "six",
+	"7\u20e3":                    "seven",
+	"8\u20e3":                    "eight",
+	"9\u20e3":                    "nine",
+	"#\u20e3":                    "hash",
+	"\U0001F468\u200d\U0001F4BB": "man_technologist",
+	"\U0001F469\u200d\U0001F4BB": "woman_technologist",
+	"\U0001F3F3\u200d\U0001F308": "rainbow_flag",
+}
@@ -0,0 +1,154 @@
+package textplain
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Emphasis markers surround bold and italic text when WithEmphasisMarkers is set
+const (
+	boldMarker   = "*"
+	italicMarker = "_"
+)
+
+// emphasis tracks the bold and italic state of a subtree, and whether an ancestor has
+// already placed markers for it
+type emphasis struct {
+	bold, italic             bool
+	markedBold, markedItalic bool
+}
+
+// markEmphasis surrounds the text of bold and italic inline content with emphasis markers.
+// Emphasis comes from `<strong>`/`<b>` and `<em>`/`<i>` as well as the `font-weight` and
+// `font-style` inline styles email builders use instead. Markers are only placed around
+// content without block children, so that they never span paragraphs, and are closed before
+// any descendant styled back to a normal weight or style. Headings are left to their own
+// formatting
+func markEmphasis(n *html.Node, state emphasis) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+
+		inner := state
+		switch c.DataAtom {
+		case atom.Strong, atom.B:
+			inner.bold = true
+		case atom.Em, atom.I:
+			inner.italic = true
+		case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+			inner.bold, inner.markedBold = true, true
+		}
+
+		// content styled back to normal lies outside of the markers of its ancestors
+		style := inlineStyle(c)
+		if weight, ok := style["font-weight"]; ok {
+			inner.bold = isBoldWeight(weight)
+			inner.markedBold = inner.markedBold && inner.bold
+		}
+		if fontStyle, ok := style["font-style"]; ok {
+			inner.italic = isItalicStyle(fontStyle)
+			inner.markedItalic = inner.markedItalic && inner.italic
+		}
+
+		if !containsBlock(c) {
+			if inner.italic && !inner.markedItalic {
+				markRuns(c, italicMarker, func(style map[string]string) bool {
+					fontStyle, ok := style["font-style"]
+					return ok && !isItalicStyle(fontStyle)
+				})
+				inner.markedItalic = true
+			}
+			if inner.bold && !inner.markedBold {
+				markRuns(c, boldMarker, func(style map[string]string) bool {
+					weight, ok := style["font-weight"]
+					return ok && !isBoldWeight(weight)
+				})
+				inner.markedBold = true
+			}
+		}
+
+		markEmphasis(c, inner)
+	}
+}
+
+// markRuns surrounds each run of the text below n with marker, breaking the runs at the
+// descendants whose inline style resets the emphasis
+func markRuns(n *html.Node, marker string, resets func(map[string]string) bool) {
+	runs := [][]*html.Node{nil}
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch {
+			case c.Type == html.TextNode:
+				runs[len(runs)-1] = append(runs[len(runs)-1], c)
+			case c.Type != html.ElementNode:
+			case hasAttr(c, "style") && resets(inlineStyle(c)):
+				runs = append(runs, nil)
+			default:
+				collect(c)
+			}
+		}
+	}
+	collect(n)
+
+	for _, run := range runs {
+		surroundTexts(run, marker, marker)
+	}
+}
+
+// isBoldWeight reports whether a css font-weight value renders as bold
+func isBoldWeight(weight string) bool {
+	switch weight {
+	case "bold", "bolder":
+		return true
+	}
+	numeric, err := strconv.Atoi(weight)
+	return err == nil && numeric >= 600
+}
+
+// isItalicStyle reports whether a css font-style value renders as italic
+func isItalicStyle(fontStyle string) bool {
+	return fontStyle == "italic" || fontStyle == "oblique"
+}
+
+// containsBlock reports whether any element below n starts a new block
+func containsBlock(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (blockElements[c.Data] || containsBlock(c)) {
+			return true
+		}
+	}
+	return false
+}
+
+// surroundText places open before the first and close after the last non-whitespace text
+// below n, keeping any surrounding whitespace outside of the markers
+func surroundText(n *html.Node, open, close string) {
+	var texts []*html.Node
+	collectText(n, &texts)
+	surroundTexts(texts, open, close)
+}
+
+// surroundTexts places open before the first and close after the last non-whitespace text of
+// texts, keeping any surrounding whitespace outside of the markers
+func surroundTexts(texts []*html.Node, open, close string) {
+	for len(texts) > 0 && strings.TrimSpace(texts[0].Data) == "" {
+		texts = texts[1:]
+	}
+	for len(texts) > 0 && strings.TrimSpace(texts[len(texts)-1].Data) == "" {
+		texts = texts[:len(texts)-1]
+	}
+	if len(texts) == 0 {
+		return
+	}
+
+	first, last := texts[0], texts[len(texts)-1]
+	trimmed := strings.TrimLeft(first.Data, " \t\r\n")
+	first.Data = first.Data[:len(first.Data)-len(trimmed)] + open + trimmed
+	trimmed = strings.TrimRight(last.Data, " \t\r\n")
+	last.Data = trimmed + close + last.Data[len(trimmed):]
+}
@@ -0,0 +1,54 @@
+package textplain
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// EmptyBlockPolicy sets how blocks with no visible content, such as the `<p>&nbsp;</p>`
+// spacers of email templates, are placed in the text version
+type EmptyBlockPolicy int
+
+const (
+	// KeepEmptyBlocks converts empty blocks like any other, so that each leaves a line of its
+	// own. This is the default
+	KeepEmptyBlocks EmptyBlockPolicy = iota
+	// DropEmptyBlocks removes blocks holding nothing but whitespace, no-break spaces and line
+	// breaks, so that they leave no trace in the text
+	DropEmptyBlocks
+)
+
+// blankElements are the inline elements that add nothing to the text of an empty block. Any
+// other element, such as an image or a link, is taken to be content
+var blankElements = map[string]bool{
+	"b": true, "big": true, "br": true, "em": true, "font": true, "i": true, "o:p": true, "s": true,
+	"small": true, "span": true, "strong": true, "sub": true, "sup": true, "u": true, "wbr": true,
+}
+
+// dropEmptyBlocks removes the blocks below n that have no visible content. Table cells and list
+// items are kept, as removing them would shift the cells or items that follow
+func dropEmptyBlocks(n *html.Node) {
+	removeMatching(n, func(c *html.Node) bool {
+		return blockElements[c.Data] && c.Data != "hr" && !isTablePart(c) && isBlank(c)
+	})
+}
+
+// isBlank reports whether n holds nothing but whitespace, no-break spaces and elements that
+// are blank themselves
+func isBlank(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			if strings.TrimFunc(c.Data, unicode.IsSpace) != "" {
+				return false
+			}
+		case html.ElementNode:
+			if c.Data == "hr" || !blankElements[c.Data] && !blockElements[c.Data] || !isBlank(c) {
+				return false
+			}
+		}
+	}
+	return true
+}
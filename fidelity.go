@@ -0,0 +1,141 @@
+package textplain
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// FidelityReport describes how closely a text part matches the html part it accompanies
+type FidelityReport struct {
+	// Similarity is the proportion of content, by word count, that is shared by both parts,
+	// ranging from 0 (nothing in common) to 1 (equivalent)
+	Similarity float64
+	// MissingParagraphs are paragraphs of the html that don't appear in the text
+	MissingParagraphs []string
+	// MissingLinks are link targets of the html that don't appear in the text
+	MissingLinks []string
+	// ExtraContent are paragraphs of the text that don't appear in the html
+	ExtraContent []string
+}
+
+// CompareText validates a text part, eg. one supplied by a third party, against the text
+// this package generates for the html part it accompanies. Comparison ignores wrapping,
+// case, punctuation and decoration such as heading underlines
+func CompareText(document, text string) (*FidelityReport, error) {
+	generated, err := NewTreeConverter(WithLinkMode(LinkTextOnly)).Convert(document, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	expected, actual := paragraphs(generated), paragraphs(text)
+	expectedText, actualText := normalizeWords(generated), normalizeWords(text)
+
+	report := &FidelityReport{}
+	var shared, total int
+	for _, p := range expected {
+		words := normalizeWords(p)
+		count := len(strings.Fields(words))
+		total += count
+		if strings.Contains(actualText, words) {
+			shared += count
+		} else {
+			report.MissingParagraphs = append(report.MissingParagraphs, p)
+		}
+	}
+	for _, p := range actual {
+		words := normalizeWords(p)
+		count := len(strings.Fields(words))
+		total += count
+		if strings.Contains(expectedText, words) {
+			shared += count
+		} else {
+			report.ExtraContent = append(report.ExtraContent, p)
+		}
+	}
+
+	if total > 0 {
+		report.Similarity = float64(shared) / float64(total)
+	} else {
+		report.Similarity = 1
+	}
+
+	links, err := linkTargets(document)
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		if !strings.Contains(text, link) {
+			report.MissingLinks = append(report.MissingLinks, link)
+		}
+	}
+
+	return report, nil
+}
+
+// paragraphs splits text on blank lines, dropping any paragraph without words
+func paragraphs(text string) []string {
+	var paras []string
+	for _, p := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n\n") {
+		if p = strings.TrimSpace(p); normalizeWords(p) != "" {
+			paras = append(paras, p)
+		}
+	}
+	return paras
+}
+
+// normalizeWords reduces text to its lowercased words separated by single spaces, dropping
+// punctuation and any urls
+func normalizeWords(text string) string {
+	var words []string
+	for _, field := range strings.Fields(text) {
+		field = strings.Trim(field, "()<>[]")
+		if strings.Contains(field, "://") || strings.HasPrefix(field, "mailto:") {
+			continue
+		}
+
+		word := strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToLower(r)
+			}
+			return -1
+		}, field)
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// linkTargets returns the distinct http(s) and mailto link targets of a document
+func linkTargets(document string) ([]string, error) {
+	root, err := parse(document, maxNestingDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	var links []string
+	seen := make(map[string]bool)
+	var find func(*html.Node)
+	find = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.A {
+				href := strings.TrimSpace(getAttr(c, "href"))
+				lower := strings.ToLower(href)
+				if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "mailto:") {
+					href = strings.TrimPrefix(href, "mailto:")
+					if !seen[href] {
+						seen[href] = true
+						links = append(links, href)
+					}
+				}
+			}
+			find(c)
+		}
+	}
+	find(root)
+
+	return links, nil
+}
@@ -0,0 +1,31 @@
+package textplain_test
+
+import (
+	"testing"
+
+	"github.com/mailproto/textplain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareText(t *testing.T) {
+	document := `<h1>Summer sale</h1>
+		<p>Everything is half price this weekend only.</p>
+		<p><a href="https://example.com/shop">Shop now</a></p>
+		<p>Questions? Contact <a href="mailto:help@example.com">help@example.com</a></p>`
+
+	t.Run("faithful text part", func(tt *testing.T) {
+		report, err := textplain.CompareText(document, "SUMMER SALE\n===========\n\nEverything is half price\nthis weekend only.\n\n"+
+			"Shop now: https://example.com/shop\n\nQuestions? Contact help@example.com")
+		assert.Nil(tt, err)
+		assert.Equal(tt, &textplain.FidelityReport{Similarity: 1}, report)
+	})
+
+	t.Run("divergent text part", func(tt *testing.T) {
+		report, err := textplain.CompareText(document, "Summer sale\n\nWin a free cruise today!\n\nShop now")
+		assert.Nil(tt, err)
+		assert.Equal(tt, []string{"Everything is half price this weekend only.", "Questions? Contact help@example.com"}, report.MissingParagraphs)
+		assert.Equal(tt, []string{"https://example.com/shop", "help@example.com"}, report.MissingLinks)
+		assert.Equal(tt, []string{"Win a free cruise today!"}, report.ExtraContent)
+		assert.InDelta(tt, 0.35, report.Similarity, 0.01)
+	})
+}
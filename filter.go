@@ -0,0 +1,175 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// applyFilters removes the parts of the document that have been excluded from the text
+// version through options, or that fall outside of the content it has been restricted to.
+// Hidden elements are removed unless they are one of the exempt nodes
+func (o *options) applyFilters(body *html.Node, exempt []*html.Node) {
+	if !o.keepHidden {
+		removeMatching(body, func(n *html.Node) bool {
+			for _, e := range exempt {
+				if n == e {
+					return false
+				}
+			}
+			return isHidden(n)
+		})
+		dropInvisibleText(body, false)
+	}
+
+	if len(o.skipClasses) > 0 {
+		removeMatching(body, func(n *html.Node) bool {
+			return hasAnyClass(n, o.skipClasses)
+		})
+	}
+
+	if len(o.excludeSelectors) > 0 {
+		removeMatching(body, func(n *html.Node) bool {
+			return matchesAny(n, o.excludeSelectors)
+		})
+	}
+
+	if len(o.onlyClasses) > 0 || len(o.onlySelectors) > 0 {
+		keepOnlyMatching(body, func(n *html.Node) bool {
+			return hasAnyClass(n, o.onlyClasses) || matchesAny(n, o.onlySelectors)
+		})
+	}
+}
+
+// isHidden reports whether an element is hidden from readers of the html version, including
+// those using assistive technology
+func isHidden(n *html.Node) bool {
+	style := inlineStyle(n)
+	switch {
+	case strings.EqualFold(strings.TrimSpace(getAttr(n, "aria-hidden")), "true"):
+		return true
+	case hasAttr(n, "hidden"):
+		// the attribute hides the element whatever its value, bar an explicit display style
+		_, displayed := style["display"]
+		return !displayed || style["display"] == "none"
+	case hiddenByStyle(style):
+		return true
+	case isZeroLength(style["font-size"]):
+		// font-size:0 is also used to remove the gaps between inline-block columns, which
+		// then restore a readable size
+		return !anyDescendantStyle(n, func(d map[string]string) bool {
+			size, ok := d["font-size"]
+			return ok && !isZeroLength(size)
+		})
+	case style["visibility"] == "hidden":
+		// descendants can be made visible again, leaving the rest to dropInvisibleText
+		return !anyDescendantStyle(n, visibleAgain)
+	}
+	return false
+}
+
+// visibleAgain reports whether an inline style makes an element visible within a hidden one
+func visibleAgain(style map[string]string) bool {
+	return style["visibility"] == "visible"
+}
+
+// dropInvisibleText removes the text below n that is hidden with `visibility:hidden`, where
+// hidden is whether n itself is, keeping the descendants made visible again. Whitespace is
+// kept, so that the visible parts stay apart
+func dropInvisibleText(n *html.Node, hidden bool) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.TextNode:
+			if hidden && strings.TrimSpace(c.Data) != "" {
+				n.RemoveChild(c)
+			}
+		case html.ElementNode:
+			inner := hidden
+			switch inlineStyle(c)["visibility"] {
+			case "hidden":
+				inner = true
+			case "visible":
+				inner = false
+			}
+			if inner && !anyDescendantStyle(c, visibleAgain) {
+				n.RemoveChild(c)
+			} else {
+				dropInvisibleText(c, inner)
+			}
+		}
+		c = next
+	}
+}
+
+// anyDescendantStyle reports whether the inline style of any element below n matches
+func anyDescendantStyle(n *html.Node, match func(map[string]string) bool) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if hasAttr(c, "style") && match(inlineStyle(c)) {
+			return true
+		}
+		if anyDescendantStyle(c, match) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeMatching drops every element below n for which match returns true
+func removeMatching(n *html.Node, match func(*html.Node) bool) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.ElementNode && match(c) {
+			n.RemoveChild(c)
+		} else {
+			removeMatching(c, match)
+		}
+		c = next
+	}
+}
+
+// keepOnlyMatching replaces the contents of body with the outermost elements for which
+// match returns true, in document order
+func keepOnlyMatching(body *html.Node, match func(*html.Node) bool) {
+	var matched []*html.Node
+	var find func(*html.Node)
+	find = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && match(c) {
+				matched = append(matched, c)
+			} else {
+				find(c)
+			}
+		}
+	}
+	find(body)
+
+	for c := body.FirstChild; c != nil; c = body.FirstChild {
+		body.RemoveChild(c)
+	}
+	for i, n := range matched {
+		if n.Parent != nil {
+			n.Parent.RemoveChild(n)
+		}
+		if i > 0 {
+			// keep the sections apart now that they're siblings
+			body.AppendChild(&html.Node{Type: html.TextNode, Data: "\n\n"})
+		}
+		body.AppendChild(n)
+	}
+}
+
+// hasAnyClass reports whether n carries at least one of the supplied classes
+func hasAnyClass(n *html.Node, classes []string) bool {
+	for _, class := range strings.Fields(getAttr(n, "class")) {
+		for _, c := range classes {
+			if class == c {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// flattenWrappers collapses the purely structural wrappers that email builders nest content
+// within, so that the block structure reflects visual sections rather than markup depth.
+// Single-cell layout tables become plain divs, and wrapper divs around a single block are
+// replaced by that block. Attributes of any removed wrapper are merged into its replacement
+// so that styles, classes and other inherited state survive
+func flattenWrappers(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		flattenWrappers(c)
+
+		switch c.DataAtom {
+		case atom.Table:
+			if chain := singleCell(c); chain != nil {
+				c = replaceLayoutTable(c, chain)
+			}
+		case atom.Div, atom.Center:
+			if child := onlyElementChild(c); child != nil && blockElements[child.Data] && !isTablePart(child) {
+				mergeAttrs(child, c)
+				c.RemoveChild(child)
+				n.InsertBefore(child, c)
+				n.RemoveChild(c)
+				c = child
+			}
+		}
+	}
+}
+
+// singleCell returns the chain of elements from the table down to its cell when the table
+// consists of exactly one row with one cell
+func singleCell(table *html.Node) []*html.Node {
+	var chain []*html.Node
+	var row *html.Node
+	for c := table.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.DataAtom == atom.Tbody || c.DataAtom == atom.Thead || c.DataAtom == atom.Tfoot:
+			for r := c.FirstChild; r != nil; r = r.NextSibling {
+				if r.DataAtom == atom.Tr {
+					if row != nil {
+						return nil
+					}
+					row, chain = r, []*html.Node{c}
+				} else if significant(r) {
+					return nil
+				}
+			}
+		case c.DataAtom == atom.Tr:
+			if row != nil {
+				return nil
+			}
+			row, chain = c, nil
+		case significant(c):
+			return nil
+		}
+	}
+	if row == nil {
+		return nil
+	}
+
+	cell := onlyElementChild(row)
+	if cell == nil || (cell.DataAtom != atom.Td && cell.DataAtom != atom.Th) {
+		return nil
+	}
+	return append(append([]*html.Node{table}, chain...), row, cell)
+}
+
+// replaceLayoutTable swaps a single-cell table for a div holding the cell's contents
+func replaceLayoutTable(table *html.Node, chain []*html.Node) *html.Node {
+	div := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	for i := len(chain) - 1; i >= 0; i-- {
+		mergeAttrs(div, chain[i])
+	}
+
+	cell := chain[len(chain)-1]
+	for c := cell.FirstChild; c != nil; c = cell.FirstChild {
+		cell.RemoveChild(c)
+		div.AppendChild(c)
+	}
+
+	table.Parent.InsertBefore(div, table)
+	table.Parent.RemoveChild(table)
+	return div
+}
+
+// onlyElementChild returns the single element child of n, provided n has no other
+// significant content
+func onlyElementChild(n *html.Node) *html.Node {
+	var only *html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			if only != nil {
+				return nil
+			}
+			only = c
+		} else if significant(c) {
+			return nil
+		}
+	}
+	return only
+}
+
+// significant reports whether a node contributes anything beyond whitespace and comments
+func significant(n *html.Node) bool {
+	switch n.Type {
+	case html.CommentNode:
+		return false
+	case html.TextNode:
+		return strings.TrimSpace(n.Data) != ""
+	}
+	return true
+}
+
+func isTablePart(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Tbody, atom.Thead, atom.Tfoot, atom.Tr, atom.Td, atom.Th, atom.Li, atom.Dt, atom.Dd:
+		return true
+	}
+	return false
+}
+
+// mergeAttrs folds the attributes of an outer wrapper into dst. Classes and styles are
+// combined, with the inner declarations taking precedence, other attributes are only
+// copied when dst doesn't already define them
+func mergeAttrs(dst, wrapper *html.Node) {
+	for _, attr := range wrapper.Attr {
+		idx := -1
+		for i, existing := range dst.Attr {
+			if existing.Key == attr.Key {
+				idx = i
+				break
+			}
+		}
+		switch {
+		case idx < 0:
+			dst.Attr = append(dst.Attr, attr)
+		case attr.Key == "class":
+			dst.Attr[idx].Val = attr.Val + " " + dst.Attr[idx].Val
+		case attr.Key == "style":
+			dst.Attr[idx].Val = attr.Val + ";" + dst.Attr[idx].Val
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package textplain
+
+import "strings"
+
+// typographyFolder replaces typographic punctuation and spaces with their plain ASCII
+// equivalents
+var typographyFolder = strings.NewReplacer(
+	// quotes and primes
+	"\u2018", "'", "\u2019", "'", "\u201a", "'", "\u201b", "'", "\u2032", "'",
+	"\u201c", "\"", "\u201d", "\"", "\u201e", "\"", "\u201f", "\"", "\u2033", "\"",
+	"\u00ab", "\"", "\u00bb", "\"", "\u2039", "'", "\u203a", "'",
+
+	// hyphens and dashes
+	"\u2010", "-", "\u2011", "-", "\u2012", "-", "\u2013", "-", "\u2212", "-",
+	"\u2014", "--", "\u2015", "--",
+
+	// ellipsis
+	"\u2026", "...",
+
+	// spaces
+	"\u00a0", " ", "\u2000", " ", "\u2001", " ", "\u2002", " ", "\u2003", " ", "\u2004", " ",
+	"\u2005", " ", "\u2006", " ", "\u2007", " ", "\u2008", " ", "\u2009", " ", "\u200a", " ",
+	"\u202f", " ", "\u205f", " ", "\u3000", " ",
+)
+
+// foldTypography replaces smart quotes, dashes, ellipses and non-ASCII spaces in text with
+// their ASCII equivalents
+func foldTypography(text string) string {
+	return typographyFolder.Replace(text)
+}
@@ -0,0 +1,94 @@
+package textplain
+
+import (
+	"sort"
+	"unicode"
+)
+
+const zeroWidthJoiner = '\u200d'
+
+// graphemes splits text into user-perceived characters, approximating Unicode grapheme
+// cluster segmentation: combining marks, variation selectors, emoji modifiers and tags attach
+// to the preceding character, zero width joiners join emoji sequences and regional indicators
+// pair up into flags
+func graphemes(text string) []string {
+	var clusters []string
+	var start, regional int
+	var prev rune = -1
+	for i, r := range text {
+		if prev >= 0 && !extendsCluster(prev, r, regional) {
+			clusters = append(clusters, text[start:i])
+			start = i
+			regional = 0
+		}
+		if isRegionalIndicator(r) {
+			regional++
+		}
+		prev = r
+	}
+	if start < len(text) {
+		clusters = append(clusters, text[start:])
+	}
+	return clusters
+}
+
+// textWidth returns the number of user-perceived characters in text, ignoring any no-wrap
+// delimiters
+func textWidth(text string) int {
+	return len(graphemes(stripNoWrap(text)))
+}
+
+// extendsCluster reports whether r continues the cluster ending in prev, which holds the
+// given number of regional indicators
+func extendsCluster(prev, r rune, regional int) bool {
+	switch {
+	case prev == '\r' && r == '\n':
+		return true
+	case prev == '\r', prev == '\n', r == '\r', r == '\n':
+		return false
+	case r == zeroWidthJoiner, prev == zeroWidthJoiner:
+		return true
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case r >= 0xfe00 && r <= 0xfe0f, r >= 0xe0100 && r <= 0xe01ef: // variation selectors
+		return true
+	case r >= 0x1f3fb && r <= 0x1f3ff: // emoji skin tone modifiers
+		return true
+	case r >= 0xe0020 && r <= 0xe007f: // tags, as used by subdivision flags
+		return true
+	case isRegionalIndicator(prev) && isRegionalIndicator(r):
+		return regional%2 == 1
+	}
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// graphemeSpans converts spans of byte offsets within text to offsets into its clusters,
+// widening any span that starts or ends within a cluster to include all of it
+func graphemeSpans(clusters []string, spans [][2]int) [][2]int {
+	if len(spans) == 0 {
+		return nil
+	}
+	starts := clusterOffsets(clusters)[:len(clusters)]
+	converted := make([][2]int, len(spans))
+	for i, span := range spans {
+		start := sort.SearchInts(starts, span[0]+1) - 1
+		end := sort.SearchInts(starts, span[1])
+		converted[i] = [2]int{start, end}
+	}
+	return converted
+}
+
+// clusterOffsets returns the byte offset of each of the clusters split from a text, followed by
+// the length of the text, so that any run of clusters can be sliced from the text rather than
+// joined back together
+func clusterOffsets(clusters []string) []int {
+	offsets := make([]int, len(clusters)+1)
+	for i, cluster := range clusters {
+		offsets[i+1] = offsets[i] + len(cluster)
+	}
+	return offsets
+}
@@ -0,0 +1,78 @@
+package textplain
+
+import "strings"
+
+// HeadingStyle sets how the headings of a level are decorated
+type HeadingStyle struct {
+	// Prefix starts the heading text, eg. `## ` for Markdown style headings. The lines of a
+	// prefixed heading are joined into one
+	Prefix string
+
+	// Rule is the character repeated across the width of the heading text to underline it, eg.
+	// `-`. Headings without a rule are left undecorated
+	Rule string
+
+	// Boxed places the rule above the heading text as well as below it
+	Boxed bool
+}
+
+// DefaultHeadingStyles returns the styles of the h1 to h6 headings: h1 boxed in `*` and h2 in
+// `-`, while h3 to h6 are underlined with `-`, `~`, `^` and `.` respectively, so that each level
+// of the hierarchy remains recognisable
+func DefaultHeadingStyles() [6]HeadingStyle {
+	return [6]HeadingStyle{
+		{Rule: "*", Boxed: true},
+		{Rule: "-", Boxed: true},
+		{Rule: "-"},
+		{Rule: "~"},
+		{Rule: "^"},
+		{Rule: "."},
+	}
+}
+
+// ATXHeadingStyles returns the styles of Markdown's ATX headings, which start the heading
+// text with as many `#` as its level, eg. `## Section`
+func ATXHeadingStyles() [6]HeadingStyle {
+	var styles [6]HeadingStyle
+	for i := range styles {
+		styles[i].Prefix = strings.Repeat("#", i+1) + " "
+	}
+	return styles
+}
+
+// formatHeading decorates the text of a heading of the given level, from 1 to 6, in the
+// configured style
+func (o *options) formatHeading(level int, text string) string {
+	if level > len(o.headingStyles) {
+		level = len(o.headingStyles)
+	}
+	style := o.headingStyles[level-1]
+	if style.Prefix != "" {
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			lines[i] = strings.Trim(line, " \t")
+		}
+		text = style.Prefix + strings.Join(lines, " ")
+	}
+	if style.Rule == "" {
+		return text
+	}
+
+	var width int
+	for _, line := range strings.Split(text, "\n") {
+		if l := textWidth(strings.TrimSpace(line)); l > width {
+			width = l
+		}
+	}
+	rule := firstWidthMarker(text) + strings.Repeat(style.Rule, width)
+
+	if style.Boxed {
+		return rule + "\n" + text + "\n" + rule
+	}
+	return text + "\n" + rule
+}
+
+// headingLevel returns the level of a `<h1>` to `<h6>` element from its name
+func headingLevel(name string) int {
+	return int(name[1] - '0')
+}
@@ -0,0 +1,87 @@
+package textplain
+
+import "strings"
+
+// Ignore markers delimit html that should be left out of the text version entirely, useful
+// for removing headers and footers that aren't needed
+const (
+	ignoreStart = "start text/html"
+	ignoreEnd   = "end text/html"
+)
+
+// stripIgnored removes every `<!-- start text/html -->` ... `<!-- end text/html -->` region
+// from the document. Regions may be nested, in which case the outermost region is removed,
+// end markers without a matching start are dropped, and a start marker that is never closed
+// strips nothing
+func stripIgnored(document string) string {
+	if !strings.Contains(document, ignoreStart) {
+		return document
+	}
+
+	var out strings.Builder
+	var depth, last, regionStart, offset int
+	for {
+		start, end, marker := nextComment(document, offset)
+		if start < 0 {
+			break
+		}
+		offset = end
+
+		switch marker {
+		case ignoreStart:
+			if depth == 0 {
+				regionStart = start
+			}
+			depth++
+		case ignoreEnd:
+			switch depth {
+			case 0:
+				// unbalanced end marker
+				out.WriteString(document[last:start])
+				last = end
+			case 1:
+				out.WriteString(document[last:regionStart])
+				last = end
+				depth = 0
+			default:
+				depth--
+			}
+		}
+	}
+
+	out.WriteString(document[last:])
+	return out.String()
+}
+
+// nextComment finds the first comment at or after offset, returning its start and end offsets
+// along with its trimmed content. Comments are read as html reads them: they don't nest, they
+// end at the first `-->` or `--!>`, `<!-->` and `<!--->` are empty, and one that is never
+// closed runs to the end of the document
+func nextComment(document string, offset int) (int, int, string) {
+	start := strings.Index(document[offset:], "<!--")
+	if start < 0 {
+		return -1, -1, ""
+	}
+	start += offset
+
+	content := start + len("<!--")
+	for _, abrupt := range []string{">", "->"} {
+		if strings.HasPrefix(document[content:], abrupt) {
+			return start, content + len(abrupt), ""
+		}
+	}
+
+	for i := content; ; {
+		idx := strings.Index(document[i:], "--")
+		if idx < 0 {
+			return start, len(document), strings.TrimSpace(document[content:])
+		}
+		end := i + idx
+		for _, closing := range []string{"-->", "--!>"} {
+			if strings.HasPrefix(document[end:], closing) {
+				return start, end + len(closing), strings.TrimSpace(document[content:end])
+			}
+		}
+		i = end + 1
+	}
+}
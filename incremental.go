@@ -0,0 +1,169 @@
+package textplain
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// flushLength is the amount of text ConvertTo renders before writing out the blocks that are
+// settled
+const flushLength = 32 << 10
+
+// ConvertTo converts document with the default TreeConverter, writing the text to w as it goes
+func ConvertTo(w io.Writer, document string, lineLength int) error {
+	defaultConverterOnce.Do(func() {
+		defaultConverter = NewTreeConverter()
+	})
+	return defaultConverter.(*TreeConverter).ConvertTo(w, document, lineLength)
+}
+
+// ConvertTo writes the same text as Convert to w, but writes it out block by block as the body
+// is walked rather than building up the whole text first, so that the memory needed for the
+// text of a very large document stays bounded. Blocks are only written once the block after
+// them is known, as the spacing between the two depends on both
+func (t *TreeConverter) ConvertTo(w io.Writer, document string, lineLength int) error {
+	budget := t.startBudget()
+
+	d, err := t.parse(document, budget)
+	if err != nil {
+		return err
+	}
+
+	out := incrementalWriter{
+		TreeConverter: t,
+		conv:          d.conv,
+		w:             w,
+		lineLength:    lineLength,
+		spacing:       spacer{options: &t.options},
+	}
+
+	var b bytes.Buffer
+	for c := d.body.FirstChild; c != nil; c = c.NextSibling {
+		if err := t.convertNode(&b, 0, c); err != nil {
+			return err
+		}
+		if rendered := b.Len(); rendered >= flushLength {
+			if err := out.flush(&b); err != nil {
+				return err
+			}
+			// the text converted so far has already been written
+			if err := budget.check("convert", rendered-b.Len(), nil); err != nil {
+				return err
+			}
+		}
+	}
+	rendered := b.Len()
+	if err := out.close(&b); err != nil {
+		return err
+	}
+	budget.record("convert", rendered)
+	return nil
+}
+
+// incrementalWriter takes the text rendered by ConvertTo through the rest of the conversion
+type incrementalWriter struct {
+	*TreeConverter
+	conv       *conversion
+	w          io.Writer
+	lineLength int
+
+	spacing spacer
+	tidied  strings.Builder
+	started bool
+}
+
+// flush takes the text rendered to b, bar the whitespace it ends in, through to w. The
+// whitespace is kept, as the rendering of the blocks that follow depends on it
+func (iw *incrementalWriter) flush(b *bytes.Buffer) error {
+	rendered := b.Bytes()
+	settled := len(bytes.TrimRight(rendered, " \t\n"))
+	if !bytes.Contains(rendered[settled:], []byte("\n")) {
+		return nil
+	}
+	iw.spacing.write(&iw.tidied, string(b.Next(settled)))
+
+	text := iw.tidied.String()
+	cut := settledLines(text)
+	if cut <= 0 {
+		return nil
+	}
+	iw.tidied.Reset()
+	iw.tidied.WriteString(text[cut:])
+	return iw.write(text[:cut], false)
+}
+
+// close takes the rest of the text rendered to b through to w
+func (iw *incrementalWriter) close(b *bytes.Buffer) error {
+	iw.spacing.write(&iw.tidied, b.String())
+	iw.spacing.end(&iw.tidied)
+	return iw.write(iw.tidied.String(), true)
+}
+
+// write converts a part of the tidied text, made up of whole lines, and writes it to w. Parts
+// after the first start with the line break separating them from the part before
+func (iw *incrementalWriter) write(text string, last bool) error {
+	text = joinControlBlocks(text)
+
+	var separator string
+	if iw.started {
+		trimmed := strings.TrimLeft(text, "\n")
+		separator, text = text[:len(text)-len(trimmed)], trimmed
+		if iw.conv.paragraphSeparator != "" && len(separator) > 1 {
+			separator = iw.conv.paragraphSeparator
+		}
+	} else if !iw.keepSurrounding {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+	}
+	if last && !iw.keepSurrounding {
+		text = strings.TrimRightFunc(text, unicode.IsSpace)
+		if text == "" {
+			separator = ""
+		}
+	}
+	iw.started = true
+
+	wrapped := iw.wrapText(text, iw.lineLength)
+	if !iw.wrapping.hard {
+		wrapped = fixWrappedOpenBraces(wrapped)
+		wrapped = strings.Replace(wrapped, "\n)", " )\n", -1)
+	}
+
+	_, err := io.WriteString(iw.w, separator+iw.conv.finishPart(wrapped, last))
+	return err
+}
+
+// settledLines returns the offset of the last line break in text that separates two lines
+// independently of each other, or zero if there is none. Lines are dependent where template
+// control tags join them, or where a brace would be moved from one to the other
+func settledLines(text string) int {
+	for end := len(text); end > 0; {
+		idx := strings.LastIndexByte(text[:end], '\n')
+		if idx < 0 {
+			return 0
+		}
+		start := len(strings.TrimRight(text[:idx], "\n"))
+		next := strings.TrimLeft(text[idx:], "\n")
+		end = start
+		if start == 0 || next == "" {
+			continue
+		}
+
+		previous := text[strings.LastIndexByte(text[:start], '\n')+1 : start]
+		if idx := strings.IndexByte(next, '\n'); idx >= 0 {
+			next = next[:idx]
+		}
+		if _, ok := controlTags(strings.TrimSpace(previous)); ok {
+			continue
+		}
+		if _, ok := controlTags(strings.TrimSpace(next)); ok {
+			continue
+		}
+		if strings.HasSuffix(previous, "(") || strings.HasPrefix(next, ")") {
+			continue
+		}
+		return start
+	}
+	return 0
+}
@@ -0,0 +1,92 @@
+package textplain
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// stripInvisible removes the control characters, zero width characters and bidi embeddings or
+// overrides from the document's text and image alt text. They're invisible to readers of the
+// html version, but pad out preheaders and are occasionally used to disguise text. Zero width
+// joiners and non-joiners are kept between visible characters, where they shape emoji
+// sequences and the words of some scripts. Bidi formatting required by `dir` attributes is
+// added back afterwards
+func stripInvisible(n *html.Node) {
+	rewriteText(n, removeInvisible)
+}
+
+// rewriteText replaces the text and image alt text below n with the result of rewrite
+func rewriteText(n *html.Node, rewrite func(string) string) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			c.Data = rewrite(c.Data)
+		case html.ElementNode:
+			for i, a := range c.Attr {
+				if a.Key == "alt" {
+					c.Attr[i].Val = rewrite(a.Val)
+				}
+			}
+			rewriteText(c, rewrite)
+		}
+	}
+}
+
+// removeInvisible removes invisible characters from text, see stripInvisible
+func removeInvisible(text string) string {
+	if !hasInvisible(text) {
+		return text
+	}
+
+	var b strings.Builder
+	b.Grow(len(text))
+	var prev rune
+	for i, r := range text {
+		switch {
+		case r == '\u200c' || r == '\u200d':
+			next, _ := utf8.DecodeRuneInString(text[i+utf8.RuneLen(r):])
+			if !isVisible(prev) || !isVisible(next) {
+				continue
+			}
+		case isInvisible(r):
+			continue
+		}
+		b.WriteRune(r)
+		prev = r
+	}
+	return b.String()
+}
+
+func hasInvisible(text string) bool {
+	for _, r := range text {
+		if isInvisible(r) || r == '\u200c' || r == '\u200d' {
+			return true
+		}
+	}
+	return false
+}
+
+// isInvisible reports whether r is always removed: C0 and C1 controls other than whitespace,
+// zero width spaces and joiners, soft hyphens and explicit bidi embeddings, overrides and
+// isolates
+func isInvisible(r rune) bool {
+	switch {
+	case r == '\t', r == '\n', r == '\r', r == '\f':
+		return false
+	case r < 0x20, r >= 0x7f && r <= 0x9f:
+		return true
+	case r == '\u200b', r == '\u2060', r == '\ufeff', r == '\u034f', r == '\u00ad':
+		return true
+	case r >= '\u202a' && r <= '\u202e', r >= '\u2066' && r <= '\u2069':
+		return true
+	}
+	return false
+}
+
+// isVisible reports whether r is a character that a joiner may attach to
+func isVisible(r rune) bool {
+	return r != 0 && r != utf8.RuneError && !unicode.IsSpace(r) && !isInvisible(r) && r != '\u200c' && r != '\u200d'
+}
@@ -0,0 +1,117 @@
+package textplain
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kinsoku shori rules for Japanese (and more generally CJK) line breaking
+const (
+	// noLineStart holds the closing punctuation, small kana and marks that may not begin a line
+	noLineStart = ")]}、。，．）］｝」』】〕〉》〗〙”’" +
+		"・ー…‥！？：；!?:;,." +
+		"ぁぃぅぇぉっゃゅょゎゕゖ" +
+		"ァィゥェォッャュョヮヵヶゝゞヽヾ々"
+
+	// noLineEnd holds the opening punctuation that may not end a line
+	noLineEnd = "([{（［｛「『【〔〈《〖〘“‘"
+)
+
+// cjkBreak reports whether a line may break between the clusters before and after, which is
+// allowed between CJK characters, where words aren't separated by spaces, unless it would
+// start a line with closing punctuation or end one with opening punctuation. Hangul is left
+// out as Korean separates words with spaces
+func cjkBreak(before, after string) bool {
+	if !isCJK(before) && !isCJK(after) {
+		return false
+	}
+	return !strings.Contains(noLineStart, after) && !strings.Contains(noLineEnd, before)
+}
+
+// isCJK reports whether a cluster is a Chinese or Japanese character or punctuation mark
+func isCJK(cluster string) bool {
+	r, _ := utf8.DecodeRuneInString(cluster)
+	switch {
+	case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana):
+		return true
+	case r >= 0x3000 && r <= 0x303f, r >= 0xff00 && r <= 0xffef:
+		return true
+	}
+	return false
+}
+
+// keepTogether marks each character of text within noStart as a no-wrap region along with the
+// character before it, and each within noEnd along with the character after it, so that
+// wrapping never breaks the line between the two, even where they're separated by spaces. The
+// regions already marked, such as URLs, and merge tags are left as they are
+func keepTogether(text, noStart, noEnd string) string {
+	skip := append(mergeTagSpans(text), markedSpans(text)...)
+	skipped := func(offset int) bool {
+		for _, span := range skip {
+			if offset >= span[0] && offset < span[1] {
+				return true
+			}
+		}
+		return false
+	}
+
+	// the [start, end) offsets of the characters to keep together, in order
+	var joins [][2]int
+	for i, r := range text {
+		if skipped(i) {
+			continue
+		}
+		end := i + utf8.RuneLen(r)
+		if strings.ContainsRune(noStart, r) {
+			if before := strings.TrimRight(text[:i], " \t\r\n\f"); before != "" && !skipped(len(before)-1) {
+				_, size := utf8.DecodeLastRuneInString(before)
+				joins = append(joins, [2]int{len(before) - size, end})
+			}
+		}
+		if strings.ContainsRune(noEnd, r) {
+			if after := strings.TrimLeft(text[end:], " \t\r\n\f"); after != "" && !skipped(len(text)-len(after)) {
+				_, size := utf8.DecodeRuneInString(after)
+				joins = append(joins, [2]int{i, len(text) - len(after) + size})
+			}
+		}
+	}
+	if len(joins) == 0 {
+		return text
+	}
+
+	var b strings.Builder
+	var last int
+	for i := 0; i < len(joins); {
+		start, end := joins[i][0], joins[i][1]
+		for i++; i < len(joins) && joins[i][0] < end; i++ {
+			if joins[i][1] > end {
+				end = joins[i][1]
+			}
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(NoWrap(text[start:end]))
+		last = end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// markedSpans returns the [start, end) offsets of the no-wrap regions of text, delimiters
+// included
+func markedSpans(text string) [][2]int {
+	var spans [][2]int
+	for i := 0; ; {
+		start := strings.Index(text[i:], NoWrapStart)
+		if start < 0 {
+			return spans
+		}
+		start += i
+		end := strings.Index(text[start:], NoWrapEnd)
+		if end < 0 {
+			return append(spans, [2]int{start, len(text)})
+		}
+		i = start + end + len(NoWrapEnd)
+		spans = append(spans, [2]int{start, i})
+	}
+}
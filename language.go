@@ -0,0 +1,147 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// LanguageRules customizes the text produced for the regions of a document written in a
+// given language, as declared through `lang` attributes
+type LanguageRules struct {
+	// Quotes are the opening and closing characters placed around inline `<q>` quotations,
+	// eg. `„` and `“` for German
+	Quotes [2]string
+
+	// Transform, if set, rewrites the text of every text node within the region before it is
+	// converted, eg. to join French punctuation to the preceding word with a no-break space so
+	// that wrapping never separates the two
+	Transform func(text string) string
+
+	// ListMarker, if set, replaces the `* ` bullet of the unordered list items within the
+	// region, eg. `• ` or `- `
+	ListMarker string
+
+	// ListNumberSuffix, if set, replaces the `. ` following the numbers of the ordered list items
+	// within the region that are numbered with WithOrderedLists, eg. `) ` or `、`
+	ListNumberSuffix string
+
+	// NoLineStart and NoLineEnd hold characters that may not start and end a line respectively
+	// within the region, beyond the CJK punctuation wrapping always keeps in place, eg. `!?:;»`
+	// and `«` for French, whose punctuation is set apart by spaces. Wrapping keeps each of them
+	// on a line with the character next to it
+	NoLineStart string
+	NoLineEnd   string
+}
+
+// WithLanguageRules applies rules to the regions of a document whose language matches lang.
+// Languages match by prefix, so rules for `de` also apply to `de-CH`, while the rules for the
+// longest matching tag take precedence
+func WithLanguageRules(lang string, rules LanguageRules) Option {
+	return func(o *options) {
+		if o.languageRules == nil {
+			o.languageRules = make(map[string]LanguageRules)
+		}
+		o.languageRules[strings.ToLower(lang)] = rules
+		if rules.Transform != nil {
+			o.functions = nextFunctions()
+		}
+	}
+}
+
+// applyLanguageRules applies the configured language rules to the text below n, where lang
+// is the language in effect for n
+func (o *options) applyLanguageRules(n *html.Node, lang string) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			if rules, ok := o.rulesFor(lang); ok && rules.Transform != nil {
+				c.Data = rules.Transform(c.Data)
+			}
+		case html.ElementNode:
+			inner := lang
+			if declared, ok := declaredLanguage(c); ok {
+				inner = declared
+			}
+			o.applyLanguageRules(c, inner)
+
+			rules, ok := o.rulesFor(inner)
+			if !ok {
+				continue
+			}
+			if c.DataAtom == atom.Q && rules.Quotes != [2]string{} {
+				surroundText(c, rules.Quotes[0], rules.Quotes[1])
+			}
+
+			// the language of lists is declared on them, for the RegexpConverter to find it in
+			// their tags
+			switch c.DataAtom {
+			case atom.Ul, atom.Ol, atom.Li:
+				if _, declared := declaredLanguage(c); !declared && rules.ListMarker+rules.ListNumberSuffix != "" {
+					c.Attr = append(c.Attr, html.Attribute{Key: "lang", Val: inner})
+				}
+			}
+		}
+	}
+}
+
+// applyLineBreakRules keeps the characters that the language rules in effect for the text
+// below n keep from starting or ending a line on a line with the character next to them, where
+// lang is the language in effect for n
+func (o *options) applyLineBreakRules(n *html.Node, lang string) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			if rules, ok := o.rulesFor(lang); ok && rules.NoLineStart+rules.NoLineEnd != "" {
+				c.Data = keepTogether(c.Data, rules.NoLineStart, rules.NoLineEnd)
+			}
+		case html.ElementNode:
+			inner := lang
+			if declared, ok := declaredLanguage(c); ok {
+				inner = declared
+			}
+			o.applyLineBreakRules(c, inner)
+		}
+	}
+}
+
+// rulesFor returns the rules registered for the longest prefix of lang
+func (o *options) rulesFor(lang string) (LanguageRules, bool) {
+	for lang = strings.ToLower(lang); lang != ""; {
+		if rules, ok := o.languageRules[lang]; ok {
+			return rules, true
+		}
+		idx := strings.LastIndexAny(lang, "-_")
+		if idx < 0 {
+			break
+		}
+		lang = lang[:idx]
+	}
+	return LanguageRules{}, false
+}
+
+// nodeLanguage returns the language in effect for n, as declared by the nearest `lang` or
+// `xml:lang` attribute on it or its ancestors
+func nodeLanguage(n *html.Node) string {
+	for ; n != nil; n = n.Parent {
+		if lang, ok := declaredLanguage(n); ok {
+			return lang
+		}
+	}
+	return ""
+}
+
+// declaredLanguage returns the language declared on n itself. An empty declaration is valid
+// and marks the language as unknown
+func declaredLanguage(n *html.Node) (string, bool) {
+	if n.Type != html.ElementNode {
+		return "", false
+	}
+	for _, a := range n.Attr {
+		if a.Key == "lang" || a.Key == "xml:lang" {
+			return strings.TrimSpace(a.Val), true
+		}
+	}
+	return "", false
+}
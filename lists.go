@@ -0,0 +1,44 @@
+package textplain
+
+import (
+	"strconv"
+	"strings"
+)
+
+// WithOrderedLists numbers the items of ordered lists, eg. `1. First`, from the number given
+// by the list's start attribute, rather than bulleting them as it does the items of unordered
+// lists
+func WithOrderedLists() Option {
+	return func(o *options) {
+		o.orderedLists = true
+	}
+}
+
+// listStart returns the number of the first item of an ordered list from the value of its
+// start attribute
+func listStart(start string) int {
+	if n, err := strconv.Atoi(strings.TrimSpace(start)); err == nil {
+		return n
+	}
+	return 1
+}
+
+// listMarkers returns the bullet of the unordered list items and the punctuation following the
+// numbers of the ordered ones within a region in lang, as set by its LanguageRules
+func (o *options) listMarkers(lang string) (bullet, suffix string) {
+	bullet, suffix = "* ", ". "
+	if rules, ok := o.rulesFor(lang); ok {
+		if rules.ListMarker != "" {
+			bullet = rules.ListMarker
+		}
+		if rules.ListNumberSuffix != "" {
+			suffix = rules.ListNumberSuffix
+		}
+	}
+	return bullet, suffix
+}
+
+// ordered returns the prefix of the ordered list item numbered idx
+func ordered(idx int, suffix string) string {
+	return strconv.Itoa(idx) + suffix
+}
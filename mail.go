@@ -0,0 +1,90 @@
+package textplain
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// ConvertMessage finds the text/html part of the supplied message, decoding any
+// Content-Transfer-Encoding and charset, and converts it to plaintext
+func ConvertMessage(msg *mail.Message, lineLength int) (string, error) {
+	body, contentType, err := findHTMLPart(textproto.MIMEHeader(msg.Header), msg.Body)
+	if err != nil {
+		return "", err
+	}
+	return ConvertEncoded(body, contentType, lineLength)
+}
+
+// ConvertPart converts a raw MIME entity, such as a part stored by an archiving system, that
+// consists of its headers followed by a possibly quoted-printable or base64 encoded body.
+// Multipart entities are searched for their text/html part
+func ConvertPart(r io.Reader, lineLength int) (string, error) {
+	reader := bufio.NewReader(r)
+	header, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	body, contentType, err := findHTMLPart(header, reader)
+	if err != nil {
+		return "", err
+	}
+	return ConvertEncoded(body, contentType, lineLength)
+}
+
+// findHTMLPart walks a (possibly multipart) MIME entity depth-first and returns the decoded
+// body of the first inline text/html part along with its Content-Type
+func findHTMLPart(header textproto.MIMEHeader, body io.Reader) ([]byte, string, error) {
+	contentType := header.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, "", ErrNoHTMLPart
+	}
+
+	if disposition, _, _ := mime.ParseMediaType(header.Get("Content-Disposition")); disposition == "attachment" {
+		return nil, "", ErrNoHTMLPart
+	}
+
+	switch {
+	case mediaType == "text/html":
+		decoded, err := io.ReadAll(decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body))
+		return decoded, contentType, err
+	case strings.HasPrefix(mediaType, "multipart/"):
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, "", err
+			}
+
+			html, partType, err := findHTMLPart(part.Header, part)
+			if err == nil {
+				return html, partType, nil
+			} else if err != ErrNoHTMLPart {
+				return nil, "", err
+			}
+		}
+	}
+
+	return nil, "", ErrNoHTMLPart
+}
+
+// decodeTransferEncoding wraps body in a decoder for the supplied Content-Transfer-Encoding
+func decodeTransferEncoding(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	}
+	return body
+}
@@ -0,0 +1,149 @@
+package textplain_test
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/mailproto/textplain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertMessage(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		message string
+		expect  string
+		err     error
+	}{
+		{
+			name: "single part html",
+			message: "Content-Type: text/html; charset=utf-8\r\n" +
+				"\r\n" +
+				"<p>Hello</p><p>World</p>",
+			expect: "Hello\n\nWorld",
+		},
+		{
+			name: "quoted-printable multipart/alternative",
+			message: "Content-Type: multipart/alternative; boundary=abc\r\n" +
+				"\r\n" +
+				"--abc\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"\r\n" +
+				"Not this one\r\n" +
+				"--abc\r\n" +
+				"Content-Type: text/html; charset=utf-8\r\n" +
+				"Content-Transfer-Encoding: quoted-printable\r\n" +
+				"\r\n" +
+				"<p style=3D\"color: red\">Gar=C3=A7on</p>\r\n" +
+				"--abc--\r\n",
+			expect: "Garçon",
+		},
+		{
+			name: "base64 nested in multipart/mixed",
+			message: "Content-Type: multipart/mixed; boundary=outer\r\n" +
+				"\r\n" +
+				"--outer\r\n" +
+				"Content-Type: multipart/alternative; boundary=inner\r\n" +
+				"\r\n" +
+				"--inner\r\n" +
+				"Content-Type: text/html\r\n" +
+				"Content-Transfer-Encoding: base64\r\n" +
+				"\r\n" +
+				"PHA+SGVsbG88L3A+\r\n" +
+				"PHA+V29ybGQ8L3A+\r\n" +
+				"--inner--\r\n" +
+				"--outer--\r\n",
+			expect: "Hello\n\nWorld",
+		},
+		{
+			name: "latin-1 charset",
+			message: "Content-Type: text/html; charset=iso-8859-1\r\n" +
+				"Content-Transfer-Encoding: quoted-printable\r\n" +
+				"\r\n" +
+				"<p>gar=E7on</p>",
+			expect: "garçon",
+		},
+		{
+			name: "html attachments are ignored",
+			message: "Content-Type: multipart/mixed; boundary=abc\r\n" +
+				"\r\n" +
+				"--abc\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"\r\n" +
+				"Body\r\n" +
+				"--abc\r\n" +
+				"Content-Type: text/html\r\n" +
+				"Content-Disposition: attachment; filename=page.html\r\n" +
+				"\r\n" +
+				"<p>Attached</p>\r\n" +
+				"--abc--\r\n",
+			err: textplain.ErrNoHTMLPart,
+		},
+		{
+			name: "plaintext only",
+			message: "Content-Type: text/plain\r\n" +
+				"\r\n" +
+				"Hello",
+			err: textplain.ErrNoHTMLPart,
+		},
+	} {
+		t.Run(tc.name, func(tt *testing.T) {
+			msg, err := mail.ReadMessage(strings.NewReader(tc.message))
+			assert.Nil(tt, err)
+
+			result, err := textplain.ConvertMessage(msg, textplain.DefaultLineLength)
+			assert.Equal(tt, tc.err, err)
+			assert.Equal(tt, tc.expect, result)
+		})
+	}
+}
+
+func TestConvertPart(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		part   string
+		expect string
+		err    error
+	}{
+		{
+			name: "quoted-printable",
+			part: "Content-Type: text/html; charset=utf-8\r\n" +
+				"Content-Transfer-Encoding: quoted-printable\r\n" +
+				"\r\n" +
+				"<p>A long line that has been soft wrapped by the quoted-printable enc=\r\n" +
+				"oder</p>",
+			expect: "A long line that has been soft wrapped by the quoted-printable\nencoder",
+		},
+		{
+			name: "base64",
+			part: "Content-Type: text/html\r\n" +
+				"Content-Transfer-Encoding: base64\r\n" +
+				"\r\n" +
+				"PHA+SGVsbG88L3A+\r\n",
+			expect: "Hello",
+		},
+		{
+			name: "base64 latin-1",
+			part: "Content-Type: text/html; charset=iso-8859-1\r\n" +
+				"Content-Transfer-Encoding: base64\r\n" +
+				"\r\n" +
+				"PHA+Z2Fy529uPC9wPg==\r\n",
+			expect: "garçon",
+		},
+		{
+			name: "not html",
+			part: "Content-Type: image/png\r\n" +
+				"Content-Transfer-Encoding: base64\r\n" +
+				"\r\n" +
+				"iVBORw0KGgo=\r\n",
+			err: textplain.ErrNoHTMLPart,
+		},
+	} {
+		t.Run(tc.name, func(tt *testing.T) {
+			result, err := textplain.ConvertPart(strings.NewReader(tc.part), textplain.DefaultLineLength)
+			assert.Equal(tt, tc.err, err)
+			assert.Equal(tt, tc.expect, result)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package textplain
+
+import (
+	"strconv"
+
+	"golang.org/x/net/html"
+)
+
+// nodeSize is the memory each node of a parsed document is estimated to take, along with its
+// share of attributes
+const nodeSize = 160
+
+// WithMemoryLimit limits the memory a conversion may use to about n bytes, so that a single
+// outsized document can't balloon the memory of a service converting many. The parsed document
+// is estimated to take its own size plus a fixed amount per node, and each intermediate text
+// the document is converted to is measured as it grows. Conversions that would go past the
+// limit stop with a *MemoryLimitError
+func WithMemoryLimit(n int) Option {
+	return func(o *options) {
+		o.memoryLimit = n
+	}
+}
+
+// MemoryLimitError is returned by conversions that would go past the limit set with
+// WithMemoryLimit. It matches ErrMemoryLimit with errors.Is
+type MemoryLimitError struct {
+	// Stage is the stage of the conversion that went past the limit
+	Stage string
+
+	// Size is the memory, in bytes, that the stage was measured or estimated to take
+	Size int
+}
+
+func (e *MemoryLimitError) Error() string {
+	return ErrMemoryLimit.Error() + " in the " + e.Stage + " stage, at " + strconv.Itoa(e.Size) + " bytes"
+}
+
+func (e *MemoryLimitError) Is(target error) bool {
+	return target == ErrMemoryLimit
+}
+
+// checkSize returns a *MemoryLimitError if size goes past the memory limit
+func (o *options) checkSize(stage string, size int) error {
+	if o.memoryLimit <= 0 || size <= o.memoryLimit {
+		return nil
+	}
+	return &MemoryLimitError{Stage: stage, Size: size}
+}
+
+// checkParsed returns a *MemoryLimitError if the estimated size of document, parsed into the
+// tree at root, goes past the memory limit
+func (o *options) checkParsed(document string, root *html.Node) error {
+	if o.memoryLimit <= 0 {
+		return nil
+	}
+
+	var nodes int
+	stack := []*html.Node{root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		nodes++
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			stack = append(stack, c)
+		}
+	}
+	return o.checkSize("parse", len(document)+nodes*nodeSize)
+}
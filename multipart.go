@@ -0,0 +1,58 @@
+package textplain
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+)
+
+// WriteAlternative writes a multipart/alternative body to w containing a text/plain part
+// generated from document, followed by the original text/html, as recommended by RFC 2046.
+// It returns the Content-Type (including boundary) to use for the enclosing entity
+func WriteAlternative(w io.Writer, document string, lineLength int) (string, error) {
+	mw := multipart.NewWriter(w)
+	if err := WritePlainPart(mw, document, lineLength); err != nil {
+		return "", err
+	}
+	if err := writeQuotedPrintablePart(mw, "text/html", func(part io.Writer) error {
+		_, err := io.WriteString(part, document)
+		return err
+	}); err != nil {
+		return "", err
+	}
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+
+	return mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": mw.Boundary()}), nil
+}
+
+// WritePlainPart converts document and adds the result to w as a UTF-8, quoted-printable
+// encoded text/plain part. The text is written to the part as it is converted, see ConvertTo,
+// so a conversion that fails leaves the part incomplete
+func WritePlainPart(w *multipart.Writer, document string, lineLength int) error {
+	return writeQuotedPrintablePart(w, "text/plain", func(part io.Writer) error {
+		return ConvertTo(part, document, lineLength)
+	})
+}
+
+// writeQuotedPrintablePart adds a UTF-8, quoted-printable encoded part to mw, its body written
+// by write
+func writeQuotedPrintablePart(mw *multipart.Writer, mediaType string, write func(io.Writer) error) error {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Type", mime.FormatMediaType(mediaType, map[string]string{"charset": "utf-8"}))
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(part)
+	if err := write(qp); err != nil {
+		return err
+	}
+	return qp.Close()
+}
@@ -0,0 +1,63 @@
+package textplain_test
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	"github.com/mailproto/textplain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteAlternative(t *testing.T) {
+	document := `<html><body><h1>Hello</h1><p>Gar&ccedil;on, <a href="http://example.com/">the menu</a></p></body></html>`
+
+	var buf bytes.Buffer
+	contentType, err := textplain.WriteAlternative(&buf, document, textplain.DefaultLineLength)
+	assert.Nil(t, err)
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	assert.Nil(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	// multipart.Reader transparently decodes quoted-printable parts
+	reader := multipart.NewReader(&buf, params["boundary"])
+	var types, bodies []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+
+		body, err := io.ReadAll(part)
+		assert.Nil(t, err)
+		types = append(types, part.Header.Get("Content-Type"))
+		bodies = append(bodies, string(body))
+	}
+
+	assert.Equal(t, []string{"text/plain; charset=utf-8", "text/html; charset=utf-8"}, types)
+	assert.Equal(t, []string{
+		"*****\r\nHello\r\n*****\r\n\r\nGarçon, the menu ( http://example.com/ )",
+		document,
+	}, bodies)
+}
+
+func TestWritePlainPart(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	assert.Nil(t, textplain.WritePlainPart(mw, `<p>`+strings.Repeat("caf&eacute; ", 10)+`</p>`, 20))
+	assert.Nil(t, mw.Close())
+
+	part, err := multipart.NewReader(&buf, mw.Boundary()).NextRawPart()
+	assert.Nil(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+	assert.Equal(t, "quoted-printable", part.Header.Get("Content-Transfer-Encoding"))
+
+	body, err := io.ReadAll(part)
+	assert.Nil(t, err)
+	assert.Equal(t, "caf=C3=A9 caf=C3=A9 caf=C3=A9 caf=C3=A9\r\ncaf=C3=A9 caf=C3=A9 caf=C3=A9 caf=C3=A9\r\ncaf=C3=A9 caf=C3=A9", string(body))
+}
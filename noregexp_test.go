@@ -0,0 +1,17 @@
+//go:build textplain_noregexp
+
+package textplain_test
+
+import "github.com/mailproto/textplain"
+
+// newConverters returns the TreeConverter, the only converter built with the
+// textplain_noregexp tag, configured with opts
+func newConverters(opts ...textplain.Option) []textplain.Converter {
+	return []textplain.Converter{textplain.NewTreeConverter(opts...)}
+}
+
+// newRegexpConverters returns no converters, as the RegexpConverter isn't built with the
+// textplain_noregexp tag, so that its expectations are skipped
+func newRegexpConverters(opts ...textplain.Option) []textplain.Converter {
+	return []textplain.Converter{}
+}
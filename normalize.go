@@ -0,0 +1,27 @@
+package textplain
+
+import "golang.org/x/text/unicode/norm"
+
+// Normalization is a Unicode normalization form applied to the text version
+type Normalization int
+
+const (
+	// NormalizeNone leaves the text as converted. This is the default
+	NormalizeNone Normalization = iota
+	// NormalizeNFC composes decomposed sequences, such as an `e` followed by a combining
+	// acute accent, into their canonical precomposed characters
+	NormalizeNFC
+	// NormalizeNFKC additionally folds compatibility characters into their plain
+	// equivalents, eg. ligatures, full-width forms and no-break spaces
+	NormalizeNFKC
+)
+
+func (n Normalization) apply(text string) string {
+	switch n {
+	case NormalizeNFC:
+		return norm.NFC.String(text)
+	case NormalizeNFKC:
+		return norm.NFKC.String(text)
+	}
+	return text
+}
@@ -0,0 +1,187 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// NoWrapStart and NoWrapEnd delimit regions of text, such as URLs or data URIs, that WordWrap
+// and the other wrapping functions never break. The delimiters are removed from the wrapped
+// text. A region left open at the end of a line runs to the end of it, and one closed without
+// having been opened runs from the start of its line
+const (
+	NoWrapStart = "\ue005"
+	NoWrapEnd   = "\ue006"
+)
+
+// NoWrap marks text as a region that wrapping must not break
+func NoWrap(text string) string {
+	return NoWrapStart + text + NoWrapEnd
+}
+
+// noWrapSpans removes the no-wrap delimiters from line, returning it along with the
+// [start, end) offsets of the regions they delimited
+func noWrapSpans(line string) (string, [][2]int) {
+	if !strings.Contains(line, NoWrapStart) && !strings.Contains(line, NoWrapEnd) {
+		return line, nil
+	}
+
+	var b strings.Builder
+	var spans [][2]int
+	var start int
+	var open bool
+	for i := 0; i < len(line); {
+		switch {
+		case strings.HasPrefix(line[i:], NoWrapStart):
+			if !open {
+				start, open = b.Len(), true
+			}
+			i += len(NoWrapStart)
+		case strings.HasPrefix(line[i:], NoWrapEnd):
+			if !open {
+				start = 0
+			}
+			spans = append(spans, [2]int{start, b.Len()})
+			open = false
+			i += len(NoWrapEnd)
+		default:
+			b.WriteByte(line[i])
+			i++
+		}
+	}
+	if open {
+		spans = append(spans, [2]int{start, b.Len()})
+	}
+	return b.String(), spans
+}
+
+// stripNoWrap removes any no-wrap delimiters, along with any block width markers, from text
+func stripNoWrap(text string) string {
+	return stripWidthMarkers(strings.NewReplacer(NoWrapStart, "", NoWrapEnd, "").Replace(text))
+}
+
+// urlPrefixes start the URLs that markURLs keeps from being wrapped
+var urlPrefixes = []string{"http://", "https://", "data:"}
+
+// markURLs marks the URLs and data URIs within the text below n as no-wrap regions, so that
+// wrapping never breaks them, even where break characters such as `/` are configured
+func markURLs(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			c.Data = noWrapURLs(c.Data)
+		case html.ElementNode:
+			markURLs(c)
+		}
+	}
+}
+
+// noWrapURLs surrounds each URL in text, outside of any merge tags, with no-wrap delimiters.
+// A URL runs from its scheme up to the next whitespace, quote or angle bracket
+func noWrapURLs(text string) string {
+	var b strings.Builder
+	tags := mergeTagSpans(text)
+	var last int
+	for i := 0; i < len(text); i++ {
+		if i > 0 && isAlphanumeric(text[i-1]) || withinSpan(i, tags) || !hasURLPrefix(text[i:]) {
+			continue
+		}
+		end := i
+		for end < len(text) && isURLCharacter(text[end]) {
+			end++
+		}
+		b.WriteString(text[last:i])
+		b.WriteString(NoWrap(text[i:end]))
+		last, i = end, end
+	}
+	if last == 0 {
+		return text
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+func hasURLPrefix(text string) bool {
+	for _, prefix := range urlPrefixes {
+		if len(text) >= len(prefix) && strings.EqualFold(text[:len(prefix)], prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isURLCharacter(c byte) bool {
+	return !strings.ContainsRune(" \t\r\n\"'<>", rune(c))
+}
+
+// noBreakMarker stands in for the spaces within `white-space:nowrap` and `<nobr>` content, and
+// those kept within preformatted content, during conversion, so that neither the whitespace
+// clean up nor wrapping breaks on them
+const noBreakMarker = "\ue003"
+
+// markNoWrap replaces the whitespace within unbreakable content with a marker, leaving any
+// whitespace at its edges as a regular break opportunity
+func markNoWrap(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if c.DataAtom != atom.Nobr && inlineStyle(c)["white-space"] != "nowrap" {
+			markNoWrap(c)
+			continue
+		}
+
+		var texts []*html.Node
+		collectText(c, &texts)
+		for i, t := range texts {
+			leading := t.Data[:len(t.Data)-len(strings.TrimLeft(t.Data, " \t\r\n"))]
+			trailing := t.Data[len(strings.TrimRight(t.Data, " \t\r\n")):]
+			data := strings.Join(strings.Fields(t.Data), noBreakMarker)
+			if data == "" {
+				if t.Data != "" && i > 0 && i < len(texts)-1 {
+					t.Data = noBreakMarker
+				}
+				continue
+			}
+
+			if leading != "" {
+				if i == 0 {
+					data = leading + data
+				} else {
+					data = noBreakMarker + data
+				}
+			}
+			if trailing != "" {
+				if i == len(texts)-1 {
+					data += trailing
+				} else {
+					data += noBreakMarker
+				}
+			}
+			t.Data = data
+		}
+	}
+}
+
+// collectText appends the text nodes below n, in document order, to texts
+func collectText(n *html.Node, texts *[]*html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			*texts = append(*texts, c)
+		case html.ElementNode:
+			collectText(c, texts)
+		}
+	}
+}
+
+// restoreNoWrap swaps no-break markers back for regular spaces
+func restoreNoWrap(text string) string {
+	return strings.Replace(text, noBreakMarker, " ", -1)
+}
@@ -0,0 +1,479 @@
+package textplain
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// LinkMode controls how anchor tags are rendered in the plaintext output
+type LinkMode int
+
+const (
+	// LinkInline renders links as `text ( href )`, collapsing to just the href when
+	// the two are identical. This is the default
+	LinkInline LinkMode = iota
+	// LinkTextOnly renders only the anchor text and drops the href entirely
+	LinkTextOnly
+	// LinkAngleBrackets renders links as `text <href>`
+	LinkAngleBrackets
+	// LinkMarkdown renders links as `[text](href)`, collapsing to just the href when the
+	// two are identical
+	LinkMarkdown
+)
+
+// BlockBreak sets how a block is separated from the content around it
+type BlockBreak int
+
+const (
+	// LineBreak starts the block on a line of its own
+	LineBreak BlockBreak = iota
+	// ParagraphBreak sets the block apart with blank lines
+	ParagraphBreak
+)
+
+// newlines returns the number of newlines that end the content preceding the block
+func (b BlockBreak) newlines() int {
+	if b == ParagraphBreak {
+		return 2
+	}
+	return 1
+}
+
+// Client is the kind of email client whose rendering the text version should follow where
+// a document provides separate variants for desktop and mobile readers
+type Client int
+
+const (
+	// ClientAny follows the variant shown when no media queries apply, without interpreting
+	// variant class names. This is the default
+	ClientAny Client = iota
+	// ClientDesktop follows the variant shown to readers using desktop clients
+	ClientDesktop
+	// ClientMobile follows the variant shown to readers using mobile clients
+	ClientMobile
+)
+
+// Option customizes the behaviour of a converter
+type Option func(*options)
+
+type options struct {
+	linkMode       LinkMode
+	alwaysShowHref bool
+	dataURISizes   bool
+	keepPreheader  bool
+	keepHidden     bool
+	skipClasses    []string
+	onlyClasses    []string
+
+	excludeSelectors []selector
+	onlySelectors    []selector
+
+	unsubscribeFooter bool
+	dedupeSiblings    bool
+	emptyBlocks       EmptyBlockPolicy
+	breakRuns         BreakRunPolicy
+	emphasisMarkers   bool
+
+	languageRules map[string]LanguageRules
+	targetClient  Client
+
+	wrapping       wrapper
+	blockWidths    map[string]int
+	headingStyles  [6]HeadingStyle
+	containerBreak BlockBreak
+	orderedLists   bool
+
+	includeTitle    bool
+	elementPolicies map[string]ElementPolicy
+	mainContent     bool
+
+	normalization  Normalization
+	foldTypography bool
+	emoji          EmojiMode
+	keepInvisible  bool
+	keepSpaces     bool
+
+	paragraphSeparator string
+	maxBlankLines      int
+	keepSurrounding    bool
+	spacingRules       []SpacingRule
+	invalidUTF8        string
+
+	timeout     time.Duration
+	memoryLimit int
+	maxDepth    int
+	parallelism int
+	stageHook   func(Stage)
+
+	// functions identifies the functions passed as options, which can't be compared, for the
+	// fingerprint of the options to tell them apart
+	functions uint64
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		wrapping:      wrapper{hanging: true},
+		maxBlankLines: 1,
+		spacingRules:  DefaultSpacingRules(),
+		headingStyles: DefaultHeadingStyles(),
+		invalidUTF8:   string(utf8.RuneError),
+		maxDepth:      maxNestingDepth,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithLinkMode sets how anchor tags are rendered, see LinkMode
+func WithLinkMode(mode LinkMode) Option {
+	return func(o *options) {
+		o.linkMode = mode
+	}
+}
+
+// WithAlwaysShowHref forces the href to be rendered alongside the link text even when
+// the two are identical, eg. `http://example.com ( http://example.com )`
+func WithAlwaysShowHref() Option {
+	return func(o *options) {
+		o.alwaysShowHref = true
+	}
+}
+
+// WithDataURISizes includes the approximate decoded size in the placeholders rendered for
+// inline `data:` URIs, eg. `[inline image, 12.4 KB]`
+func WithDataURISizes() Option {
+	return func(o *options) {
+		o.dataURISizes = true
+	}
+}
+
+// WithKeepPreheader disables the removal of the hidden preview text ("preheader") that
+// marketing emails place ahead of their visible content
+func WithKeepPreheader() Option {
+	return func(o *options) {
+		o.keepPreheader = true
+	}
+}
+
+// WithKeepHidden includes elements that are hidden from readers of the html version, eg.
+// through `display:none` or `aria-hidden="true"`, in the text version
+func WithKeepHidden() Option {
+	return func(o *options) {
+		o.keepHidden = true
+	}
+}
+
+// WithSkipClasses excludes every element carrying one of the supplied classes, along with
+// its contents, from the text version
+func WithSkipClasses(classes ...string) Option {
+	return func(o *options) {
+		o.skipClasses = append(o.skipClasses, classes...)
+	}
+}
+
+// WithOnlyClasses restricts the text version to the contents of elements carrying one of
+// the supplied classes
+func WithOnlyClasses(classes ...string) Option {
+	return func(o *options) {
+		o.onlyClasses = append(o.onlyClasses, classes...)
+	}
+}
+
+// WithExcludeSelector excludes every element matching the comma separated css selectors,
+// along with its contents, from the text version. Type, `.class` and `#id` selectors may be
+// combined with descendant and `>` child combinators; selectors using any other syntax are
+// ignored
+func WithExcludeSelector(selectors string) Option {
+	return func(o *options) {
+		o.excludeSelectors = append(o.excludeSelectors, parseSelectors(selectors)...)
+	}
+}
+
+// WithOnlySelector restricts the text version to the contents of elements matching the
+// comma separated css selectors, using the same grammar as WithExcludeSelector. When combined
+// with WithOnlyClasses, elements matching either are kept
+func WithOnlySelector(selectors string) Option {
+	return func(o *options) {
+		o.onlySelectors = append(o.onlySelectors, parseSelectors(selectors)...)
+	}
+}
+
+// WithTargetClient includes only the content a reader of the given kind of client would see,
+// following `@media` queries in style blocks and common class names such as `mobile-only` or
+// `hide-on-mobile`
+func WithTargetClient(client Client) Option {
+	return func(o *options) {
+		o.targetClient = client
+	}
+}
+
+// WithUnsubscribeFooter moves unsubscribe and subscription preference links, detected by
+// their href or link text, into a separate footer section at the end of the text
+func WithUnsubscribeFooter() Option {
+	return func(o *options) {
+		o.unsubscribeFooter = true
+	}
+}
+
+// WithDedupeSiblings collapses adjacent sibling blocks with near-identical content, as
+// produced by builders that duplicate sections as light/dark or mobile/desktop variants
+func WithDedupeSiblings() Option {
+	return func(o *options) {
+		o.dedupeSiblings = true
+	}
+}
+
+// WithEmptyBlocks sets how blocks with no visible content are placed in the text version,
+// see EmptyBlockPolicy
+func WithEmptyBlocks(policy EmptyBlockPolicy) Option {
+	return func(o *options) {
+		o.emptyBlocks = policy
+	}
+}
+
+// WithBreakRuns sets how runs of consecutive `<br>` elements are placed in the text version,
+// see BreakRunPolicy
+func WithBreakRuns(policy BreakRunPolicy) Option {
+	return func(o *options) {
+		o.breakRuns = policy
+	}
+}
+
+// WithEmphasisMarkers surrounds bold text with `*` and italic text with `_`, whether the
+// emphasis comes from markup such as `<strong>` or from inline styles
+func WithEmphasisMarkers() Option {
+	return func(o *options) {
+		o.emphasisMarkers = true
+	}
+}
+
+// WithHardWrap force-breaks words that are longer than the line length, see HardWrap
+func WithHardWrap(continuation string) Option {
+	return func(o *options) {
+		o.wrapping.hard = true
+		o.wrapping.continuation = continuation
+	}
+}
+
+// WithBreakCharacters allows lines to also be broken after any of the supplied characters,
+// see WordWrapAt and BreakCharacters
+func WithBreakCharacters(breakAfter string) Option {
+	return func(o *options) {
+		o.wrapping.breakAfter = breakAfter
+	}
+}
+
+// WithMinimumRaggedness wraps each paragraph so as to even out the length of its lines,
+// rather than filling every line in turn, see WrapOptions.MinimumRaggedness
+func WithMinimumRaggedness() Option {
+	return func(o *options) {
+		o.wrapping.balanced = true
+	}
+}
+
+// WithPreserveIndent keeps the lines wrapped from an indented line at its indentation, for
+// content such as CSS or code pasted into a message, see WrapOptions.PreserveIndent. The
+// RegexpConverter, which keeps the lines of the source, then keeps their indentation too, with
+// tabs expanded to the next tab stop. The TreeConverter collapses whitespace as browsers do,
+// so only its preformatted lines, such as those of `white-space:pre-wrap` content, are indented
+func WithPreserveIndent() Option {
+	return func(o *options) {
+		o.wrapping.preserveIndent = true
+	}
+}
+
+// WithNormalization applies a Unicode normalization form to the text version
+func WithNormalization(form Normalization) Option {
+	return func(o *options) {
+		o.normalization = form
+	}
+}
+
+// WithTypographicFolding replaces smart quotes, en and em dashes, ellipses and non-ASCII
+// spaces with their ASCII equivalents, eg. `“Wait…”` becomes `"Wait..."`, for consumers that
+// require 7-bit clean text or feed it into legacy systems
+func WithTypographicFolding() Option {
+	return func(o *options) {
+		o.foldTypography = true
+	}
+}
+
+// WithEmoji sets how emoji are rendered in the text version, eg. as `:shortcode:` text or not
+// at all, for downstream systems such as ticketing tools and SMS gateways that can't carry
+// them. Emoji are kept as they are by default
+func WithEmoji(mode EmojiMode) Option {
+	return func(o *options) {
+		o.emoji = mode
+	}
+}
+
+// WithKeepInvisibleCharacters disables the removal of control characters, zero width
+// characters and bidi overrides from the text of the document
+func WithKeepInvisibleCharacters() Option {
+	return func(o *options) {
+		o.keepInvisible = true
+	}
+}
+
+// WithKeepUnicodeSpaces keeps thin, hair, figure, en and em spaces as they appear in the
+// document, rather than replacing them with regular spaces, for output that must reproduce
+// the typography of the html version
+func WithKeepUnicodeSpaces() Option {
+	return func(o *options) {
+		o.keepSpaces = true
+	}
+}
+
+// WithInvalidUTF8Replacement sets the text that replaces each run of invalid UTF-8 bytes in
+// the document, eg. the stray Latin-1 bytes of scraped html. Runs are replaced with the
+// Unicode replacement character `\uFFFD` by default, while an empty replacement removes them
+func WithInvalidUTF8Replacement(replacement string) Option {
+	return func(o *options) {
+		o.invalidUTF8 = replacement
+	}
+}
+
+// WithBlockLineLength sets the line length of the text of the given element, eg. `h1` or
+// `blockquote`, in place of the one passed to Convert. A line length of zero or less leaves
+// the block unwrapped. The line length of the innermost configured element applies, while
+// preformatted content is never wrapped
+func WithBlockLineLength(element string, lineLength int) Option {
+	return func(o *options) {
+		if o.blockWidths == nil {
+			o.blockWidths = make(map[string]int)
+		}
+		o.blockWidths[strings.ToLower(element)] = lineLength
+	}
+}
+
+// WithHeadingStyle sets how the headings of the given level, from 1 to 6, are decorated, see
+// DefaultHeadingStyles for the defaults
+func WithHeadingStyle(level int, style HeadingStyle) Option {
+	return func(o *options) {
+		if level >= 1 && level <= len(o.headingStyles) {
+			o.headingStyles[level-1] = style
+		}
+	}
+}
+
+// WithATXHeadings renders headings in the compact style of Markdown, as `# Title`,
+// `## Section` and so on, rather than decorating them with rules, see ATXHeadingStyles
+func WithATXHeadings() Option {
+	return func(o *options) {
+		o.headingStyles = ATXHeadingStyles()
+	}
+}
+
+// WithDocumentTitle renders the title of the document, from its `<title>` or `og:title` meta
+// tag, as a top-level heading ahead of the body content. The title is left out where the body
+// already holds an h1 heading that reads the same
+func WithDocumentTitle() Option {
+	return func(o *options) {
+		o.includeTitle = true
+	}
+}
+
+// WithContainerBreak sets how the tree converter separates generic containers such as `<div>`
+// from the content around them. Containers start on a line of their own by default, while
+// ParagraphBreak sets them apart with blank lines as it does paragraphs
+func WithContainerBreak(brk BlockBreak) Option {
+	return func(o *options) {
+		o.containerBreak = brk
+	}
+}
+
+// WithElementPolicy sets how the content of the given element is placed in the text version,
+// eg. to skip `<nav>` menus, demote `<aside>` content to the end of the document or set the
+// `<footer>` apart from the main content, see ElementPolicy
+func WithElementPolicy(element string, policy ElementPolicy) Option {
+	return func(o *options) {
+		if o.elementPolicies == nil {
+			o.elementPolicies = make(map[string]ElementPolicy)
+		}
+		o.elementPolicies[strings.ToLower(element)] = policy
+	}
+}
+
+// WithMainContent converts only the main content of the document, in the manner of
+// readability tools: navigation, share bars, legal footers and other boilerplate are dropped
+// and the region holding the most prose is kept. This suits scraped web pages and forwarded
+// articles rather than purpose-built email, whose content may be dropped as well
+func WithMainContent() Option {
+	return func(o *options) {
+		o.mainContent = true
+	}
+}
+
+// WithParagraphSeparator sets the separator placed between blocks of text in place of the
+// default blank line, eg. "\n" to separate them with a single newline or "\n---\n" to mark
+// each break with a line of its own
+func WithParagraphSeparator(separator string) Option {
+	return func(o *options) {
+		o.paragraphSeparator = separator
+	}
+}
+
+// WithMaxBlankLines sets the number of consecutive blank lines kept in the text version,
+// which defaults to 1. A limit of 0 leaves no blank lines between blocks at all, while a
+// negative limit keeps every blank line the markup produces
+func WithMaxBlankLines(n int) Option {
+	return func(o *options) {
+		if n < 0 {
+			n = -1
+		}
+		o.maxBlankLines = n
+	}
+}
+
+// WithSpacingRules replaces the DefaultSpacingRules the TreeConverter applies to the blank
+// lines between lines of text. Passing no rules keeps the blank lines produced by the markup
+func WithSpacingRules(rules ...SpacingRule) Option {
+	return func(o *options) {
+		o.spacingRules = append([]SpacingRule{}, rules...)
+		o.functions = nextFunctions()
+	}
+}
+
+// WithKeepSurroundingWhitespace stops the whitespace before the first and after the last
+// block of text from being trimmed, for callers that join the text of several fragments
+func WithKeepSurroundingWhitespace() Option {
+	return func(o *options) {
+		o.keepSurrounding = true
+	}
+}
+
+// trimText trims the whitespace surrounding the converted text, unless it is to be kept
+func (o *options) trimText(text string) string {
+	if o.keepSurrounding {
+		return text
+	}
+	return strings.TrimSpace(text)
+}
+
+// wrapText wraps text to lineLength in the configured style
+func (o *options) wrapText(text string, lineLength int) string {
+	return o.wrapping.wrap(text, lineLength)
+}
+
+// formatHref renders a bare href in the configured link style
+func (o *options) formatHref(href string) string {
+	if o.linkMode == LinkAngleBrackets || o.linkMode == LinkMarkdown {
+		return NoWrap("<" + href + ">")
+	}
+	return NoWrap("( " + href + " )")
+}
+
+// formatLink renders an anchor with the given text and href in the configured link style
+func (o *options) formatLink(text, href string) string {
+	switch {
+	case o.linkMode == LinkTextOnly, text == "":
+		return text
+	case strings.EqualFold(stripNoWrap(text), href) && !o.alwaysShowHref:
+		return text
+	case o.linkMode == LinkMarkdown:
+		return "[" + text + "]" + NoWrap("("+href+")")
+	}
+	return text + " " + o.formatHref(href)
+}
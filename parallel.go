@@ -0,0 +1,168 @@
+package textplain
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// WithParallelism converts the blocks of a document on up to n goroutines at once, for large
+// documents made up of many independent blocks, such as digests of hundreds of stories. The
+// blocks of the outermost element holding more than one of them, below any wrappers around
+// the whole body, are split into n runs, which are converted concurrently and then joined in
+// order. The text is the same as that of a conversion on a single goroutine
+func WithParallelism(n int) Option {
+	return func(o *options) {
+		o.parallelism = n
+	}
+}
+
+// chunkLead is the text assumed to have been written before each run of blocks converted in
+// parallel, bar the first. Blocks end in a blank line, so this rarely needs converting again
+const chunkLead = "\n\n"
+
+// chunk is a run of sibling nodes converted on a goroutine of its own
+type chunk struct {
+	first, end *html.Node
+	text       bytes.Buffer
+	lead       string
+	err        error
+}
+
+// fanOutNode returns the node whose children a rendering of the tree below body converts in
+// parallel, or nil if it is to be converted on a single goroutine
+func (t *TreeConverter) fanOutNode(body *html.Node) *html.Node {
+	if t.parallelism < 2 {
+		return nil
+	}
+	for n := body; ; {
+		var splits, content int
+		var only *html.Node
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if splitsBefore(c) {
+				splits++
+			}
+			if c.Type == html.ElementNode || (c.Type == html.TextNode && strings.TrimSpace(c.Data) != "") {
+				content++
+				only = c
+			}
+		}
+		if splits > 1 {
+			return n
+		}
+
+		// descend through the wrappers that hold the whole of the content, such as the
+		// layout tables of an email, as long as they are converted as the text of their
+		// children with nothing more than line breaks around it
+		if content != 1 || only.Type != html.ElementNode {
+			return nil
+		}
+		if _, ok := elementHandlers[only.DataAtom]; ok && !splitsBefore(only) {
+			return nil
+		}
+		n = only
+	}
+}
+
+// splitsBefore reports whether the children of a node converted in parallel may be split into
+// runs before c. Paragraphs and containers are, as the text written before them affects their
+// own only through the line breaks they add to separate themselves from it
+func splitsBefore(c *html.Node) bool {
+	if c.Type != html.ElementNode {
+		return false
+	}
+	return c.DataAtom == atom.P || c.DataAtom == atom.Pre || containsAtom(sectionElements, c.DataAtom) || containsAtom(containerElements, c.DataAtom)
+}
+
+func containsAtom(atoms []atom.Atom, a atom.Atom) bool {
+	for _, b := range atoms {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
+
+// convertParallel writes the text of the children of n to b, as doConvert does, converting
+// runs of them concurrently. Each run is converted after chunkLead, and converted again after
+// the text of the runs before it wherever that ends differently
+func (t *TreeConverter) convertParallel(b *bytes.Buffer, n *html.Node) error {
+	var splits []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if splitsBefore(c) {
+			splits = append(splits, c)
+		}
+	}
+	runs := t.parallelism
+	if runs > len(splits) {
+		runs = len(splits)
+	}
+
+	chunks := make([]chunk, runs)
+	chunks[0].first = n.FirstChild
+	for i := 1; i < runs; i++ {
+		chunks[i].first = splits[i*len(splits)/runs]
+		chunks[i].lead = chunkLead
+		chunks[i-1].end = chunks[i].first
+	}
+
+	var wg sync.WaitGroup
+	for i := range chunks {
+		wg.Add(1)
+		go func(ch *chunk) {
+			defer wg.Done()
+			ch.text.WriteString(ch.lead)
+			ch.err = t.convertRun(&ch.text, 0, ch.first, ch.end)
+		}(&chunks[i])
+	}
+	wg.Wait()
+
+	start := b.Len()
+	for i := range chunks {
+		ch := &chunks[i]
+		if ch.err != nil {
+			return ch.err
+		}
+		if lineEnding(b.Bytes()[start:]) == lineEnding([]byte(ch.lead)) {
+			b.Write(ch.text.Bytes()[len(ch.lead):])
+		} else if err := t.convertRun(b, start, ch.first, ch.end); err != nil {
+			return err
+		}
+		if err := t.checkSize("convert", b.Len()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertRun writes the text of the siblings from first up to end to b, which holds the text
+// of their parent from start
+func (t *TreeConverter) convertRun(b *bytes.Buffer, start int, first, end *html.Node) error {
+	for c := first; c != end; c = c.NextSibling {
+		if err := t.convertNode(b, start, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lineEnding returns the number of newlines, up to two, that text ends in, disregarding spaces
+// and tabs, or -1 if there is no text. Paragraphs and containers depend on no more than this
+// of the text written before them
+func lineEnding(text []byte) int {
+	if len(text) == 0 {
+		return -1
+	}
+	var newlines int
+	for i := len(text) - 1; i >= 0 && newlines < 2; i-- {
+		if text[i] == '\n' {
+			newlines++
+		} else if text[i] != ' ' && text[i] != '\t' {
+			break
+		}
+	}
+	return newlines
+}
@@ -0,0 +1,163 @@
+package textplain
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Passthrough markers delimit hand-authored plaintext that is emitted verbatim
+const (
+	passthroughStart = "start text/plain"
+	passthroughEnd   = "end text/plain"
+)
+
+// extractPassthrough removes every `<!-- start text/plain -->` ... `<!-- end text/plain -->`
+// region from the document, replacing each with a placeholder token that survives conversion.
+// The returned regions hold the text content of each region with its whitespace intact
+func extractPassthrough(document string) (string, []string) {
+	if !strings.Contains(document, passthroughStart) {
+		return document, nil
+	}
+
+	var regions []string
+	var out strings.Builder
+	for {
+		start, startEnd := findMarkerComment(document, passthroughStart)
+		if start < 0 {
+			break
+		}
+
+		end, endEnd := findMarkerComment(document[startEnd:], passthroughEnd)
+		if end < 0 {
+			end, endEnd = len(document)-startEnd, len(document)-startEnd
+		}
+
+		out.WriteString(document[:start])
+		out.WriteString("\n\n" + passthroughToken(len(regions)) + "\n\n")
+		regions = append(regions, trimBlankLines(textContent(document[startEnd:startEnd+end])))
+		document = document[startEnd+endEnd:]
+	}
+	out.WriteString(document)
+
+	return out.String(), regions
+}
+
+// findMarkerComment returns the start and end offsets of the first `<!-- marker -->` comment
+func findMarkerComment(document, marker string) (int, int) {
+	for offset := 0; ; {
+		start, end, content := nextComment(document, offset)
+		if start < 0 || content == marker {
+			return start, end
+		}
+		offset = end
+	}
+}
+
+// textContent strips all markup from an html fragment, decoding entities but otherwise
+// leaving whitespace untouched
+func textContent(fragment string) string {
+	var text strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return text.String()
+		case html.TextToken:
+			text.Write(tokenizer.Text())
+		}
+	}
+}
+
+// hoistPassthrough moves passthrough placeholders out of any hidden ancestors; the regions
+// are commonly hidden from html readers and mustn't be discarded along with their wrapper
+func hoistPassthrough(body *html.Node, regions []string) {
+	if len(regions) == 0 {
+		return
+	}
+
+	var placeholders []*html.Node
+	var find func(*html.Node)
+	find = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode && strings.Contains(c.Data, passthroughStartToken) {
+				placeholders = append(placeholders, c)
+			}
+			find(c)
+		}
+	}
+	find(body)
+
+	for _, placeholder := range placeholders {
+		insertAt := placeholder
+		for p := placeholder.Parent; p != nil && p != body; p = p.Parent {
+			if p.Type == html.ElementNode && isHidden(p) {
+				insertAt = p
+			}
+		}
+		if insertAt != placeholder {
+			placeholder.Parent.RemoveChild(placeholder)
+			insertAt.Parent.InsertBefore(placeholder, insertAt)
+		}
+	}
+}
+
+// restorePassthrough swaps the placeholder tokens in text for their passthrough regions
+func restorePassthrough(text string, regions []string) string {
+	return replaceTokens(text, passthroughStartToken, passthroughEndToken, func(idx int) (string, bool) {
+		if idx >= len(regions) {
+			return "", false
+		}
+		return regions[idx], true
+	})
+}
+
+// Passthrough placeholders are built from private use characters, which pass through both
+// converters untouched and can't be broken by wrapping
+const (
+	passthroughStartToken = "\ue000"
+	passthroughEndToken   = "\ue001"
+)
+
+// passthroughToken builds the placeholder of the passthrough region at idx
+func passthroughToken(idx int) string {
+	return passthroughStartToken + strconv.Itoa(idx) + passthroughEndToken
+}
+
+// isPassthroughToken reports whether text consists of a single placeholder token
+func isPassthroughToken(text string) bool {
+	if !strings.HasPrefix(text, passthroughStartToken) || !strings.HasSuffix(text, passthroughEndToken) {
+		return false
+	}
+	_, err := strconv.Atoi(text[len(passthroughStartToken) : len(text)-len(passthroughEndToken)])
+	return err == nil
+}
+
+// trimBlankLines removes leading and trailing lines that consist only of whitespace
+func trimBlankLines(text string) string {
+	lines := strings.Split(text, "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// writeTextContent writes the text of n and its descendants, excluding scripts and styles
+func writeTextContent(b *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		b.WriteString(n.Data)
+	case html.ElementNode:
+		if n.DataAtom == atom.Script || n.DataAtom == atom.Style {
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			writeTextContent(b, c)
+		}
+	}
+}
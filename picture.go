@@ -0,0 +1,28 @@
+package textplain
+
+import (
+	"bytes"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// pictureImage returns the fallback `<img>` of a `<picture>` element, which holds the alt text
+// for all of its sources, or nil if it has none
+func pictureImage(n *html.Node) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Img {
+			return c
+		}
+	}
+	return nil
+}
+
+// picture writes a `<picture>` element as its fallback image, leaving out its sources along
+// with the whitespace between them
+func (t *TreeConverter) picture(b *bytes.Buffer, start int, c *html.Node) error {
+	if img := pictureImage(c); img != nil {
+		return t.image(b, start, img)
+	}
+	return nil
+}
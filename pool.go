@@ -0,0 +1,36 @@
+package textplain
+
+// ConverterPool shares a fixed number of converters between goroutines, checking one out for
+// the duration of each conversion so that no two conversions ever run on the same instance.
+// Converters are created by the pool's factory as they are first needed, and a conversion
+// waits for one to be returned once all of them are in use
+type ConverterPool struct {
+	factory    func() Converter
+	converters chan Converter
+}
+
+// NewConverterPool returns a pool of at most size converters created by factory. A size below
+// one is treated as one
+func NewConverterPool(factory func() Converter, size int) *ConverterPool {
+	if size < 1 {
+		size = 1
+	}
+
+	p := &ConverterPool{factory: factory, converters: make(chan Converter, size)}
+	for i := 0; i < size; i++ {
+		p.converters <- nil
+	}
+	return p
+}
+
+// Convert checks out a converter from the pool, creating it if this is its first use, and
+// converts document with it
+func (p *ConverterPool) Convert(document string, lineLength int) (string, error) {
+	c := <-p.converters
+	if c == nil {
+		c = p.factory()
+	}
+	defer func() { p.converters <- c }()
+
+	return c.Convert(document, lineLength)
+}
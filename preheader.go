@@ -0,0 +1,60 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// findPreheader finds the visually hidden preview text ("preheader") that marketing emails
+// place ahead of their visible content, along with any zero-width padding that accompanies it.
+// Only hidden content that precedes the first visible text is considered part of the preheader
+func findPreheader(body *html.Node) []*html.Node {
+	var preheader []*html.Node
+
+	var walk func(*html.Node) bool
+	walk = func(n *html.Node) bool {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.Type {
+			case html.TextNode:
+				if isPaddingText(c.Data) {
+					preheader = append(preheader, c)
+				} else if strings.TrimSpace(c.Data) != "" {
+					return false
+				}
+			case html.ElementNode:
+				if c.DataAtom == atom.Script || c.DataAtom == atom.Style {
+					continue
+				}
+				if isHidden(c) || inlineStyle(c)["mso-hide"] == "all" {
+					preheader = append(preheader, c)
+					continue
+				}
+				if getAttr(c, "alt") != "" || !walk(c) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	walk(body)
+
+	return preheader
+}
+
+// isPaddingText reports whether text consists of nothing but whitespace and the invisible
+// characters used to pad out preview text, with at least one of the latter
+func isPaddingText(text string) bool {
+	var padded bool
+	for _, r := range text {
+		switch r {
+		case '\u200b', '\u200c', '\u200d', '\u2060', '\ufeff', '\u034f', '\u00ad', '\u00a0':
+			padded = true
+		case ' ', '\t', '\n', '\r', '\f':
+		default:
+			return false
+		}
+	}
+	return padded
+}
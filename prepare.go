@@ -0,0 +1,256 @@
+package textplain
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// conversion holds the content set aside while a document is prepared, which is folded
+// back into the text once it has been converted
+type conversion struct {
+	passthrough []string
+	footer      []string
+	escaped     []rune
+
+	normalization      Normalization
+	foldTypography     bool
+	emoji              EmojiMode
+	paragraphSeparator string
+	invalidUTF8        string
+}
+
+// preprocess applies the clean up shared by both converters to the raw document. Ignored
+// regions are dropped and passthrough regions set aside before the html is parsed, as the
+// parser may relocate the marker comments. Invalid UTF-8 is replaced up front, so that it never
+// reaches the text version. The private use characters the converters use as markers are
+// escaped before any markers are added, so that none in the document can pass for one
+func (o *options) preprocess(document string) (string, *conversion) {
+	document = strings.ToValidUTF8(document, o.invalidUTF8)
+	document, escaped := escapeMarkers(document)
+	document, passthrough := extractPassthrough(stripIgnored(document))
+	return document, &conversion{
+		passthrough:        passthrough,
+		escaped:            escaped,
+		normalization:      o.normalization,
+		foldTypography:     o.foldTypography,
+		emoji:              o.emoji,
+		paragraphSeparator: o.paragraphSeparator,
+		invalidUTF8:        o.invalidUTF8,
+	}
+}
+
+// prepare applies the clean up shared by both converters to the parsed body before it is
+// converted
+func (o *options) prepare(body *html.Node, c *conversion) {
+	o.applyStyleSheets(body)
+	o.hideOtherVariants(body)
+	hoistPassthrough(body, c.passthrough)
+
+	preheader := findPreheader(body)
+	if !o.keepPreheader {
+		for _, n := range preheader {
+			n.Parent.RemoveChild(n)
+		}
+		preheader = nil
+	}
+
+	unwrapVML(body)
+	o.applyFilters(body, preheader)
+	if len(o.elementPolicies) > 0 {
+		o.applyElementPolicies(body)
+	}
+	if o.mainContent {
+		extractMainContent(body)
+	}
+	if o.includeTitle {
+		insertTitle(body)
+	}
+	flattenWrappers(body)
+	if o.dedupeSiblings {
+		dedupeSiblings(body)
+	}
+	if !o.keepInvisible {
+		stripInvisible(body)
+	}
+	if !o.keepSpaces {
+		rewriteText(body, plainSpaces)
+	}
+	if o.foldTypography {
+		rewriteText(body, foldTypography)
+	}
+	if o.emoji != EmojiKeep {
+		rewriteText(body, o.emoji.apply)
+	}
+	if o.emptyBlocks == DropEmptyBlocks {
+		dropEmptyBlocks(body)
+	}
+	if o.breakRuns != ParagraphBreakRuns {
+		o.breakRuns.apply(body)
+	}
+	if o.emphasisMarkers {
+		markEmphasis(body, emphasis{})
+	}
+	applyTextTransform(body, "")
+	if len(o.languageRules) > 0 {
+		o.applyLanguageRules(body, nodeLanguage(body))
+	}
+	markDirection(body, documentDirection(body))
+	preserveWhitespace(body)
+	markNoWrap(body)
+	markSignatureDelimiters(body)
+	markURLs(body)
+	if len(o.languageRules) > 0 {
+		o.applyLineBreakRules(body, nodeLanguage(body))
+	}
+	if len(o.blockWidths) > 0 {
+		o.markBlockWidths(body, "")
+	}
+
+	if o.unsubscribeFooter {
+		c.footer = o.extractUnsubscribeLinks(body)
+	}
+}
+
+// finish folds the content set aside during preparation back into the converted text
+func (c *conversion) finish(text string) string {
+	return c.finishPart(text, true)
+}
+
+// finishPart folds the content set aside during preparation back into a part of the converted
+// text made up of whole lines, following the last part with the footer
+func (c *conversion) finishPart(text string, last bool) string {
+	text = restoreSignatureDelimiters(text)
+	text = restoreNoWrap(text)
+
+	if last && len(c.footer) > 0 {
+		text += "\n\n" + footerRule + "\n" + stripNoWrap(strings.Join(c.footer, "\n"))
+	}
+
+	if c.paragraphSeparator != "" {
+		text = separateParagraphs(text, c.paragraphSeparator)
+	}
+	text = restoreBlankLines(text)
+
+	text = c.normalization.apply(restorePassthrough(text, c.passthrough))
+	if c.foldTypography { // the passthrough regions and footer are yet to be folded
+		text = foldTypography(text)
+	}
+	text = c.emoji.apply(text)
+
+	// filters and language rules may still have introduced invalid UTF-8
+	text = strings.ToValidUTF8(text, c.invalidUTF8)
+	return trimTrailingWhitespace(restoreMarkers(text, c.escaped))
+}
+
+// Marker characters found in a document are replaced with a token holding their index in the
+// conversion's escaped characters until it has been converted
+const (
+	escapeStart = "\ue008"
+	escapeEnd   = "\ue009"
+)
+
+// isMarker reports whether r is one of the private use characters the converters use as
+// markers, such as those of passthrough tokens, no-break spaces or block widths
+func isMarker(r rune) bool {
+	return r >= '\ue000' && r <= '\ue009' || isWidthMarker(r)
+}
+
+// escapeMarkers replaces the marker characters in document with tokens, returning them in the
+// order they appear
+func escapeMarkers(document string) (string, []rune) {
+	if strings.IndexFunc(document, isMarker) < 0 {
+		return document, nil
+	}
+
+	var b strings.Builder
+	var escaped []rune
+	for _, r := range document {
+		if !isMarker(r) {
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteString(escapeStart + strconv.Itoa(len(escaped)) + escapeEnd)
+		escaped = append(escaped, r)
+	}
+	return b.String(), escaped
+}
+
+// restoreMarkers swaps the tokens in text for the marker characters they escaped
+func restoreMarkers(text string, escaped []rune) string {
+	return replaceTokens(text, escapeStart, escapeEnd, func(idx int) (string, bool) {
+		if idx >= len(escaped) {
+			return "", false
+		}
+		return string(escaped[idx]), true
+	})
+}
+
+// replaceTokens replaces each token of text delimited by start and end around an index with
+// the text lookup returns for the index, in a single pass. Tokens lookup has nothing for are
+// left as they are
+func replaceTokens(text, start, end string, lookup func(idx int) (string, bool)) string {
+	if !strings.Contains(text, start) {
+		return text
+	}
+
+	var b strings.Builder
+	for {
+		idx := strings.Index(text, start)
+		if idx < 0 {
+			break
+		}
+		b.WriteString(text[:idx])
+		text = text[idx:]
+
+		token := len(start)
+		if n := strings.Index(text[token:], end); n >= 0 {
+			token += n + len(end)
+			if i, err := strconv.Atoi(text[len(start) : token-len(end)]); err == nil && i >= 0 {
+				if replacement, ok := lookup(i); ok {
+					b.WriteString(replacement)
+					text = text[token:]
+					continue
+				}
+			}
+		}
+		b.WriteString(start)
+		text = text[len(start):]
+	}
+	b.WriteString(text)
+	return b.String()
+}
+
+// trimTrailingWhitespace removes the spaces and tabs ending any line of text, bar signature
+// delimiters. Trailing spaces mark soft line breaks to format=flowed readers and are ignored
+// by relaxed DKIM canonicalization, so text version lines must never end in them
+func trimTrailingWhitespace(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line != signatureDelimiter {
+			lines[i] = strings.TrimRight(line, " \t")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// separateParagraphs replaces each run of blank lines between blocks of text with separator
+func separateParagraphs(text, separator string) string {
+	var b strings.Builder
+	var blank bool
+	for i, line := range strings.Split(text, "\n") {
+		switch {
+		case strings.TrimSpace(line) == "":
+			blank = true
+			continue
+		case blank && b.Len() > 0:
+			b.WriteString(separator)
+		case i > 0 && b.Len() > 0:
+			b.WriteString("\n")
+		}
+		blank = false
+		b.WriteString(line)
+	}
+	return b.String()
+}
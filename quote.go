@@ -0,0 +1,143 @@
+package textplain
+
+import "strings"
+
+// QuoteText prefixes every line of text with `> `, as when quoting a message in a reply.
+// Lines that are already quoted gain another level of quoting (`>> `), and each line is
+// re-wrapped so that it fits within lineLength including its prefix
+func QuoteText(text string, lineLength int) string {
+	var quoted []string
+	for _, line := range strings.Split(text, "\n") {
+		depth, content := splitQuote(line)
+		prefix := strings.Repeat(">", depth+1)
+		if content == "" {
+			quoted = append(quoted, prefix)
+			continue
+		}
+
+		quoted = append(quoted, WordWrapPrefixed(content, prefix+" ", lineLength))
+	}
+	return strings.Join(quoted, "\n")
+}
+
+// splitQuote separates the quote markers at the start of a line, eg. `> > text` or `>> text`,
+// from its content, returning the quoting depth
+func splitQuote(line string) (int, string) {
+	var depth, idx int
+	for idx < len(line) {
+		if line[idx] == '>' {
+			depth++
+			idx++
+		} else if line[idx] == ' ' && depth > 0 && idx+1 < len(line) && line[idx+1] == '>' {
+			idx++
+		} else {
+			break
+		}
+	}
+	if depth > 0 {
+		return depth, strings.TrimPrefix(line[idx:], " ")
+	}
+	return 0, line
+}
+
+// Reflow re-wraps existing plaintext to lineLength. Paragraphs, delimited by blank lines, are
+// joined and wrapped as a whole while quote prefixes (`>`) and list bullets are kept and
+// re-applied to every wrapped line. Rules such as heading underlines, signature delimiters
+// and indented pre-formatted lines are left as they are
+func Reflow(text string, lineLength int) string {
+	var out []string
+	var para []string
+	var paraDepth int
+	var paraBullet string
+
+	flush := func() {
+		if len(para) == 0 {
+			return
+		}
+
+		prefix := ""
+		if paraDepth > 0 {
+			prefix = strings.Repeat(">", paraDepth) + " "
+		}
+		indent := strings.Repeat(" ", len(paraBullet))
+
+		width := lineLength
+		if width > 0 {
+			if width -= len(prefix) + len(indent); width < 1 {
+				width = 1
+			}
+		}
+
+		for i, line := range strings.Split(WordWrap(strings.Join(para, " "), width), "\n") {
+			if i == 0 {
+				out = append(out, prefix+paraBullet+line)
+			} else {
+				out = append(out, prefix+indent+line)
+			}
+		}
+		para, paraBullet = nil, ""
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		depth, content := splitQuote(strings.TrimRight(line, " \t"))
+		if isSignatureDelimiter(line) {
+			depth, content = 0, signatureDelimiter
+		}
+
+		trimmed := strings.TrimSpace(content)
+		switch {
+		case trimmed == "":
+			flush()
+			out = append(out, strings.TrimRight(line, " \t"))
+			continue
+		case depth != paraDepth:
+			flush()
+			paraDepth = depth
+		}
+
+		if bullet := listBullet(content); bullet != "" {
+			flush()
+			paraDepth, paraBullet = depth, bullet
+			para = append(para, strings.TrimSpace(content[len(bullet):]))
+			continue
+		}
+
+		indented := content[0] == ' ' || content[0] == '\t'
+		if content == signatureDelimiter || isRule(trimmed) || (indented && paraBullet == "") {
+			// kept verbatim, and never joined with the lines around them
+			flush()
+			out = append(out, line)
+			continue
+		}
+
+		para = append(para, trimmed)
+	}
+	flush()
+
+	return strings.Join(out, "\n")
+}
+
+// listBullet returns the list marker at the start of a line, eg. `* ` or `12. `
+func listBullet(line string) string {
+	if len(line) >= 2 && line[1] == ' ' && strings.ContainsRune("*-+", rune(line[0])) {
+		return line[:2]
+	}
+
+	var digits int
+	for digits < len(line) && line[digits] >= '0' && line[digits] <= '9' {
+		digits++
+	}
+	if digits > 0 && strings.HasPrefix(line[digits:], ". ") {
+		return line[:digits+2]
+	}
+	return ""
+}
+
+// isRule reports whether the line is a horizontal rule or heading underline, ie. a run of a
+// single punctuation character
+func isRule(line string) bool {
+	if len(line) < 2 || !strings.ContainsRune("*-=~#_.", rune(line[0])) {
+		return false
+	}
+	return strings.Trim(line, line[:1]) == ""
+}
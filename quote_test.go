@@ -0,0 +1,90 @@
+package textplain_test
+
+import (
+	"testing"
+
+	"github.com/mailproto/textplain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuoteText(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		text       string
+		lineLength int
+		expect     string
+	}{
+		{
+			name:       "short lines",
+			text:       "Hello\n\nWorld",
+			lineLength: 20,
+			expect:     "> Hello\n>\n> World",
+		},
+		{
+			name:       "rewraps within the prefix",
+			text:       "the quick brown fox jumps",
+			lineLength: 12,
+			expect:     "> the quick\n> brown fox\n> jumps",
+		},
+		{
+			name:       "already quoted",
+			text:       "Thanks!\n\n> > Earlier\n>> message\n>",
+			lineLength: 20,
+			expect:     "> Thanks!\n>\n>>> Earlier\n>>> message\n>>",
+		},
+		{
+			name:       "no wrapping",
+			text:       "the quick brown fox jumps",
+			lineLength: 0,
+			expect:     "> the quick brown fox jumps",
+		},
+	} {
+		t.Run(tc.name, func(tt *testing.T) {
+			assert.Equal(tt, tc.expect, textplain.QuoteText(tc.text, tc.lineLength))
+		})
+	}
+}
+
+func TestReflow(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		text       string
+		lineLength int
+		expect     string
+	}{
+		{
+			name:       "paragraphs",
+			text:       "the quick brown fox jumps\nover the lazy dog\n\nsecond paragraph",
+			lineLength: 20,
+			expect:     "the quick brown fox\njumps over the lazy\ndog\n\nsecond paragraph",
+		},
+		{
+			name:       "quoted paragraphs",
+			text:       "> the quick brown fox jumps over the lazy dog\n>\n>> nested quote",
+			lineLength: 20,
+			expect:     "> the quick brown\n> fox jumps over\n> the lazy dog\n>\n>> nested quote",
+		},
+		{
+			name:       "list items",
+			text:       "* the quick brown fox jumps over\n* the lazy dog\n10. ten items",
+			lineLength: 14,
+			expect:     "* the quick\n  brown fox\n  jumps over\n* the lazy dog\n10. ten items",
+		},
+		{
+			name:       "headings and signatures are kept",
+			text:       "*****\nTitle\n*****\n\nBody text\n-- \nJane Doe",
+			lineLength: 20,
+			expect:     "*****\nTitle\n*****\n\nBody text\n-- \nJane Doe",
+		},
+		{
+			name:       "indented lines are kept",
+			text:       "Code:\n    if x {\n        return\n    }",
+			lineLength: 10,
+			expect:     "Code:\n    if x {\n        return\n    }",
+		},
+	} {
+		t.Run(tc.name, func(tt *testing.T) {
+			assert.Equal(tt, tc.expect, textplain.Reflow(tc.text, tc.lineLength))
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package textplain
+
+import (
+	"sort"
+	"strings"
+)
+
+// balancedLines wraps a line split into clusters, choosing the breaks that minimize its
+// raggedness (the sum of the squares of the space left at the end of every line bar the last)
+// rather than filling each line in turn. Words too long for a line are left to overflow it
+func balancedLines(line string, clusters []string, spans [][2]int, lineLength int, breakAfter string) []string {
+	if len(clusters) == 0 {
+		return []string{""}
+	}
+	cuts := breakOpportunities(clusters, spans, breakAfter)
+
+	// the layout of the text from each line start, found working back from the end
+	type layout struct {
+		cost, cut int
+	}
+	best := map[int]layout{}
+	starts := []int{0}
+	for _, cut := range cuts {
+		if next := skipSpaces(clusters, cut); next < len(clusters) {
+			starts = append(starts, next)
+		}
+	}
+	for i := len(starts) - 1; i >= 0; i-- {
+		start := starts[i]
+		if _, ok := best[start]; ok {
+			continue
+		}
+
+		choice := layout{cost: -1}
+		for _, cut := range cuts[sort.SearchInts(cuts, start+1):] {
+			end := cut
+			for end > start && clusters[end-1] == " " {
+				end--
+			}
+			width := end - start
+			if width > lineLength && choice.cost >= 0 {
+				break
+			}
+
+			var cost int
+			if next := skipSpaces(clusters, cut); next < len(clusters) {
+				if width < lineLength {
+					cost = (lineLength - width) * (lineLength - width)
+				}
+				cost += best[next].cost
+			}
+			if choice.cost < 0 || cost < choice.cost {
+				choice = layout{cost: cost, cut: cut}
+			}
+			if width > lineLength {
+				break
+			}
+		}
+		best[start] = choice
+	}
+
+	offsets := clusterOffsets(clusters)
+	var lines []string
+	for start := 0; start < len(clusters); {
+		cut := best[start].cut
+		lines = append(lines, strings.TrimRight(line[offsets[start]:offsets[cut]], " "))
+		start = skipSpaces(clusters, cut)
+	}
+	return lines
+}
+
+// breakOpportunities returns the offsets into clusters at which a line may end, in ascending
+// order and ending with the end of the clusters, following the same rules as lastBreak
+func breakOpportunities(clusters []string, spans [][2]int, breakAfter string) []int {
+	var cuts []int
+	for i := 1; i < len(clusters); i++ {
+		cut := -1
+		switch {
+		case clusters[i] == " " && clusters[i-1] != " ":
+			cut = i
+		case breakAfter != "" && i < len(clusters)-1 && clusters[i-1] != " " && clusters[i+1] != " " && strings.Contains(breakAfter, clusters[i]):
+			cut = i + 1
+		case clusters[i-1] != " " && cjkBreak(clusters[i-1], clusters[i]):
+			cut = i
+		}
+		if cut >= 0 && !withinSpan(cut, spans) && (len(cuts) == 0 || cuts[len(cuts)-1] < cut) {
+			cuts = append(cuts, cut)
+		}
+	}
+	return append(cuts, len(clusters))
+}
+
+// skipSpaces returns the offset of the first cluster from offset that isn't a space
+func skipSpaces(clusters []string, offset int) int {
+	for offset < len(clusters) && clusters[offset] == " " {
+		offset++
+	}
+	return offset
+}
@@ -0,0 +1,191 @@
+package textplain
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Class and id fragments that mark an element as boilerplate, or as likely content, when
+// extracting the main content of a page
+var (
+	unlikelyContent = []string{
+		"banner", "breadcrumb", "combx", "comment", "community", "cookie", "copyright", "disqus",
+		"footer", "header", "legal", "menu", "modal", "nav", "popup", "related", "remark", "share",
+		"shoutbox", "sidebar", "social", "sponsor", "tweet",
+	}
+	likelyContent = []string{
+		"article", "blog", "body", "content", "entry", "main", "page", "post", "story", "text",
+	}
+	negativeContent = []string{
+		"ad-", "byline", "footnote", "hidden", "meta", "promo", "scroll", "widget",
+	}
+)
+
+// extractMainContent reduces body to its main content region, following the approach of
+// readability algorithms: boilerplate such as navigation, share bars and legal footers is
+// dropped, the remaining paragraphs score their ancestors by the amount of prose they hold,
+// and the best scoring element is kept along with any siblings that score nearly as well.
+// The body is left whole where no element stands out
+func extractMainContent(body *html.Node) {
+	removeMatching(body, isUnlikelyContent)
+
+	scores := make(map[*html.Node]float64)
+	var candidates []*html.Node
+	score := func(n *html.Node, points float64) {
+		if n == nil || n.Type != html.ElementNode {
+			return
+		}
+		if _, ok := scores[n]; !ok {
+			scores[n] = elementWeight(n)
+			candidates = append(candidates, n)
+		}
+		scores[n] += points
+	}
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if !isParagraphLike(c) {
+				walk(c)
+				continue
+			}
+
+			text := nodeText(c)
+			length := utf8.RuneCountInString(text)
+			if length < 25 {
+				continue
+			}
+			points := 1 + float64(strings.Count(text, ","))
+			if bonus := float64(length / 100); bonus < 3 {
+				points += bonus
+			} else {
+				points += 3
+			}
+			score(c.Parent, points)
+			if c.Parent != nil {
+				score(c.Parent.Parent, points/2)
+			}
+		}
+	}
+	walk(body)
+
+	var top *html.Node
+	for _, n := range candidates {
+		scores[n] *= 1 - linkDensity(n)
+		if top == nil || scores[n] > scores[top] {
+			top = n
+		}
+	}
+	if top == nil || top == body {
+		return
+	}
+
+	threshold := scores[top] * 0.2
+	if threshold < 10 {
+		threshold = 10
+	}
+	keep := map[*html.Node]bool{top: true}
+	for c := top.Parent.FirstChild; c != nil; c = c.NextSibling {
+		if s, ok := scores[c]; ok && s >= threshold {
+			keep[c] = true
+		} else if c.DataAtom == atom.P && utf8.RuneCountInString(nodeText(c)) > 80 && linkDensity(c) < 0.25 {
+			keep[c] = true
+		}
+	}
+
+	keepOnlyMatching(body, func(n *html.Node) bool {
+		return keep[n]
+	})
+}
+
+// isUnlikelyContent reports whether n is boilerplate, by its name or by a class or id that
+// marks it as such without also marking it as likely content
+func isUnlikelyContent(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Nav, atom.Aside, atom.Footer:
+		return true
+	case atom.Body, atom.Main, atom.Article, atom.A:
+		return false
+	}
+	names := classAndID(n)
+	return containsAny(names, unlikelyContent) && !containsAny(names, likelyContent)
+}
+
+// isParagraphLike reports whether n holds a run of prose: a paragraph, a block of
+// preformatted text or a quote, or a div or table cell that holds no blocks of its own
+func isParagraphLike(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.P, atom.Pre, atom.Blockquote:
+		return true
+	case atom.Div, atom.Td:
+		return !containsBlock(n)
+	}
+	return false
+}
+
+// elementWeight returns the starting score of a candidate, favouring the elements and class
+// names that usually hold content over those that usually don't
+func elementWeight(n *html.Node) float64 {
+	var weight float64
+	switch n.DataAtom {
+	case atom.Article, atom.Main:
+		weight = 10
+	case atom.Div:
+		weight = 5
+	case atom.Pre, atom.Td, atom.Blockquote:
+		weight = 3
+	case atom.Address, atom.Ol, atom.Ul, atom.Dl, atom.Dd, atom.Dt, atom.Li, atom.Form:
+		weight = -3
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Th:
+		weight = -5
+	}
+
+	names := classAndID(n)
+	if containsAny(names, likelyContent) {
+		weight += 25
+	}
+	if containsAny(names, negativeContent) || containsAny(names, unlikelyContent) {
+		weight -= 25
+	}
+	return weight
+}
+
+// linkDensity returns the share of the text below n that is the text of links
+func linkDensity(n *html.Node) float64 {
+	length := utf8.RuneCountInString(nodeText(n))
+	if length == 0 {
+		return 0
+	}
+
+	var links int
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.A {
+				links += utf8.RuneCountInString(nodeText(c))
+			} else {
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	return float64(links) / float64(length)
+}
+
+// nodeText returns the text below n with its whitespace collapsed
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	writeTextContent(&b, n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// classAndID returns the lowercased class and id attributes of n
+func classAndID(n *html.Node) string {
+	return strings.ToLower(getAttr(n, "class") + " " + getAttr(n, "id"))
+}
@@ -1,10 +1,13 @@
+//go:build !textplain_noregexp
+
+// The RegexpConverter is left out of builds with the textplain_noregexp tag, for targets such
+// as TinyGo and WebAssembly where the regexp package and compiling its patterns at start up
+// cost too much. The TreeConverter depends on neither
+
 package textplain
 
 import (
-	"bytes"
-	"fmt"
 	"regexp"
-	"strconv"
 	"strings"
 
 	"golang.org/x/net/html"
@@ -12,73 +15,36 @@ import (
 )
 
 type RegexpConverter struct {
-	ignoredHTML           *regexp.Regexp
-	comments              *regexp.Regexp
-	imgAltDoubleQuotes    submatchReplacer
-	imgAltSingleQuotes    submatchReplacer
-	links                 submatchReplacer
-	headerClose           submatchReplacer
-	headerBlockBr         *regexp.Regexp
-	headerBlockTags       *regexp.Regexp
-	headerBlock           submatchReplacer
-	wrapSpans             submatchReplacer
-	lists                 *regexp.Regexp
-	listsNoNewline        *regexp.Regexp
-	paragraphs            *regexp.Regexp
-	lineBreaks            *regexp.Regexp
-	remainingTags         *regexp.Regexp
-	shortenSpaces         *regexp.Regexp
-	lineFeeds             *regexp.Regexp
-	nonBreakingSpaces     *regexp.Regexp
-	extraSpaceStartOfLine *regexp.Regexp
-	extraSpaceEndOfLine   *regexp.Regexp
-	consecutiveNewlines   *regexp.Regexp
-	fixWordWrappedParens  submatchReplacer
+	controlBlocks        submatchReplacer
+	headerClose          submatchReplacer
+	headerBlockBr        *regexp.Regexp
+	headerBlockTags      *regexp.Regexp
+	tags                 *regexp.Regexp
+	fixWordWrappedParens submatchReplacer
+	options
 }
 
 // New textplain converter object
-func NewRegexpConverter() Converter {
+func NewRegexpConverter(opts ...Option) Converter {
 
-	headerBlockBr := regexp.MustCompile(`(?i)<br[\s]*\/?>`)
-	headerBlockTags := regexp.MustCompile(`(?i)<\/?[^>]*>`)
+	o := newOptions(opts)
 
 	return &RegexpConverter{
-		ignoredHTML: regexp.MustCompile(`(?ms)<!-- start text\/html -->.*?<!-- end text\/html -->`),
-
-		comments: regexp.MustCompile(`(?ms)<!--.*?-->`),
-
-		// imgAltDoubleQuotes replaces images with their alt tag when it is double quoted
-		imgAltDoubleQuotes: submatchReplacer{
-			regexp: regexp.MustCompile(`(?i)<img.+?alt=\"([^\"]*)\"[^>]*\>`),
-			handler: func(t string, submatch []int) string {
-				return t[submatch[2]:submatch[3]]
-			},
-		},
-
-		// imgAltSingleQuotes replaces images with their alt tag when it is single quoted
-		imgAltSingleQuotes: submatchReplacer{
-			regexp: regexp.MustCompile(`(?i)<img.+?alt=\'([^\']*)\'[^>]*\>`),
-			handler: func(t string, submatch []int) string {
-				return t[submatch[2]:submatch[3]]
-			},
-		},
-
-		// links replaces anchor links with one of "href" or "content ( href )"
-		links: submatchReplacer{
-			regexp: regexp.MustCompile(`(?i)<a\s.*?href=["'](mailto:)?([^"']*)["'][^>]*>((.|\s)*?)<\/a>`),
+		// controlBlocks moves template control tags that sit between block elements onto
+		// their own lines
+		controlBlocks: submatchReplacer{
+			regexp: regexp.MustCompile(`>(\s*(?:\{\{|\{%|&lt;%|\*\|)[^<]*)<`),
 			handler: func(t string, submatch []int) string {
-				href, value := strings.TrimSpace(t[submatch[4]:submatch[5]]), strings.TrimSpace(t[submatch[6]:submatch[7]])
-				var replace string
-				if strings.EqualFold(href, value) {
-					replace = value
-				} else if value != "" {
-					replace = fmt.Sprintf("%s ( %s )", value, href)
+				tags, ok := controlTags(html.UnescapeString(t[submatch[2]:submatch[3]]))
+				if !ok || !blockElements[tagNameBefore(t, submatch[0])] || !blockElements[tagNameAfter(t, submatch[1]-1)] {
+					return t[submatch[0]:submatch[1]]
 				}
-				return replace
+				return ">\n" + html.EscapeString(strings.Join(tags, "\n")) + "\n<"
 			},
 		},
 
-		// headerClose moves `</h[1-6]>` tags to their own line as a preprocessing step for headerBlock
+		// headerClose moves `</h[1-6]>` tags to their own line as a preprocessing step for
+		// replaceHeadings
 		headerClose: submatchReplacer{
 			regexp: regexp.MustCompile(`(?i)(<\/h[1-6]>)`),
 			handler: func(t string, submatch []int) string {
@@ -86,68 +52,12 @@ func NewRegexpConverter() Converter {
 			},
 		},
 
-		// used in headerBlock to do some content replacement
-		headerBlockBr:   headerBlockBr,
-		headerBlockTags: headerBlockTags,
+		// used in headingText to do some content replacement
+		headerBlockBr:   regexp.MustCompile(`(?i)<br[\s]*\/?>`),
+		headerBlockTags: regexp.MustCompile(`(?i)<\/?[^>]*>`),
 
-		// headerBlock converts a `<h[1-6]>` block to plaintext
-		headerBlock: submatchReplacer{
-			regexp: regexp.MustCompile(`(?imsU)[\s]*<h([1-6]+)[^>]*>[\s]*(.*)[\s]*<\/h[1-6]+>`),
-			handler: func(t string, submatch []int) string {
-				headerLevel, _ := strconv.Atoi(t[submatch[2]:submatch[3]])
-				headerText := t[submatch[4]:submatch[5]]
-
-				headerText = headerBlockBr.ReplaceAllString(headerText, "\n")
-				headerText = headerBlockTags.ReplaceAllString(headerText, "")
-
-				var maxLength int
-				var headerLines []string
-				for _, line := range strings.Split(headerText, "\n") {
-					if trimmed := strings.TrimSpace(line); len(trimmed) > 0 {
-						headerLines = append(headerLines, trimmed)
-						if l := len(headerLines[len(headerLines)-1]); l > maxLength {
-							maxLength = l
-						}
-					}
-				}
-
-				headerText = strings.Join(headerLines, "\n")
-				var header string
-
-				// special case headers
-				switch headerLevel {
-				case 1:
-					header = strings.Repeat("*", maxLength) + "\n" + headerText + "\n" + strings.Repeat("*", maxLength)
-				case 2:
-					header = strings.Repeat("-", maxLength) + "\n" + headerText + "\n" + strings.Repeat("-", maxLength)
-				default:
-					header = headerText + "\n" + strings.Repeat("-", maxLength)
-				}
-
-				return "\n\n" + header + "\n\n"
-			},
-		},
-
-		// wrapSpans merges together contiguous span tags into a single line
-		wrapSpans: submatchReplacer{
-			regexp: regexp.MustCompile(`(?msi)(<\/span>)[\s]+(<span)`),
-			handler: func(t string, submatch []int) string {
-				return fmt.Sprintf("%s %s", t[submatch[2]:submatch[3]], t[submatch[4]:submatch[5]])
-			},
-		},
-
-		// these are all used as direct replacements
-		lists:                 regexp.MustCompile(`(?i)[\s]*(<li[^>]*>)[\s]*`),
-		listsNoNewline:        regexp.MustCompile(`(?i)<\/li>[\s]*([\n]?)`),
-		paragraphs:            regexp.MustCompile(`(?i)<\/p>`),
-		lineBreaks:            regexp.MustCompile(`(?i)<br[\/ ]*>`),
-		remainingTags:         regexp.MustCompile(`<\/?[^>]*>`),
-		shortenSpaces:         regexp.MustCompile(` {2,}`),
-		lineFeeds:             regexp.MustCompile(`\r\n?`),
-		nonBreakingSpaces:     regexp.MustCompile(`[ \t]*\302\240+[ \t]*`),
-		extraSpaceStartOfLine: regexp.MustCompile(`\n[ \t]+`),
-		extraSpaceEndOfLine:   regexp.MustCompile(`[ \t]+\n`),
-		consecutiveNewlines:   regexp.MustCompile(`[\n]{3,}`),
+		// tags matches the remaining markup for conversion by replaceTags
+		tags: regexp.MustCompile(`(?i)([\s]*<li[^>]*>[\s]*)|(<\/li>[\s]*)|(<\/(?:p|pre)>|<\/?(?:main|article|section)(?:\s[^>]*)?>)|(<br[\/ ]*>)|(<(?:ol|ul)(?:\s[^>]*)?>)|(<\/(?:ol|ul)>)|<\/?[^>]*>`),
 
 		// fixWordWrappedParens searches for links that got broken by word wrap and moves them
 		// into a single line
@@ -157,7 +67,10 @@ func NewRegexpConverter() Converter {
 				leadingSpace, content, trailingSpace := t[submatch[2]:submatch[3]], t[submatch[4]:submatch[5]], t[submatch[6]:submatch[7]]
 				var out string
 				if leadingSpace == "\n" {
-					out += leadingSpace
+					// keep any hanging indent ahead of the moved paren
+					trimmed := strings.TrimLeft(content, " ")
+					out += leadingSpace + content[:len(content)-len(trimmed)]
+					content = trimmed
 				}
 				out += "( " + content + " )"
 				if trailingSpace == "\n" {
@@ -166,7 +79,188 @@ func NewRegexpConverter() Converter {
 				return out
 			},
 		},
+
+		options: o,
+	}
+}
+
+// rawTextElements hold text that is serialized as is rather than escaped
+var rawTextElements = map[string]bool{
+	"iframe": true, "noembed": true, "noframes": true, "noscript": true, "plaintext": true, "xmp": true,
+}
+
+// voidElements have no content or end tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "keygen": true, "link": true, "meta": true, "param": true, "source": true,
+	"track": true, "wbr": true,
+}
+
+// writeMarkup serializes n to b as html.Render would, leaving out comments along with script
+// and style elements, which hold no content for the text version
+func writeMarkup(b *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		if n.Parent != nil && rawTextElements[n.Parent.Data] {
+			b.WriteString(n.Data)
+		} else {
+			b.WriteString(html.EscapeString(joinInline(n)))
+		}
+	case html.ElementNode:
+		if n.DataAtom == atom.Script || n.DataAtom == atom.Style {
+			return
+		}
+		if n.DataAtom == atom.Picture {
+			// pictures are written as their fallback image, which holds their alt text
+			if img := pictureImage(n); img != nil {
+				writeMarkup(b, img)
+			}
+			return
+		}
+		b.WriteByte('<')
+		b.WriteString(n.Data)
+		for _, a := range n.Attr {
+			b.WriteByte(' ')
+			if a.Namespace != "" {
+				b.WriteString(a.Namespace)
+				b.WriteByte(':')
+			}
+			b.WriteString(a.Key)
+			b.WriteString(`="`)
+			b.WriteString(html.EscapeString(a.Val))
+			b.WriteByte('"')
+		}
+		if voidElements[n.Data] {
+			b.WriteString("/>")
+			return
+		}
+		b.WriteByte('>')
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			writeMarkup(b, c)
+		}
+		b.WriteString("</")
+		b.WriteString(n.Data)
+		b.WriteByte('>')
+	}
+}
+
+// replaceTags converts the remaining markup in a single pass: list items are bulleted, or
+// numbered within ordered lists, and followed by a newline, paragraphs and sections are set
+// apart by blank lines, line breaks become newlines and any other tags are stripped
+func (t *RegexpConverter) replaceTags(txt string) string {
+	// the lists the markup is within, innermost last
+	var lists []listScope
+
+	replacer := submatchReplacer{
+		regexp: t.tags,
+		handler: func(txt string, submatch []int) string {
+			switch {
+			case submatch[2] >= 0:
+				if len(lists) == 0 {
+					bullet, _ := t.listMarkers(tagLanguage(txt[submatch[2]:submatch[3]], "<li"))
+					return bullet
+				}
+				return lists[len(lists)-1].prefix()
+			case submatch[4] >= 0, submatch[8] >= 0:
+				return "\n"
+			case submatch[6] >= 0:
+				return "\n\n"
+			case submatch[10] >= 0:
+				tag := txt[submatch[10]:submatch[11]]
+				list := listScope{next: 1}
+				list.bullet, list.suffix = t.listMarkers(tagLanguage(tag, "<ol"))
+				if t.orderedLists && strings.EqualFold(tag[1:3], "ol") {
+					list.ordered = true
+					scanAttrs(tag, len("<ol"), func(name, value string) {
+						if strings.EqualFold(name, "start") {
+							list.next = listStart(value)
+						}
+					})
+				}
+				lists = append(lists, list)
+			case submatch[12] >= 0:
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+				}
+			}
+			return ""
+		},
+	}
+	return replacer.Replace(txt)
+}
+
+// listScope tracks the numbering of a list the RegexpConverter is within
+type listScope struct {
+	ordered bool
+	next    int
+
+	bullet, suffix string
+}
+
+// prefix returns the bullet or number of the next item of the list
+func (l *listScope) prefix() string {
+	if !l.ordered {
+		return l.bullet
+	}
+	l.next++
+	return ordered(l.next-1, l.suffix)
+}
+
+// tagLanguage returns the language declared in an opening tag, which starts with open at its
+// first non-space character
+func tagLanguage(tag, open string) string {
+	var lang string
+	tag = strings.TrimLeft(tag, " \t\r\n\f")
+	scanAttrs(tag, len(open), func(name, value string) {
+		if strings.EqualFold(name, "lang") || strings.EqualFold(name, "xml:lang") {
+			lang = strings.TrimSpace(value)
+		}
+	})
+	return lang
+}
+
+// joinInline returns the text of n with any whitespace at its edges that lies next to an
+// inline element collapsed to a single space, so that inline elements broken across lines of
+// the source flow together as they do in browsers. Other line breaks in the source are kept
+func joinInline(n *html.Node) string {
+	const space = " \t\r\n\f"
+	text := n.Data
+	content := strings.Trim(text, space)
+	if isPassthroughToken(content) {
+		return text // passthrough regions stand as blocks of their own
+	}
+
+	prev, next := writtenSiblings(n)
+	if content == "" {
+		if isInline(prev) && isInline(next) {
+			return " "
+		}
+		return text
+	}
+	if trimmed := strings.TrimLeft(text, space); isInline(prev) && len(trimmed) < len(text) {
+		text = " " + trimmed
+	}
+	if trimmed := strings.TrimRight(text, space); isInline(next) && len(trimmed) < len(text) {
+		text = trimmed + " "
 	}
+	return text
+}
+
+// writtenSiblings returns the siblings either side of n that writeMarkup writes out
+func writtenSiblings(n *html.Node) (prev, next *html.Node) {
+	written := func(n *html.Node) bool {
+		return n.Type != html.CommentNode && n.DataAtom != atom.Script && n.DataAtom != atom.Style
+	}
+	for prev = n.PrevSibling; prev != nil && !written(prev); prev = prev.PrevSibling {
+	}
+	for next = n.NextSibling; next != nil && !written(next); next = next.NextSibling {
+	}
+	return prev, next
+}
+
+// isInline reports whether n is an element that flows inline with the text around it
+func isInline(n *html.Node) bool {
+	return n != nil && n.Type == html.ElementNode && !blockElements[n.Data] && n.DataAtom != atom.Br
 }
 
 // XXX: based on premailer/premailer@7c94e7a5a457b6710bada8186c6a41fccbfa08d1
@@ -178,141 +272,174 @@ type submatchReplacer struct {
 }
 
 func (s *submatchReplacer) Replace(text string) string {
+	matches := s.regexp.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+
 	var start int
-	var finalText string
-	for _, submatch := range s.regexp.FindAllStringSubmatchIndex(text, -1) {
-		finalText += text[start:submatch[0]] + s.handler(text, submatch)
+	var finalText strings.Builder
+	finalText.Grow(len(text))
+	for _, submatch := range matches {
+		finalText.WriteString(text[start:submatch[0]])
+		finalText.WriteString(s.handler(text, submatch))
 		start = submatch[1]
 	}
-	return finalText + text[start:]
+	finalText.WriteString(text[start:])
+	return finalText.String()
+}
+
+// tagNameBefore returns the lowercased name of the tag closed by the `>` at end
+func tagNameBefore(t string, end int) string {
+	start := strings.LastIndex(t[:end], "<")
+	if start < 0 {
+		return ""
+	}
+	return tagName(t[start+1 : end])
+}
+
+// tagNameAfter returns the lowercased name of the tag opened by the `<` at start
+func tagNameAfter(t string, start int) string {
+	end := strings.Index(t[start:], ">")
+	if end < 0 {
+		return ""
+	}
+	return tagName(t[start+1 : start+end])
+}
+
+func tagName(tag string) string {
+	tag = strings.TrimPrefix(tag, "/")
+	if idx := strings.IndexAny(tag, " \t\n/"); idx >= 0 {
+		tag = tag[:idx]
+	}
+	return strings.ToLower(tag)
+}
+
+// headingText converts the markup of a heading's content to a plaintext heading
+func (t *RegexpConverter) headingText(level int, content string) string {
+	content = t.headerBlockBr.ReplaceAllString(content, "\n")
+	content = t.headerBlockTags.ReplaceAllString(content, "")
+
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if trimmed := strings.TrimSpace(line); len(trimmed) > 0 {
+			lines = append(lines, trimmed)
+		}
+	}
+
+	return "\n\n" + t.formatHeading(level, strings.Join(lines, "\n")) + "\n\n"
 }
 
 // Convert returns a text-only version of supplied document in UTF-8 format with all HTML tags removed
 func (t *RegexpConverter) Convert(document string, lineLength int) (string, error) {
-	// Brutish way to get a fully formed html document
-	doc, err := html.Parse(strings.NewReader(document))
+	budget := t.startBudget()
+
+	// drop the regions marked as ignored, such as headers and footers that aren't needed in the
+	// text version, and set aside the passthrough regions
+	document, conv := t.preprocess(document)
+
+	doc, err := parse(document, t.maxDepth)
 	if err != nil {
 		return "", err
 	}
-
-	// Find the <body> tag within the document
-	var bodyElement *html.Node
-	if doc.Type == html.ElementNode && doc.Data == "body" {
-		bodyElement = doc
-	} else {
-		var scanForBody func(n *html.Node, depth int)
-		scanForBody = func(n *html.Node, depth int) {
-			if n == nil {
-				return
-			}
-			for c := n.FirstChild; c != nil; c = c.NextSibling {
-				if n.Type == html.ElementNode && n.Data == "body" {
-					bodyElement = n
-					return
-				}
-				if depth < 5 {
-					scanForBody(c, depth+1)
-				}
-			}
-		}
-		scanForBody(doc, 0)
+	if err := budget.check("parse", len(document), nil); err != nil {
+		return "", err
+	}
+	if err := t.checkParsed(document, doc); err != nil {
+		return "", err
 	}
+
+	// Find the <body> tag within the document, however deeply it is nested
+	bodyElement := findBody(doc)
 	if bodyElement == nil {
 		return "", ErrBodyNotFound
 	}
 
-	var dropNonContentTags func(*html.Node)
-	dropNonContentTags = func(n *html.Node) {
-		if n == nil {
-			return
-		}
-		var toRemove []*html.Node
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			if c.DataAtom == atom.Script || c.DataAtom == atom.Style {
-				toRemove = append(toRemove, c)
-			} else {
-				dropNonContentTags(c)
-			}
-		}
-		for _, r := range toRemove {
-			n.RemoveChild(r)
-		}
+	t.prepare(bodyElement, conv)
+	if err := budget.check("prepare", len(document), nil); err != nil {
+		return "", err
 	}
-	dropNonContentTags(bodyElement)
 
-	// Reconstitute the cleaned HTML document for application
+	// Serialize the cleaned body, without its comments and non-content tags, for application
 	// of plaintext-conversion logic
-	var clean bytes.Buffer
-	err = html.Render(&clean, bodyElement)
-	if err != nil {
+	var clean strings.Builder
+	clean.Grow(len(document))
+	writeMarkup(&clean, bodyElement)
+	txt := clean.String()
+	if err := budget.check("serialize", len(txt), nil); err != nil {
+		return "", err
+	}
+	if err := t.checkSize("serialize", len(txt)); err != nil {
 		return "", err
 	}
 
-	//  strip text ignored html. Useful for removing
-	//  headers and footers that aren't needed in the
-	//  text version
-	txt := t.ignoredHTML.ReplaceAllString(clean.String(), "")
-
-	//  strip out html comments
-	txt = t.comments.ReplaceAllString(txt, "")
+	//  keep template control tags wrapping whole blocks on their own lines
+	txt = t.controlBlocks.Replace(txt)
 
-	//  replace images with their alt attributes for img tags with "" for attribute quotes
-	//  eg. the following formats:
+	//  replace images with their alt attributes, eg. the following formats, or summarize
+	//  those with an inline data URI and no alt text:
 	//  <img alt="" />
-	//  <img alt="">
-	txt = t.imgAltDoubleQuotes.Replace(txt)
-
-	//  replace images with their alt attributes for img tags with '' for attribute quotes
-	//  eg. the following formats:
-	//  <img alt='' />
 	//  <img alt=''>
-	txt = t.imgAltSingleQuotes.Replace(txt)
+	//  <img src=logo.png alt=Logo>
+	txt = t.replaceImages(txt)
+
+	if err := budget.check("images", len(txt), nil); err != nil {
+		return "", err
+	}
+	if err := t.checkSize("images", len(txt)); err != nil {
+		return "", err
+	}
 
 	// links
-	txt = t.links.Replace(txt)
+	txt = t.replaceLinks(txt)
 
 	//  handle headings (H1-H6)
 	txt = t.headerClose.Replace(txt)
-	txt = t.headerBlock.Replace(txt)
-
-	//  wrap spans
-	txt = t.wrapSpans.Replace(txt)
+	txt = replaceHeadings(txt, t.headingText)
 
-	//  lists -- TODO: should handle ordered lists
-	txt = t.lists.ReplaceAllString(txt, "* ")
-
-	//  list not followed by a newline
-	txt = t.listsNoNewline.ReplaceAllString(txt, "\n")
-
-	//  paragraphs and line breaks
-	txt = t.paragraphs.ReplaceAllString(txt, "\n\n")
-	txt = t.lineBreaks.ReplaceAllString(txt, "\n")
+	if err := budget.check("links and headings", len(txt), nil); err != nil {
+		return "", err
+	}
+	if err := t.checkSize("links and headings", len(txt)); err != nil {
+		return "", err
+	}
 
-	//  strip remaining tags
-	txt = t.remainingTags.ReplaceAllString(txt, "")
+	//  lists, paragraphs, sections and line breaks, stripping the remaining tags
+	txt = t.replaceTags(txt)
 
 	//  decode HTML entities
 	txt = html.UnescapeString(txt)
 
 	//  no more than two consecutive spaces
-	txt = t.shortenSpaces.ReplaceAllString(txt, " ")
-
-	//  apply word wrapping
-	txt = WordWrap(txt, lineLength)
+	txt = shortenSpaces(txt)
+	if err := budget.check("tags", len(txt), func() string { return conv.finish(t.trimText(cleanLineEdges(txt, -1))) }); err != nil {
+		return "", err
+	}
 
-	//  remove linefeeds (\r\n and \r -> \n)
-	txt = t.lineFeeds.ReplaceAllString(txt, "\n")
+	//  apply word wrapping, marking any hanging indents so they survive the clean up below
+	wrapping := t.wrapping
+	wrapping.indent = hangingIndentMarker
+	if wrapping.preserveIndent {
+		txt = markIndentation(txt)
+	}
+	txt = wrapping.wrap(txt, lineLength)
 
-	//  strip extra spaces
-	txt = t.nonBreakingSpaces.ReplaceAllString(txt, " ")
-	txt = t.extraSpaceStartOfLine.ReplaceAllString(txt, "\n")
-	txt = t.extraSpaceEndOfLine.ReplaceAllString(txt, "\n")
+	//  remove linefeeds (\r\n and \r -> \n) and strip extra spaces, allowing no more than the
+	//  configured number of blank lines
+	maxNewlines := -1
+	if t.maxBlankLines >= 0 {
+		maxNewlines = t.maxBlankLines + 1
+	}
+	txt = cleanLineEdges(txt, maxNewlines)
 
-	// no more than two consecutive newlines
-	txt = t.consecutiveNewlines.ReplaceAllString(txt, "\n\n")
+	//  wordWrap messes up the parens, though fixing them can push lines past a strict limit
+	if !t.wrapping.hard {
+		txt = t.fixWordWrappedParens.Replace(txt)
+	}
 
-	//  wordWrap messes up the parens
-	txt = t.fixWordWrappedParens.Replace(txt)
+	//  keep template control tags adjacent to the blocks they wrap
+	txt = joinControlBlocks(txt)
+	budget.record("wrap", len(txt))
 
-	return strings.TrimSpace(txt), nil
+	return conv.finish(t.trimText(txt)), nil
 }
@@ -0,0 +1,40 @@
+//go:build !textplain_noregexp
+
+package textplain_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mailproto/textplain"
+)
+
+// newConverters returns each of the converters, configured with opts
+func newConverters(opts ...textplain.Option) []textplain.Converter {
+	return []textplain.Converter{textplain.NewRegexpConverter(opts...), textplain.NewTreeConverter(opts...)}
+}
+
+// newRegexpConverters returns the RegexpConverter alone, configured with opts, for the
+// expectations that differ between the converters
+func newRegexpConverters(opts ...textplain.Option) []textplain.Converter {
+	return []textplain.Converter{textplain.NewRegexpConverter(opts...)}
+}
+
+func TestRegexpStageHook(t *testing.T) {
+	testStageHook(t, textplain.NewRegexpConverter, []string{"parse", "prepare", "serialize", "images", "links and headings", "tags", "wrap"})
+}
+
+func BenchmarkRegexp(b *testing.B) {
+	converter := textplain.NewRegexpConverter()
+	for i := 0; i < b.N; i++ {
+		_, _ = converter.Convert(html, textplain.DefaultLineLength)
+	}
+}
+
+func BenchmarkRegexpLinks(b *testing.B) {
+	document := "<html><body>" + strings.Repeat(`<h2>Offer</h2><p>See <a href="http://example.com/offer">the offer</a> or <a href="mailto:sales@example.com">mail us</a>.</p>`, 500) + "</body></html>"
+	converter := textplain.NewRegexpConverter()
+	for i := 0; i < b.N; i++ {
+		_, _ = converter.Convert(document, textplain.DefaultLineLength)
+	}
+}
@@ -0,0 +1,303 @@
+package textplain
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The scanners below stand in for the lazy patterns the RegexpConverter once matched against
+// the whole of the serialized body, which backtracked badly over documents with many links and
+// headings. Each scans forward from the opening tags it's looking for, matching the text the
+// pattern it replaces would have
+
+// lowerASCII lowercases the ASCII letters of text, keeping byte offsets into it valid
+func lowerASCII(text string) string {
+	for i := 0; i < len(text); i++ {
+		if c := text[i]; c >= 'A' && c <= 'Z' {
+			b := []byte(text)
+			for j := i; j < len(b); j++ {
+				if c := b[j]; c >= 'A' && c <= 'Z' {
+					b[j] = c + 'a' - 'A'
+				}
+			}
+			return string(b)
+		}
+	}
+	return text
+}
+
+// isRegexpSpace reports whether c is matched by `\s`
+func isRegexpSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+// replaceLinks replaces anchor links with one of "href" or "content ( href )", as matched by
+// `(?i)<a\s.*?href=["'](mailto:)?([^"']*)["'][^>]*>((.|\s)*?)<\/a>`, bar that the href is read
+// from the attributes of the link itself, however they are quoted
+func (o *options) replaceLinks(markup string) string {
+	lowered := lowerASCII(markup)
+
+	var b strings.Builder
+	var written int
+	for pos := 0; ; {
+		idx := strings.Index(lowered[pos:], "<a")
+		if idx < 0 {
+			break
+		}
+		open := pos + idx
+		href, content, end, ok := matchLink(markup, lowered, open)
+		if !ok {
+			pos = open + 1
+			continue
+		}
+
+		if written == 0 {
+			b.Grow(len(markup))
+		}
+		b.WriteString(markup[written:open])
+		b.WriteString(o.markupLink(strings.TrimSpace(href), strings.TrimSpace(content)))
+		written, pos = end, end
+	}
+
+	if written == 0 {
+		return markup
+	}
+	b.WriteString(markup[written:])
+	return b.String()
+}
+
+// matchLink matches an anchor link starting at open, returning its href, bar any `mailto:`
+// scheme, its content and the offset of its end
+func matchLink(markup, lowered string, open int) (href, content string, end int, ok bool) {
+	attrs := open + len("<a")
+	if attrs >= len(markup) || !isRegexpSpace(markup[attrs]) {
+		return "", "", 0, false
+	}
+
+	var hasHref bool
+	contentStart := scanAttrs(markup, attrs, func(name, value string) {
+		if !hasHref && strings.EqualFold(name, "href") {
+			href, hasHref = value, true
+		}
+	})
+	if contentStart < 0 || href == "" {
+		return "", "", 0, false // links without a target are left to be stripped as tags
+	}
+	if len(href) >= len("mailto:") && strings.EqualFold(href[:len("mailto:")], "mailto:") {
+		href = href[len("mailto:"):]
+	}
+
+	contentEnd := strings.Index(lowered[contentStart:], "</a>")
+	if contentEnd < 0 {
+		return "", "", 0, false
+	}
+	contentEnd += contentStart
+	return href, markup[contentStart:contentEnd], contentEnd + len("</a>"), true
+}
+
+// markupLink renders a link for the RegexpConverter, which is yet to strip the markup and
+// decode the entities around it
+func (o *options) markupLink(href, value string) string {
+	if isDataURI(href) {
+		if value == "" {
+			value = o.dataURIPlaceholder(href)
+		}
+		return value
+	}
+
+	link := o.formatLink(value, href)
+	if o.linkMode == LinkAngleBrackets && link != value {
+		// the brackets would be mistaken for markup by remainingTags, encode them
+		// and let the entity decoding pass restore them
+		link = value + " &lt;" + href + "&gt;"
+	}
+	return link
+}
+
+// replaceHeadings converts `<h[1-6]>` blocks, along with the whitespace before them, to plain
+// text, as matched by `(?imsU)[\s]*<h([1-6]+)[^>]*>[\s]*(.*)[\s]*<\/h[1-6]+>`. format renders
+// a heading from its level and the markup of its content
+func replaceHeadings(markup string, format func(level int, content string) string) string {
+	lowered := lowerASCII(markup)
+
+	var b strings.Builder
+	var written int
+	for pos := 0; ; {
+		idx := strings.Index(lowered[pos:], "<h")
+		if idx < 0 {
+			break
+		}
+		open := pos + idx
+		pos = open + 1
+
+		digit := open + len("<h")
+		if digit >= len(markup) || markup[digit] < '1' || markup[digit] > '6' {
+			continue
+		}
+		tagEnd := strings.IndexByte(markup[digit:], '>')
+		if tagEnd < 0 {
+			break
+		}
+		contentStart := digit + tagEnd + 1
+
+		contentEnd, end := closingHeading(lowered, contentStart)
+		if end < 0 {
+			break // any later heading would need a closing tag after this one
+		}
+
+		start := open
+		for start > written && isRegexpSpace(markup[start-1]) {
+			start--
+		}
+		for contentEnd > contentStart && isRegexpSpace(markup[contentEnd-1]) {
+			contentEnd--
+		}
+		level, _ := strconv.Atoi(markup[digit : digit+1])
+
+		if written == 0 {
+			b.Grow(len(markup))
+		}
+		b.WriteString(markup[written:start])
+		b.WriteString(format(level, markup[contentStart:contentEnd]))
+		written, pos = end, end
+	}
+
+	if written == 0 {
+		return markup
+	}
+	b.WriteString(markup[written:])
+	return b.String()
+}
+
+// closingHeading finds the first `</h[1-6]+>` tag from offset from, returning the offsets of
+// its start and end, or -1 if there is none
+func closingHeading(lowered string, from int) (int, int) {
+	for pos := from; ; {
+		idx := strings.Index(lowered[pos:], "</h")
+		if idx < 0 {
+			return -1, -1
+		}
+		start := pos + idx
+		pos = start + 1
+
+		end := start + len("</h")
+		for end < len(lowered) && lowered[end] >= '1' && lowered[end] <= '6' {
+			end++
+		}
+		if end > start+len("</h") && end < len(lowered) && lowered[end] == '>' {
+			return start, end + 1
+		}
+	}
+}
+
+// replaceImages replaces images with their alt text or, where they have none and their source
+// is an inline data URI, a short placeholder. Their attributes are read whatever their order
+// and quoting, as matched by `(?i)<img.+?alt=(?:"([^"]*)"|'([^']*)')[^>]*>` and
+// `(?i)<img[^>]+?src=["'](data:[^"']*)["'][^>]*>` in turn for quoted attributes
+func (o *options) replaceImages(markup string) string {
+	lowered := lowerASCII(markup)
+
+	var b strings.Builder
+	var written int
+	for pos := 0; ; {
+		idx := strings.Index(lowered[pos:], "<img")
+		if idx < 0 {
+			break
+		}
+		open := pos + idx
+		pos = open + 1
+
+		attrs := open + len("<img")
+		if attrs < len(markup) && !isRegexpSpace(markup[attrs]) && markup[attrs] != '/' && markup[attrs] != '>' {
+			continue // a longer tag name
+		}
+		alt, src, end := imageAttrs(markup, attrs)
+		if end < 0 {
+			break // no later image would be closed either
+		}
+		var text string
+		switch {
+		case alt != "":
+			text = alt
+		case isDataURI(src):
+			text = o.dataURIPlaceholder(src)
+		default:
+			continue
+		}
+
+		if written == 0 {
+			b.Grow(len(markup))
+		}
+		b.WriteString(markup[written:open])
+		b.WriteString(text)
+		written, pos = end, end
+	}
+
+	if written == 0 {
+		return markup
+	}
+	b.WriteString(markup[written:])
+	return b.String()
+}
+
+// imageAttrs reads the attributes of the `<img` tag whose name ends at from, returning its alt
+// and src attributes and the offset of the end of the tag, or -1 if it isn't closed
+func imageAttrs(markup string, from int) (alt, src string, end int) {
+	var hasAlt, hasSrc bool
+	end = scanAttrs(markup, from, func(name, value string) {
+		switch {
+		case !hasAlt && strings.EqualFold(name, "alt"):
+			alt, hasAlt = value, true
+		case !hasSrc && strings.EqualFold(name, "src"):
+			src, hasSrc = value, true
+		}
+	})
+	return alt, src, end
+}
+
+// scanAttrs reads the attributes of the tag whose name ends at from, whether their values are
+// double quoted, single quoted, unquoted or left out, passing each to visit in turn. It returns
+// the offset of the end of the tag, or -1 if it isn't closed. Callers keep the first of any
+// repeated attribute, as html does
+func scanAttrs(markup string, from int, visit func(name, value string)) int {
+	for i := from; i < len(markup); {
+		switch c := markup[i]; {
+		case c == '>':
+			return i + 1
+		case isRegexpSpace(c), c == '/':
+			i++
+			continue
+		}
+
+		nameStart := i
+		for i < len(markup) && !isRegexpSpace(markup[i]) && !strings.ContainsRune("=>/", rune(markup[i])) {
+			i++
+		}
+		name := markup[nameStart:i]
+		for i < len(markup) && isRegexpSpace(markup[i]) {
+			i++
+		}
+
+		var value string
+		if i < len(markup) && markup[i] == '=' {
+			for i++; i < len(markup) && isRegexpSpace(markup[i]); i++ {
+			}
+			if i < len(markup) && (markup[i] == '"' || markup[i] == '\'') {
+				closing := strings.IndexByte(markup[i+1:], markup[i])
+				if closing < 0 {
+					return -1
+				}
+				value = markup[i+1 : i+1+closing]
+				i += closing + 2
+			} else {
+				valueStart := i
+				for i < len(markup) && !isRegexpSpace(markup[i]) && markup[i] != '>' {
+					i++
+				}
+				value = markup[valueStart:i]
+			}
+		}
+		visit(name, value)
+	}
+	return -1
+}
@@ -0,0 +1,79 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ElementPolicy sets how the content of an element, typically one of the html sectioning
+// elements such as `<nav>`, `<aside>`, `<header>` or `<footer>`, is placed in the text version
+type ElementPolicy int
+
+const (
+	// KeepElement converts the element in place, like any other. This is the default
+	KeepElement ElementPolicy = iota
+	// SkipElement drops the element along with its content
+	SkipElement
+	// DemoteElement moves the element to the end of the document, after the main content
+	DemoteElement
+	// SeparateElement sets the element apart from the content preceding it with a rule
+	SeparateElement
+)
+
+// sectionRule separates the elements with the SeparateElement policy from the content
+// preceding them
+const sectionRule = "----------"
+
+// applyElementPolicies applies the configured element policies to the elements below body
+func (o *options) applyElementPolicies(body *html.Node) {
+	removeMatching(body, func(n *html.Node) bool {
+		return o.elementPolicies[n.Data] == SkipElement
+	})
+
+	var demoted, separated []*html.Node
+	var find func(*html.Node)
+	find = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch o.elementPolicies[c.Data] {
+			case DemoteElement:
+				demoted = append(demoted, c)
+				continue // the element moves along with its descendants
+			case SeparateElement:
+				separated = append(separated, c)
+			}
+			find(c)
+		}
+	}
+	find(body)
+
+	for _, n := range demoted {
+		n.Parent.RemoveChild(n)
+		body.AppendChild(n)
+	}
+	for _, n := range separated {
+		if hasContentBefore(n, body) {
+			rule := &html.Node{Type: html.ElementNode, Data: "p", DataAtom: atom.P}
+			rule.AppendChild(&html.Node{Type: html.TextNode, Data: sectionRule})
+			n.Parent.InsertBefore(rule, n)
+		}
+	}
+}
+
+// hasContentBefore reports whether any text within body precedes n
+func hasContentBefore(n, body *html.Node) bool {
+	for ; n != nil && n != body; n = n.Parent {
+		for c := n.PrevSibling; c != nil; c = c.PrevSibling {
+			var b strings.Builder
+			writeTextContent(&b, c)
+			if strings.TrimSpace(b.String()) != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
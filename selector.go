@@ -0,0 +1,144 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// selector is a parsed css selector, a chain of compound selectors joined by descendant or
+// child combinators
+type selector []compoundSelector
+
+// compoundSelector matches a single element by tag name, ids and classes. child requires the
+// element to be a direct child of the one matched by the preceding compound selector
+type compoundSelector struct {
+	tag     string
+	ids     []string
+	classes []string
+	child   bool
+}
+
+// parseSelectors parses a comma separated list of selectors. The grammar is limited to type,
+// universal, `.class` and `#id` selectors joined by descendant and `>` child combinators;
+// selectors using anything else are dropped, as they can't be matched faithfully
+func parseSelectors(list string) []selector {
+	var selectors []selector
+	for _, s := range strings.Split(list, ",") {
+		if sel, ok := parseSelector(s); ok {
+			selectors = append(selectors, sel)
+		}
+	}
+	return selectors
+}
+
+func parseSelector(s string) (selector, bool) {
+	var sel selector
+	var child bool
+	for _, token := range strings.Fields(strings.Replace(s, ">", " > ", -1)) {
+		if token == ">" {
+			if len(sel) == 0 || child {
+				return nil, false
+			}
+			child = true
+			continue
+		}
+
+		compound, ok := parseCompound(token)
+		if !ok {
+			return nil, false
+		}
+		compound.child = child
+		child = false
+		sel = append(sel, compound)
+	}
+	return sel, len(sel) > 0 && !child
+}
+
+func parseCompound(token string) (compoundSelector, bool) {
+	var compound compoundSelector
+	if strings.ContainsAny(token, "[]:+~()\"'") {
+		return compound, false
+	}
+
+	end := strings.IndexAny(token, ".#")
+	if end < 0 {
+		end = len(token)
+	}
+	if compound.tag = strings.ToLower(token[:end]); compound.tag == "*" {
+		compound.tag = ""
+	} else if strings.Contains(compound.tag, "*") {
+		return compound, false
+	}
+
+	for rest := token[end:]; rest != ""; {
+		kind := rest[0]
+		next := strings.IndexAny(rest[1:], ".#")
+		if next < 0 {
+			next = len(rest) - 1
+		}
+		name := rest[1 : next+1]
+		rest = rest[next+1:]
+
+		switch {
+		case name == "" || strings.Contains(name, "*"):
+			return compound, false
+		case kind == '#':
+			compound.ids = append(compound.ids, name)
+		default:
+			compound.classes = append(compound.classes, name)
+		}
+	}
+	return compound, true
+}
+
+// matches reports whether n is selected by s
+func (s selector) matches(n *html.Node) bool {
+	return s.matchFrom(len(s)-1, n)
+}
+
+func (s selector) matchFrom(i int, n *html.Node) bool {
+	if !s[i].matches(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	for p := n.Parent; p != nil && p.Type == html.ElementNode; p = p.Parent {
+		if s.matchFrom(i-1, p) {
+			return true
+		}
+		if s[i].child {
+			return false
+		}
+	}
+	return false
+}
+
+func (c compoundSelector) matches(n *html.Node) bool {
+	if n.Type != html.ElementNode || (c.tag != "" && c.tag != n.Data) {
+		return false
+	}
+	for _, id := range c.ids {
+		if getAttr(n, "id") != id {
+			return false
+		}
+	}
+	classes := strings.Fields(getAttr(n, "class"))
+	for _, class := range c.classes {
+		if !containsString(classes, class) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAny reports whether n is selected by at least one of the selectors
+func matchesAny(n *html.Node, selectors []selector) bool {
+	for _, s := range selectors {
+		if s.matches(n) {
+			return true
+		}
+	}
+	return false
+}
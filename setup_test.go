@@ -2,25 +2,27 @@ package textplain_test
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/mailproto/textplain"
 	"github.com/stretchr/testify/assert"
 )
 
-func runTestCases(t *testing.T, testCases []testCase) {
+func runTestCases(t *testing.T, testCases []testCase, converters ...textplain.Converter) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(tt *testing.T) {
-			runTestCase(tt, tc)
+			runTestCase(tt, tc, converters...)
 		})
 	}
 }
 
 func runTestCase(t *testing.T, tc testCase, converters ...textplain.Converter) {
 
-	if len(converters) == 0 {
-		converters = []textplain.Converter{textplain.NewRegexpConverter(), textplain.NewTreeConverter()}
+	// an empty list of converters, as opposed to none at all, runs nothing
+	if converters == nil {
+		converters = newConverters()
 	}
 
 	for _, converter := range converters {
@@ -59,16 +61,17 @@ const html = `<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.0 Transitional//EN" "htt
 
 <img src="https://example.com/footer-animation.gif" /></body></html>`
 
-func BenchmarkRegexp(b *testing.B) {
-	converter := textplain.NewRegexpConverter()
+func BenchmarkTree(b *testing.B) {
+	converter := textplain.NewTreeConverter()
 	for i := 0; i < b.N; i++ {
 		_, _ = converter.Convert(html, textplain.DefaultLineLength)
 	}
 }
 
-func BenchmarkTree(b *testing.B) {
+func BenchmarkTreeList(b *testing.B) {
+	document := "<html><body><h2>Digest</h2><ul>" + strings.Repeat(`<li><a href="http://example.com/story">A story</a> from the week</li>`, 500) + "</ul></body></html>"
 	converter := textplain.NewTreeConverter()
 	for i := 0; i < b.N; i++ {
-		_, _ = converter.Convert(html, textplain.DefaultLineLength)
+		_, _ = converter.Convert(document, textplain.DefaultLineLength)
 	}
 }
@@ -0,0 +1,43 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// signatureDelimiter is the conventional `-- ` line separating a message from its signature,
+// its trailing space is significant to receiving clients
+const signatureDelimiter = "-- "
+
+// signatureMarker stands in for the delimiter's trailing space during conversion so that
+// none of the whitespace clean up can remove it
+const signatureMarker = "--\ue002"
+
+// markSignatureDelimiters replaces the trailing space of every `-- ` signature delimiter line
+// in the document's text with a marker that survives conversion
+func markSignatureDelimiters(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode && strings.Contains(c.Data, signatureDelimiter) {
+			lines := strings.Split(c.Data, "\n")
+			for i, line := range lines {
+				if isSignatureDelimiter(line) {
+					lines[i] = signatureMarker
+				}
+			}
+			c.Data = strings.Join(lines, "\n")
+		}
+		markSignatureDelimiters(c)
+	}
+}
+
+// isSignatureDelimiter reports whether the line is a `-- ` delimiter, allowing for indentation
+// and additional trailing whitespace
+func isSignatureDelimiter(line string) bool {
+	return strings.HasPrefix(strings.TrimLeft(line, " \t"), signatureDelimiter) && strings.TrimSpace(line) == "--"
+}
+
+// restoreSignatureDelimiters swaps signature markers back for the `-- ` delimiter
+func restoreSignatureDelimiters(text string) string {
+	return strings.Replace(text, signatureMarker, signatureDelimiter, -1)
+}
@@ -0,0 +1,261 @@
+package textplain
+
+import "strings"
+
+// SpacingRule adjusts the number of blank lines the TreeConverter places between two
+// consecutive lines of text, given the number produced by the markup (or by the rules before
+// it). Rules are applied in order, before the limit set by WithMaxBlankLines
+type SpacingRule func(previous, next string, blankLines int) int
+
+// DefaultSpacingRules returns the rules the TreeConverter applies unless configured otherwise
+// with WithSpacingRules: runs of list items are kept together
+func DefaultSpacingRules() []SpacingRule {
+	return []SpacingRule{CompactListRuns(IsListItem)}
+}
+
+// IsListItem reports whether line starts a list item, as rendered by the converters
+func IsListItem(line string) bool {
+	return strings.HasPrefix(line, "* ")
+}
+
+// IsHeadingRule reports whether line is one of the rules of `*` or `-` characters that
+// surround or underline headings
+func IsHeadingRule(line string) bool {
+	return line != "" && (strings.Trim(line, "*") == "" || strings.Trim(line, "-") == "")
+}
+
+// CompactListRuns removes the blank lines between consecutive list items, as detected by isItem
+func CompactListRuns(isItem func(line string) bool) SpacingRule {
+	return func(previous, next string, blankLines int) int {
+		if isItem(previous) && isItem(next) {
+			return 0
+		}
+		return blankLines
+	}
+}
+
+// BlankLinesAfter sets the number of blank lines following each line matched by match, eg.
+// BlankLinesAfter(IsHeadingRule, 0) to follow headings directly with their content
+func BlankLinesAfter(match func(line string) bool, n int) SpacingRule {
+	return func(previous, next string, blankLines int) int {
+		if match(previous) {
+			return n
+		}
+		return blankLines
+	}
+}
+
+// tidyWhitespace lays out the whitespace of the converted text the way a browser renders it.
+// Text nodes have already had their whitespace collapsed, leaving the runs of spaces formed
+// where nodes meet and the spaces at the edges of each line to remove. The blank lines between
+// lines are then adjusted by the spacing rules and limited to the configured maximum
+func (o *options) tidyWhitespace(text string) string {
+	var b strings.Builder
+	s := spacer{options: o}
+	s.write(&b, text)
+	s.end(&b)
+	return b.String()
+}
+
+// spacer tidies the whitespace of converted text written to it in parts, holding back the
+// last line of each part, which the next may continue, and the blank lines after the last
+// line tidied, which the spacing rules settle only once the next line is known
+type spacer struct {
+	*options
+	partial  string
+	previous string
+	newlines int
+	started  bool
+}
+
+// write tidies the lines text completes to b
+func (s *spacer) write(b *strings.Builder, text string) {
+	lines := strings.Split(s.partial+text, "\n")
+	for _, line := range lines[:len(lines)-1] {
+		s.line(b, line, false)
+	}
+	s.partial = lines[len(lines)-1]
+}
+
+// end tidies the last line to b, along with the blank lines before it
+func (s *spacer) end(b *strings.Builder) {
+	s.line(b, s.partial, true)
+	s.partial = ""
+}
+
+func (s *spacer) line(b *strings.Builder, line string, last bool) {
+	line = strings.Trim(collapseWhitespace(line), " ")
+	if s.started {
+		s.newlines++
+	}
+	s.started = true
+	if line == "" && !last {
+		return
+	}
+
+	if s.previous != "" && line != "" {
+		blankLines := s.newlines - 1
+		for _, rule := range s.spacingRules {
+			blankLines = rule(stripWidthMarkers(s.previous), stripWidthMarkers(line), blankLines)
+		}
+		s.newlines = blankLines + 1
+	}
+	if s.maxBlankLines >= 0 && s.newlines > s.maxBlankLines+1 {
+		s.newlines = s.maxBlankLines + 1
+	}
+	b.WriteString(strings.Repeat("\n", s.newlines))
+	b.WriteString(line)
+	s.previous, s.newlines = line, 0
+}
+
+// collapseWhitespace collapses each run of whitespace in text to a single space, as html
+// renders whitespace outside of preformatted content. No-break spaces are not whitespace
+func collapseWhitespace(text string) string {
+	if !strings.ContainsAny(text, "\t\r\n\f") && !strings.Contains(text, "  ") {
+		return text
+	}
+
+	var b strings.Builder
+	var space bool
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case ' ', '\t', '\r', '\n', '\f':
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteByte(text[i])
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// unicodeSpaces replaces the typographic spaces of varying widths, such as thin, hair, figure
+// and en/em spaces, with regular spaces
+var unicodeSpaces = strings.NewReplacer(
+	"\u2000", " ", "\u2001", " ", "\u2002", " ", "\u2003", " ", "\u2004", " ", "\u2005", " ",
+	"\u2006", " ", "\u2007", " ", "\u2008", " ", "\u2009", " ", "\u200a", " ", "\u205f", " ",
+)
+
+// plainSpaces replaces typographic spaces in text with regular spaces, which then collapse
+// like any other whitespace. No-break spaces are left alone, as they keep words together
+func plainSpaces(text string) string {
+	return unicodeSpaces.Replace(text)
+}
+
+// shortenSpaces replaces each run of spaces in text with a single space
+func shortenSpaces(text string) string {
+	idx := strings.Index(text, "  ")
+	if idx < 0 {
+		return text
+	}
+
+	b := make([]byte, 0, len(text))
+	b = append(b, text[:idx+1]...)
+	for i := idx + 1; i < len(text); i++ {
+		if text[i] != ' ' || text[i-1] != ' ' {
+			b = append(b, text[i])
+		}
+	}
+	return string(b)
+}
+
+// latinNoBreakSpace is a no-break space misread as Latin-1 and re-encoded, which turns its
+// lead byte into `Â`
+const latinNoBreakSpace = "\u00c2\u00a0"
+
+// hangingIndentMarker stands in for the spaces of hanging indents between wrapping and the
+// final whitespace clean up, which would otherwise strip them
+const hangingIndentMarker = "\ue004"
+
+// markIndentation replaces the spaces and tabs indenting each line of text with hanging indent
+// markers, expanding tabs to the next tab stop, so that the final whitespace clean up keeps
+// them. Lines of nothing but whitespace are left as they are
+func markIndentation(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		content := strings.TrimLeft(line, " \t")
+		if content == "" || len(content) == len(line) {
+			continue
+		}
+		lines[i] = strings.Repeat(hangingIndentMarker, columns(line[:len(line)-len(content)])) + content
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cleanLineEdges makes a single pass over the wrapped text of the RegexpConverter to replace
+// line feeds (\r\n and \r) along with the spaces and tabs around them with plain newlines, and
+// runs of mangled no-break spaces along with the spaces around them with a single space. The
+// hanging indent markers are restored as spaces, and runs of more than maxNewlines newlines are
+// shortened, unless maxNewlines is negative
+func cleanLineEdges(text string, maxNewlines int) string {
+	b := make([]byte, 0, len(text))
+	var newlines int
+	for i := 0; i < len(text); {
+		// copy the text up to the next byte that may start a sequence to replace as is
+		start := i
+		for i < len(text) && !isEdgeByte(text[i]) {
+			i++
+		}
+		if i > start {
+			b = append(b, text[start:i]...)
+			newlines = 0
+			continue
+		}
+
+		spaces := i
+		for spaces < len(text) && (text[spaces] == ' ' || text[spaces] == '\t') {
+			spaces++
+		}
+		switch rest := text[spaces:]; {
+		case strings.HasPrefix(rest, "\r\n"), strings.HasPrefix(rest, "\r"), strings.HasPrefix(rest, "\n"):
+			i = spaces + 1
+			if strings.HasPrefix(rest, "\r\n") {
+				i++
+			}
+			if maxNewlines < 0 || newlines < maxNewlines {
+				b = append(b, '\n')
+			}
+			newlines++
+		case strings.HasPrefix(rest, latinNoBreakSpace):
+			i = spaces + len("\u00c2")
+			for strings.HasPrefix(text[i:], "\u00a0") {
+				i += len("\u00a0")
+			}
+			b = append(b, ' ')
+			newlines = 0
+		case spaces > i:
+			b = append(b, text[i:spaces]...)
+			newlines = 0
+			i = spaces
+			continue
+		case strings.HasPrefix(rest, hangingIndentMarker):
+			b = append(b, ' ')
+			newlines = 0
+			i += len(hangingIndentMarker)
+			continue
+		default:
+			b = append(b, text[i])
+			newlines = 0
+			i++
+			continue
+		}
+
+		// the spaces and tabs following a line feed or no-break spaces go with them
+		for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+			i++
+		}
+	}
+	return string(b)
+}
+
+// isEdgeByte reports whether c may start one of the sequences cleanLineEdges replaces. Bytes
+// within multibyte characters never match the lead bytes of `Â` and the hanging indent marker
+func isEdgeByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == latinNoBreakSpace[0] || c == hangingIndentMarker[0]
+}
@@ -0,0 +1,39 @@
+package textplain
+
+import "time"
+
+// Stage describes a stage of a conversion that has completed, as reported to the hook set with
+// WithStageHook
+type Stage struct {
+	// Name is the name of the stage, as used by TimeoutError. The TreeConverter reports the
+	// "parse", "prepare", "convert" and "wrap" stages, while the RegexpConverter reports
+	// "parse", "prepare", "serialize", "images", "links and headings", "tags" and "wrap"
+	Name string
+
+	// Duration is the time the stage took
+	Duration time.Duration
+
+	// Size is the length, in bytes, of the text the stage ended with. Stages that work on the
+	// parsed tree report the length of the document they parsed
+	Size int
+}
+
+// WithStageHook calls hook as each stage of a conversion completes, so that the time taken by
+// conversions can be broken down by stage. The hook is called on the goroutine converting the
+// document. ConvertTo reports its convert stage once for each block of text it writes, and
+// the wrapping of that text as part of it
+func WithStageHook(hook func(Stage)) Option {
+	return func(o *options) {
+		o.stageHook = hook
+	}
+}
+
+// record reports the end of a stage to the hook, if any
+func (b *budget) record(stage string, size int) {
+	if b.hook == nil {
+		return
+	}
+	now := time.Now()
+	b.hook(Stage{Name: stage, Duration: now.Sub(b.last), Size: size})
+	b.last = now
+}
@@ -0,0 +1,126 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// declaration is a single css property declaration
+type declaration struct {
+	property  string
+	value     string
+	important bool
+}
+
+// parseDeclarations parses a block of css declarations, as found in a style attribute or a
+// style sheet rule. Property names and values are lowercased with their whitespace collapsed,
+// comments are dropped and semicolons within quotes or parentheses, such as those of `data:`
+// urls, don't end a declaration
+func parseDeclarations(block string) []declaration {
+	var declarations []declaration
+	for _, d := range splitDeclarations(stripCSSComments(block)) {
+		idx := strings.Index(d, ":")
+		if idx < 0 {
+			continue
+		}
+		property := strings.ToLower(strings.TrimSpace(d[:idx]))
+		value := strings.Join(strings.Fields(strings.ToLower(d[idx+1:])), " ")
+		important := strings.HasSuffix(value, "!important")
+		value = strings.TrimSpace(strings.TrimSuffix(value, "!important"))
+		if property != "" {
+			declarations = append(declarations, declaration{property, value, important})
+		}
+	}
+	return declarations
+}
+
+// splitDeclarations splits a block on the semicolons that aren't quoted or parenthesized
+func splitDeclarations(block string) []string {
+	var parts []string
+	var quote byte
+	var depth, last int
+	for i := 0; i < len(block); i++ {
+		switch c := block[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == ';' && depth == 0:
+			parts = append(parts, block[last:i])
+			last = i + 1
+		}
+	}
+	return append(parts, block[last:])
+}
+
+// stripCSSComments removes `/* */` comments from css, including an unterminated trailing one
+func stripCSSComments(css string) string {
+	for {
+		start := strings.Index(css, "/*")
+		if start < 0 {
+			return css
+		}
+		end := strings.Index(css[start+2:], "*/")
+		if end < 0 {
+			return css[:start]
+		}
+		css = css[:start] + " " + css[start+2+end+2:]
+	}
+}
+
+// parseStyle parses the declarations of an inline style attribute into a map of lowercased
+// property names to lowercased values, where later declarations override earlier ones
+func parseStyle(style string) map[string]string {
+	declarations := make(map[string]string)
+	for _, d := range parseDeclarations(style) {
+		declarations[d.property] = d.value
+	}
+	return declarations
+}
+
+// inlineStyle returns the parsed declarations of the style attribute of n
+func inlineStyle(n *html.Node) map[string]string {
+	return parseStyle(getAttr(n, "style"))
+}
+
+// inlineDeclaration adds a declaration to the inline style of n, ahead of the existing
+// declarations so that they take precedence, or after them when important
+func inlineDeclaration(n *html.Node, declaration string, important bool) {
+	for i, a := range n.Attr {
+		if a.Key != "style" {
+			continue
+		}
+		if important {
+			n.Attr[i].Val = a.Val + ";" + declaration
+		} else {
+			n.Attr[i].Val = declaration + ";" + a.Val
+		}
+		return
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: "style", Val: declaration})
+}
+
+// isZeroLength reports whether a css length value is zero in any unit
+func isZeroLength(value string) bool {
+	value = strings.TrimRight(value, "abcdefghijklmnopqrstuvwxyz%")
+	return strings.Trim(value, "0.") == "" && value != ""
+}
+
+// hiddenByStyle reports whether the declarations of an inline style hide the element along
+// with everything within it
+func hiddenByStyle(declarations map[string]string) bool {
+	switch {
+	case declarations["display"] == "none",
+		isZeroLength(declarations["opacity"]),
+		isZeroLength(declarations["max-height"]) && declarations["overflow"] == "hidden":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// cssRule is a single rule from a `<style>` block
+type cssRule struct {
+	selectors    []selector
+	declarations []declaration
+	media        string
+}
+
+// styleSheetProperties are the properties carried over from `<style>` blocks, the ones that
+// decide whether an element is hidden
+var styleSheetProperties = []string{"display", "visibility", "max-height", "overflow"}
+
+// applyStyleSheets inlines the rules from the document's `<style>` blocks that decide whether
+// an element is hidden onto the elements they select, so that variants hidden through classes
+// are treated like any other hidden element. Only the selectors supported by parseSelectors
+// are applied. Rules nested in `@media` queries are ignored unless a target client has been
+// set, in which case those matching the client's viewport apply. Conflicting rules are
+// resolved by importance and source order alone, and inlined declarations yield to the
+// element's own inline style unless `!important`
+func (o *options) applyStyleSheets(body *html.Node) {
+	root := body
+	for root.Parent != nil {
+		root = root.Parent
+	}
+
+	var rules []cssRule
+	var find func(*html.Node)
+	find = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && c.DataAtom == atom.Style {
+				var css strings.Builder
+				writeStyleText(&css, c)
+				for _, rule := range parseStyleSheet(css.String()) {
+					if rule.media == "" || (o.targetClient != ClientAny && o.targetClient.matchesMedia(rule.media)) {
+						rules = append(rules, rule)
+					}
+				}
+				continue
+			}
+			find(c)
+		}
+	}
+	find(root)
+
+	if len(rules) == 0 {
+		return
+	}
+
+	var apply func(*html.Node)
+	apply = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+
+			cascaded := make(map[string]declaration)
+			for _, rule := range rules {
+				if !matchesAny(c, rule.selectors) {
+					continue
+				}
+				for _, d := range rule.declarations {
+					if prev, ok := cascaded[d.property]; !ok || d.important || !prev.important {
+						cascaded[d.property] = d
+					}
+				}
+			}
+			for _, property := range styleSheetProperties {
+				if d, ok := cascaded[property]; ok {
+					inlineDeclaration(c, d.property+":"+d.value, d.important)
+				}
+			}
+
+			apply(c)
+		}
+	}
+	apply(body)
+}
+
+func writeStyleText(b *strings.Builder, n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			b.WriteString(c.Data)
+		}
+	}
+}
+
+// parseStyleSheet splits css into its rules, recording the query of any enclosing `@media`
+// rule. Comments and all other at-rules are skipped
+func parseStyleSheet(css string) []cssRule {
+	css = stripCSSComments(css)
+
+	var rules []cssRule
+	for {
+		open := strings.Index(css, "{")
+		if open < 0 {
+			return rules
+		}
+		prelude := strings.TrimSpace(css[:open])
+		if idx := strings.LastIndex(prelude, ";"); idx >= 0 {
+			// drop any preceding statement at-rules, such as @import
+			prelude = strings.TrimSpace(prelude[idx+1:])
+		}
+
+		// find the matching close brace, allowing for the nested blocks of at-rules
+		depth, close := 0, -1
+		for i := open; i < len(css) && close < 0; i++ {
+			switch css[i] {
+			case '{':
+				depth++
+			case '}':
+				if depth--; depth == 0 {
+					close = i
+				}
+			}
+		}
+		if close < 0 {
+			close = len(css)
+		}
+
+		switch {
+		case strings.HasPrefix(strings.ToLower(prelude), "@media"):
+			query := strings.TrimSpace(prelude[len("@media"):])
+			for _, rule := range parseStyleSheet(css[open+1 : close]) {
+				if rule.media == "" {
+					rule.media = query
+				}
+				rules = append(rules, rule)
+			}
+		case !strings.HasPrefix(prelude, "@"):
+			var declarations []declaration
+			for _, d := range parseDeclarations(css[open+1 : close]) {
+				if containsString(styleSheetProperties, d.property) {
+					declarations = append(declarations, d)
+				}
+			}
+			if len(declarations) > 0 {
+				rules = append(rules, cssRule{selectors: parseSelectors(prelude), declarations: declarations})
+			}
+		}
+
+		if close+1 >= len(css) {
+			return rules
+		}
+		css = css[close+1:]
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package textplain
+
+import "strings"
+
+// controlKind classifies a template merge tag by the role it plays in block structure
+type controlKind int
+
+const (
+	notControl controlKind = iota
+	controlOpen
+	controlBranch
+	controlClose
+)
+
+var (
+	liquidOpeners = map[string]bool{
+		"if": true, "unless": true, "for": true, "case": true, "capture": true, "tablerow": true,
+		"block": true, "with": true, "macro": true, "filter": true, "comment": true, "raw": true,
+	}
+	liquidBranches = map[string]bool{"else": true, "elsif": true, "elif": true, "when": true, "empty": true}
+
+	erbOpeners  = map[string]bool{"if": true, "unless": true, "while": true, "until": true, "case": true, "begin": true, "for": true}
+	erbBranches = map[string]bool{"else": true, "elsif": true, "when": true, "rescue": true, "ensure": true}
+)
+
+// classifyControlTag determines whether a single merge tag opens, continues or closes a
+// conditional/loop block in one of the supported templating languages. The `<!--[if mso]>`
+// conditional comments of email clients aren't preserved like these, as no template engine
+// executes them, but resolved as clients other than Outlook render them: the content of
+// `<!--[if ...]>` comments is dropped, while that of `<!--[if !mso]><!-->` blocks is kept
+func classifyControlTag(tag string) controlKind {
+	switch {
+	case strings.HasPrefix(tag, "{%"):
+		keyword := firstWord(strings.Trim(tag, "{%-} \t\n"))
+		switch {
+		case liquidOpeners[keyword]:
+			return controlOpen
+		case liquidBranches[keyword]:
+			return controlBranch
+		case strings.HasPrefix(keyword, "end"):
+			return controlClose
+		}
+	case strings.HasPrefix(tag, "{{") && !strings.HasPrefix(tag, "{{{"):
+		inner := strings.Trim(tag, "{~} \t\n")
+		switch {
+		case strings.HasPrefix(inner, "#"), strings.HasPrefix(inner, "^"):
+			return controlOpen
+		case strings.HasPrefix(inner, "/"):
+			return controlClose
+		case firstWord(inner) == "else":
+			return controlBranch
+		}
+	case strings.HasPrefix(tag, "<%"):
+		inner := strings.TrimSpace(strings.Trim(tag, "<%->"))
+		if strings.HasPrefix(inner, "=") || strings.HasPrefix(inner, "#") {
+			return notControl
+		}
+		keyword := firstWord(inner)
+		switch {
+		case erbOpeners[keyword], strings.HasSuffix(inner, " do"), strings.Contains(inner, " do |"):
+			return controlOpen
+		case erbBranches[keyword]:
+			return controlBranch
+		case keyword == "end":
+			return controlClose
+		}
+	case strings.HasPrefix(tag, "*|"):
+		inner := strings.ToUpper(strings.Trim(tag, "*| \t"))
+		switch {
+		case strings.HasPrefix(inner, "END:"):
+			return controlClose
+		case strings.HasPrefix(inner, "ELSE:"), strings.HasPrefix(inner, "ELSEIF:"):
+			return controlBranch
+		case strings.HasPrefix(inner, "IF:"), strings.HasPrefix(inner, "IFNOT:"), strings.HasPrefix(inner, "INTERESTED:"):
+			return controlOpen
+		}
+	}
+	return notControl
+}
+
+func firstWord(s string) string {
+	if idx := strings.IndexAny(s, " \t\n(|"); idx >= 0 {
+		return s[:idx]
+	}
+	return s
+}
+
+// controlTags returns the merge tags within text when it consists of nothing but whitespace
+// and merge tags, at least one of which is a control tag
+func controlTags(text string) ([]string, bool) {
+	var tags []string
+	var hasControl bool
+	var last int
+	for _, span := range mergeTagSpans(text) {
+		if strings.TrimSpace(text[last:span[0]]) != "" {
+			return nil, false
+		}
+		tag := text[span[0]:span[1]]
+		if classifyControlTag(tag) != notControl {
+			hasControl = true
+		}
+		tags = append(tags, tag)
+		last = span[1]
+	}
+	if !hasControl || strings.TrimSpace(text[last:]) != "" {
+		return nil, false
+	}
+	return tags, true
+}
+
+// joinControlBlocks removes the blank lines between a block-level control tag and the
+// content it wraps, so that the opening and closing tags remain adjacent to the block
+func joinControlBlocks(text string) string {
+	if !strings.Contains(text, "{") && !strings.Contains(text, "<%") && !strings.Contains(text, "*|") {
+		return text
+	}
+
+	lines := strings.Split(text, "\n")
+	joined := make([]string, 0, len(lines))
+	var afterOpen bool
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" && afterOpen {
+			continue
+		}
+
+		kind := notControl
+		if tags, ok := controlTags(trimmed); ok && len(tags) == 1 {
+			kind = classifyControlTag(tags[0])
+		}
+
+		if kind == controlClose || kind == controlBranch {
+			for len(joined) > 0 && strings.TrimSpace(joined[len(joined)-1]) == "" {
+				joined = joined[:len(joined)-1]
+			}
+		}
+
+		afterOpen = kind == controlOpen || kind == controlBranch
+		joined = append(joined, line)
+	}
+	return strings.Join(joined, "\n")
+}
+
+// blockElements are the elements that start a new block in the rendered output
+var blockElements = map[string]bool{
+	"html": true, "body": true, "address": true, "article": true, "aside": true, "blockquote": true,
+	"center": true, "dd": true, "div": true, "dl": true, "dt": true, "fieldset": true, "figure": true,
+	"footer": true, "form": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "main": true, "nav": true, "ol": true, "p": true, "pre": true,
+	"section": true, "table": true, "tbody": true, "td": true, "tfoot": true, "th": true, "thead": true,
+	"tr": true, "ul": true,
+}
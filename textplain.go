@@ -2,6 +2,7 @@ package textplain
 
 import (
 	"errors"
+	"sync"
 )
 
 // Defaults
@@ -12,14 +13,23 @@ const (
 // Well-defined errors
 var (
 	ErrBodyNotFound = errors.New("could not find a `body` element in your html document")
+	ErrNoHTMLPart   = errors.New("could not find a text/html part in the message")
+	ErrTimeout      = errors.New("the conversion ran past its time limit")
+	ErrMemoryLimit  = errors.New("the conversion ran past its memory limit")
 )
 
-var defaultConverter = NewTreeConverter()
+var (
+	defaultConverter     Converter
+	defaultConverterOnce sync.Once
+)
 
-// Convert is a convenience method so the library can be used without initializing a converter
-// because this library relies heavily on regexp objects, it may act as a bottlneck to concurrency
-// due to thread-safety mutexes in *regexp.Regexp internals
+// Convert is a convenience method so the library can be used without initializing a converter.
+// It uses a TreeConverter with the default options, which holds no mutable state and so may be
+// called from any number of goroutines at once without contending for locks
 func Convert(document string, lineLength int) (string, error) {
+	defaultConverterOnce.Do(func() {
+		defaultConverter = NewTreeConverter()
+	})
 	return defaultConverter.Convert(document, lineLength)
 }
 
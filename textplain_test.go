@@ -1,8 +1,11 @@
 package textplain_test
 
 import (
+	"errors"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/mailproto/textplain"
 	"github.com/stretchr/testify/assert"
@@ -48,6 +51,8 @@ func TestConvert(t *testing.T) {
 }
 
 func TestStrippingWhitespace(t *testing.T) {
+	// the regexp converter keeps the line breaks of the source, where the tree converter
+	// collapses them as html rendering does
 	runTestCases(t, []testCase{
 		{
 			name:   "leading tab, trailing newline",
@@ -64,6 +69,37 @@ func TestStrippingWhitespace(t *testing.T) {
 			body:   "  \na \n\t \n \n a \t",
 			expect: "a\n\na",
 		},
+	}, newRegexpConverters()...)
+
+	runTestCases(t, []testCase{
+		{
+			name:   "leading tab, trailing newline",
+			body:   "  \ttext\ntext\n",
+			expect: "text text",
+		},
+		{
+			name:   "leading newline, trailing tab, infix spaces",
+			body:   "  \na \n a \t",
+			expect: "a a",
+		},
+		{
+			name:   "leading and infix newlines, trailing tab",
+			body:   "  \na \n\t \n \n a \t",
+			expect: "a a",
+		},
+		{
+			name:   "whitespace between blocks",
+			body:   "<p>a</p>\n\t\n<p>\n\tb\tc\n</p>\n<ul>\n<li> d </li>\n\n<li>e</li>\n</ul>",
+			expect: "a\n\nb c\n\n* d\n* e",
+		},
+		{
+			name:   "no-break spaces are kept",
+			body:   "a&nbsp;&nbsp;\n b",
+			expect: "a\u00a0\u00a0 b",
+		},
+	}, textplain.NewTreeConverter())
+
+	runTestCases(t, []testCase{
 		{
 			name:   "trailing non-breaking space",
 			body:   "test text&nbsp;",
@@ -79,17 +115,7 @@ func TestStrippingWhitespace(t *testing.T) {
 }
 
 func TestWrappingSpans(t *testing.T) {
-	runTestCases(t, []testCase{
-		{
-			body: `<html>
-	    <body>
-			<p><span>Test</span>
-			<span>line 2</span>
-			</p>`,
-			expect: `Test line 2`,
-		},
-		{
-			body: `<html>
+	body := `<html>
 	    <body>
 			<p><span>Test</span>
 			<span> spans </span>
@@ -99,8 +125,26 @@ func TestWrappingSpans(t *testing.T) {
 			<span>
 				again
 			</span>
+			</p>`
+
+	runTestCase(t, testCase{
+		body:   body,
+		expect: "Test spans\n\ninbetween\n\nline 2\nagain",
+	}, newRegexpConverters()...)
+
+	runTestCase(t, testCase{
+		body:   body,
+		expect: "Test spans\n\ninbetween\n\nline 2 again",
+	}, textplain.NewTreeConverter())
+
+	runTestCases(t, []testCase{
+		{
+			body: `<html>
+	    <body>
+			<p><span>Test</span>
+			<span>line 2</span>
 			</p>`,
-			expect: "Test spans\n\ninbetween\n\nline 2\nagain",
+			expect: `Test line 2`,
 		},
 		{
 			name: "tables and spans",
@@ -135,7 +179,26 @@ func TestLineBreaks(t *testing.T) {
 			body:   "Test text\rTest text",
 			expect: "Test text\nTest text",
 		},
-	})
+	}, newRegexpConverters()...)
+
+	// line breaks in the source are whitespace like any other to the tree converter
+	runTestCases(t, []testCase{
+		{
+			name:   "line feed and newline become a space",
+			body:   "Test text\r\nTest text",
+			expect: "Test text Test text",
+		},
+		{
+			name:   "line feed becomes a space",
+			body:   "Test text\rTest text",
+			expect: "Test text Test text",
+		},
+		{
+			name:   "br becomes newline",
+			body:   "Test text<br>\nTest text",
+			expect: "Test text\nTest text",
+		},
+	}, textplain.NewTreeConverter())
 }
 
 func TestLists(t *testing.T) {
@@ -194,6 +257,93 @@ func TestStrippingHTML(t *testing.T) {
 			<p>text</p>`,
 			expect: "test\n\ntext",
 		},
+		{
+			name: "multiple ignored blocks with comments between",
+			body: `<!-- start text/html --><p>header</p><!-- end text/html -->
+			<p>one</p><!-- a comment --><p>two</p>
+			<!-- start text/html --><p>footer <!-- nested comment --> links</p><!-- end text/html -->`,
+			expect: "one\n\ntwo",
+		},
+		{
+			name:   "nested ignored blocks",
+			body:   `<p>one</p><!-- start text/html --><p>a</p><!-- start text/html --><p>b</p><!-- end text/html --><p>c</p><!-- end text/html --><p>two</p>`,
+			expect: "one\n\ntwo",
+		},
+		{
+			name:   "unterminated ignored block",
+			body:   `<p>one</p><!-- start text/html --><p>two</p>`,
+			expect: "one\n\ntwo",
+		},
+		{
+			name:   "unbalanced end marker",
+			body:   `<p>one</p><!-- end text/html --><p>two</p><!-- start text/html --><p>three</p><!-- end text/html -->`,
+			expect: "one\n\ntwo",
+		},
+		{
+			name:   "ignored block at start of fragment",
+			body:   `<!-- start text/html -->header<!-- end text/html --><p>body</p>`,
+			expect: "body",
+		},
+	})
+}
+
+func TestPassthroughBlocks(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name: "whitespace is preserved",
+			body: `<p>Hello</p>
+			<!-- start text/plain -->
+Order    Qty   Price
+Widget     2   $4.00
+   indented &amp; <b>bold</b>
+<!-- end text/plain -->
+			<p>After</p>`,
+			expect: "Hello\n\nOrder    Qty   Price\nWidget     2   $4.00\n   indented & bold\n\nAfter",
+		},
+		{
+			name: "hidden from html readers",
+			body: `<p>Hello</p><div style="display:none"><!-- start text/plain -->
+Plain only
+<!-- end text/plain --></div>`,
+			expect: "Hello\n\nPlain only",
+		},
+		{
+			name:   "long lines are not wrapped",
+			body:   "<!-- start text/plain -->" + strings.Repeat("word ", 20) + "<!-- end text/plain -->",
+			expect: strings.TrimSpace(strings.Repeat("word ", 20)),
+		},
+		{
+			name:   "regions after inline content",
+			body:   `<img alt="Logo"><!-- start text/plain -->one  1<!-- end text/plain --><b>html</b>`,
+			expect: "Logo\n\none  1\n\nhtml",
+		},
+		{
+			name: "multiple regions",
+			body: `<!-- start text/plain -->one  1<!-- end text/plain -->
+			<p>html</p>
+			<!-- start text/plain -->two  2<!-- end text/plain -->`,
+			expect: "one  1\n\nhtml\n\ntwo  2",
+		},
+	})
+}
+
+func TestSignatureDelimiters(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name:   "delimiter line",
+			body:   `<p>Thanks,</p><p>-- <br>Jane Doe<br>ACME</p>`,
+			expect: "Thanks,\n\n-- \nJane Doe\nACME",
+		},
+		{
+			name:   "passthrough block",
+			body:   "<p>Thanks,</p><!-- start text/plain -->\n-- \nJane Doe\n<!-- end text/plain -->",
+			expect: "Thanks,\n\n-- \nJane Doe",
+		},
+		{
+			name:   "dashes within text are left alone",
+			body:   `<p>Before -- after</p><h2>Hi</h2>`,
+			expect: "Before -- after\n\n--\nHi\n--",
+		},
 	})
 }
 
@@ -259,6 +409,21 @@ func TestHeadings(t *testing.T) {
 			body:   "<h3> <span class='a'>Test </span></h3>",
 			expect: "Test\n----",
 		},
+		{
+			name:   "h4",
+			body:   "<h4>Test</h4>",
+			expect: "Test\n~~~~",
+		},
+		{
+			name:   "h5",
+			body:   "<h5>Test</h5>",
+			expect: "Test\n^^^^",
+		},
+		{
+			name:   "h6",
+			body:   "<h6>Test</h6>",
+			expect: "Test\n....",
+		},
 	})
 }
 
@@ -327,6 +492,39 @@ func TestImgAltTags(t *testing.T) {
 	})
 }
 
+func TestDataURIs(t *testing.T) {
+	pixel := "data:image/png;base64," + strings.Repeat("iVBORw0K", 256)
+
+	runTestCases(t, []testCase{
+		{
+			name:   "image with alt",
+			body:   `<p>before <img src="` + pixel + `" alt="Logo"> after</p>`,
+			expect: "before Logo after",
+		},
+		{
+			name:   "image without alt",
+			body:   `<p>before <img src="` + pixel + `"> after</p>`,
+			expect: "before [inline image] after",
+		},
+		{
+			name:   "link with text",
+			body:   `<a href="data:text/plain;charset=utf-8,hello%20world">Download</a>`,
+			expect: "Download",
+		},
+		{
+			name:   "link without text",
+			body:   `<p><a href="` + pixel + `"></a></p>`,
+			expect: "[inline image]",
+		},
+	})
+
+	runTestCase(t, testCase{
+		name:   "size note",
+		body:   `<p>before <img src="` + pixel + `"> after</p>`,
+		expect: "before [inline image, 1.5 KB] after",
+	}, newConverters(textplain.WithDataURISizes())...)
+}
+
 func TestLinks(t *testing.T) {
 	runTestCases(t, []testCase{
 		{
@@ -407,13 +605,13 @@ func TestLinks(t *testing.T) {
 		{
 			name: "long links stay on a single line",
 			body: `<a href="http://example.com/` + strings.Repeat("A", textplain.DefaultLineLength) + `">Hello</a>`,
-			expect: `Hello 
+			expect: `Hello
 ( http://example.com/` + strings.Repeat("A", textplain.DefaultLineLength) + ` )`,
 		},
 		{
 			name: "long non-http links stay on a single line",
 			body: `<a href="gopher://example.com/` + strings.Repeat("A", textplain.DefaultLineLength) + `">Hello</a>`,
-			expect: `Hello 
+			expect: `Hello
 ( gopher://example.com/` + strings.Repeat("A", textplain.DefaultLineLength) + ` )`,
 		},
 		{
@@ -425,6 +623,72 @@ func TestLinks(t *testing.T) {
 
 }
 
+func TestLinksTextOnly(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name:   "simple link",
+			body:   `<a href="http://example.com/">Link</a>`,
+			expect: `Link`,
+		},
+		{
+			name:   "link with image alt",
+			body:   `<a href="http://example.com/"><img src="http://example.ru/hello.jpg" alt="Example"/></a>`,
+			expect: `Example`,
+		},
+		{
+			name:   "link wrapping image without alt",
+			body:   `<p>before <a href="http://example.com"><img src="https://images.com/image.png" /></a> after</p>`,
+			expect: `before after`,
+		},
+		{
+			name:   "mailto link",
+			body:   `<a href='mailto:contact@example.org'>Contact Us</a>`,
+			expect: `Contact Us`,
+		},
+	}, newConverters(textplain.WithLinkMode(textplain.LinkTextOnly))...)
+}
+
+func TestLinksAlwaysShowHref(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name:   "same text and link",
+			body:   `<a href="http://example.com">http://example.com</a>`,
+			expect: `http://example.com ( http://example.com )`,
+		},
+		{
+			name:   "simple link",
+			body:   `<a href="http://example.com/">Link</a>`,
+			expect: `Link ( http://example.com/ )`,
+		},
+	}, newConverters(textplain.WithAlwaysShowHref())...)
+}
+
+func TestLinksAngleBrackets(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name:   "simple link",
+			body:   `<a href="http://example.com/">Link</a>`,
+			expect: `Link <http://example.com/>`,
+		},
+		{
+			name:   "same text and link",
+			body:   `<a href="http://example.com">http://example.com</a>`,
+			expect: `http://example.com`,
+		},
+		{
+			name:   "complicated link",
+			body:   `<a href="http://example.com:80/~user?aaa=bb&amp;c=d,e,f#foo">Link</a>`,
+			expect: `Link <http://example.com:80/~user?aaa=bb&c=d,e,f#foo>`,
+		},
+	}, newConverters(textplain.WithLinkMode(textplain.LinkAngleBrackets))...)
+
+	runTestCase(t, testCase{
+		name:   "always show href",
+		body:   `<a href="http://example.com">http://example.com</a>`,
+		expect: `http://example.com <http://example.com>`,
+	}, newConverters(textplain.WithLinkMode(textplain.LinkAngleBrackets), textplain.WithAlwaysShowHref())...)
+}
+
 // see https://github.com/premailer/premailer/issues/72
 func TestMultipleLinksPerLine(t *testing.T) {
 	plain, err := textplain.Convert(`<p>This is <a href="http://www.google.com" >link1</a> and <a href="http://www.google.com" >link2 </a> is next.</p>`, 10000)
@@ -443,98 +707,1841 @@ func TestLinksWithinHeadings(t *testing.T) {
 	})
 }
 
-func TestStripsNonContentTags(t *testing.T) {
-	runTestCase(t, testCase{
-		body: `<html>
-			<body>
-				<script type="text/javascript">
-					alert("haxx");
-				</script>
-				No hacks here
-				<style>
-					p {
-						font-weight: bold;
-					}
-				</style>
-				<p>
-					This is not a bold statement
-				</p>
-			</body>
-		</html>`,
-		expect: "No hacks here\n\nThis is not a bold statement",
+func TestMergeTags(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name:   "handlebars",
+			body:   `<p>Hi {{ first_name }}, <a href="{{ url }}">view</a> <img alt="{{ alt }}" src="x.png"></p>`,
+			expect: `Hi {{ first_name }}, view ( {{ url }} ) {{ alt }}`,
+		},
+		{
+			name:   "liquid",
+			body:   `<p>{% if vip %}Welcome back{% endif %} <a href="{% url 'home' %}">home</a> <img alt="{% t 'logo' %}" src="x.png"></p>`,
+			expect: "{% if vip %}Welcome back{% endif %} home ( {% url 'home' %} )\n{% t 'logo' %}",
+		},
+		{
+			name:   "erb",
+			body:   `<p>Hi <%= user.name %>, <a href="<%= link %>">view</a> <img alt="<%= alt %>" src="x.png"></p>`,
+			expect: `Hi <%= user.name %>, view ( <%= link %> ) <%= alt %>`,
+		},
+		{
+			name:   "erb with comparison",
+			body:   `<p><% if count > 3 %>lots<% end %></p>`,
+			expect: `<% if count > 3 %>lots<% end %>`,
+		},
+		{
+			name:   "mailchimp",
+			body:   `<p>Hi *|FNAME|*, <a href="*|UNSUB|*">unsubscribe</a> <img alt='*|ALT|*' src="x.png"></p>`,
+			expect: `Hi *|FNAME|*, unsubscribe ( *|UNSUB|* ) *|ALT|*`,
+		},
+		{
+			name:   "merge tags aren't split by wrapping",
+			body:   `<p>` + strings.Repeat("A", textplain.DefaultLineLength-10) + ` {% if customer.first_name %}</p>`,
+			expect: strings.Repeat("A", textplain.DefaultLineLength-10) + "\n{% if customer.first_name %}",
+		},
 	})
 }
 
-func TestMultilineTitles(t *testing.T) {
-	runTestCase(t, testCase{
-		body: `<h1>Horse
-		Friends
-						Yeah
-</h1>`,
-		expect: "*******\nHorse\nFriends\nYeah\n*******",
+func TestTemplateControlBlocks(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name: "liquid",
+			body: `<p>Intro</p>
+			{% if vip %}
+			<p>Welcome back</p>
+			<p>Second</p>
+			{% else %}<p>Hello</p>{% endif %}
+			<p>Outro</p>`,
+			expect: "Intro\n\n{% if vip %}\nWelcome back\n\nSecond\n{% else %}\nHello\n{% endif %}\nOutro",
+		},
+		{
+			name:   "handlebars",
+			body:   `<p>Intro</p>{{#if promo}}<p>Use the code</p>{{else}}<p>No code</p>{{/if}}`,
+			expect: "Intro\n\n{{#if promo}}\nUse the code\n{{else}}\nNo code\n{{/if}}",
+		},
+		{
+			name:   "erb",
+			body:   `<p>Intro</p><% items.each do |item| %><p><%= item.name %></p><% end %>`,
+			expect: "Intro\n\n<% items.each do |item| %>\n<%= item.name %>\n<% end %>",
+		},
+		{
+			name:   "mailchimp",
+			body:   `<p>Intro</p>*|IF:VIP|*<p>Welcome back</p>*|END:IF|*`,
+			expect: "Intro\n\n*|IF:VIP|*\nWelcome back\n*|END:IF|*",
+		},
+		{
+			name:   "inline conditionals are left alone",
+			body:   `<p>Hello {% if vip %}VIP{% endif %} customer</p><p>{% if vip %}Thanks{% endif %}</p>`,
+			expect: "Hello {% if vip %}VIP{% endif %} customer\n\n{% if vip %}Thanks{% endif %}",
+		},
+		{
+			// vendor conditionals are resolved as clients other than Outlook render them,
+			// rather than preserved like template tags
+			name: "vendor conditional comments",
+			body: `<p>Intro</p>
+			<!--[if mso]><p>Outlook</p><![endif]-->
+			<!--[if !mso]><!--><p>Everyone else</p><!--<![endif]-->`,
+			expect: "Intro\n\nEveryone else",
+		},
 	})
 }
 
-func TestWrappingDoesntAddUnnecessaryLineBreaks(t *testing.T) {
-	runTestCase(t, testCase{
-		body: `.stylesheet {
-			color: white;
-			background-image: url('data:image/png;base64,` + strings.Repeat("A", textplain.DefaultLineLength) + `');
-			font-weight: bold;
-			margin: 0px;
-		}`,
-		expect: `.stylesheet {
-color: white;
-background-image:
-url('data:image/png;base64,` + strings.Repeat("A", textplain.DefaultLineLength) + `');
-font-weight: bold;
-margin: 0px;
-}`,
-	})
-}
+func TestPreheaders(t *testing.T) {
+	padding := strings.Repeat("&zwnj;&nbsp;", 100)
 
-func TestStrippingComments(t *testing.T) {
 	runTestCases(t, []testCase{
 		{
-			name:   "comment in tag",
-			body:   "<p>in<!--comment 1-->between</p>",
-			expect: "inbetween",
+			name: "display none",
+			body: `<html><head><style>p { color: red; }</style></head><body>
+				<div style="display:none;max-height:0px;overflow:hidden;">Don't miss our summer sale! ` + padding + `</div>
+				<p>Hello</p>
+			</body></html>`,
+			expect: "Hello",
 		},
 		{
-			name:   "comment between tags",
-			body:   `<p>before</p><!--comment 1--><p>after</p>`,
-			expect: "before\n\nafter",
+			name: "font size zero nested in tables",
+			body: `<table><tr><td>
+				<span style="font-size: 0; color: transparent">Preview text</span>
+				<div style="MAX-HEIGHT: 0; OVERFLOW: hidden">` + padding + `</div>
+				<p>Hello</p>
+			</td></tr></table>`,
+			expect: "Hello",
 		},
 		{
-			name:   "commented out tag",
-			body:   `<p>before</p><!--comment 1<div>random</div>--><p>after</p>`,
-			expect: "before\n\nafter",
+			name:   "bare zero-width padding",
+			body:   `<div>` + padding + `</div><p>Hello</p>`,
+			expect: "Hello",
 		},
 		{
-			name: "multiline comment",
-			body: `<p>before</p>
-			<!--
-				replacing unordered list with an ordered one.
-				uncomment out incase it breaks stuff.
-
-				<h2>An Unordered HTML List</h2>
-				<ul>
-				  <li>Coffee</li>
-				  <li>Tea</li>
-				  <li>Milk</li>
-				</ul>
-			-->
-			<p>after</p>`,
-			expect: "before\n\nafter",
+			name:   "hidden content after visible text is not a preheader",
+			body:   `<p>Hello</p><div style="mso-hide: all">World</div>`,
+			expect: "Hello\n\nWorld",
 		},
-		{
-			name: "comment within comment",
-			body: `<p>before</p>
-			<!--
-				some reason to comment out the whole block
+	})
 
-				<h2>An Unordered HTML List</h2>
-				<ul>
+	runTestCase(t, testCase{
+		name:   "opt out",
+		body:   `<p style="display:none">Preview text</p><p>Hello</p>`,
+		expect: "Preview text\n\nHello",
+	}, newConverters(textplain.WithKeepPreheader())...)
+}
+
+func TestHiddenElements(t *testing.T) {
+	body := `<p>Hello</p>
+		<p style="DISPLAY: none !important">Fallback content</p>
+		<div style="color: red;display:none"><p>Debugging markup</p></div>
+		<p>World <span style="display:none">secret</span></p>`
+
+	runTestCase(t, testCase{
+		name:   "display none",
+		body:   body,
+		expect: "Hello\n\nWorld",
+	})
+
+	runTestCases(t, []testCase{
+		{
+			name:   "visibility hidden",
+			body:   `<p>Hello <span style="visibility:hidden">secret</span></p><p style="visibility: hidden">Gone <span style="visibility: visible">visible</span></p>`,
+			expect: "Hello\n\nvisible",
+		},
+		{
+			name:   "visible again within hidden content",
+			body:   `<div style="visibility:hidden"><b>bold</b> <img alt="pic"> <span style="visibility:visible">shown <i>too</i></span> gone</div>`,
+			expect: "shown too",
+		},
+		{
+			name:   "hidden attribute",
+			body:   `<p>Hello</p><p hidden>secret</p><div hidden="hidden"><p>secret</p></div><p hidden style="display:block">World</p>`,
+			expect: "Hello\n\nWorld",
+		},
+		{
+			name:   "aria hidden",
+			body:   `<p><span aria-hidden="true">&#9733;</span> Hello</p><p aria-hidden="TRUE">Duplicate</p><p aria-hidden="false">World</p>`,
+			expect: "Hello\n\nWorld",
+		},
+		{
+			name:   "style parsing",
+			body:   `<p>Hello</p><p style="background:url('data:image/png;base64,AAAA'); DISPLAY : None">secret</p><p style="/* display:none; */ color: red">World</p>`,
+			expect: "Hello\n\nWorld",
+		},
+		{
+			name:   "opacity zero",
+			body:   `<p>Hello</p><p style="opacity:0">secret</p><p style="opacity: 0.5">World</p>`,
+			expect: "Hello\n\nWorld",
+		},
+		{
+			name:   "font size zero",
+			body:   `<p>Hello</p><p style="font-size:0px;line-height:0">secret</p>`,
+			expect: "Hello",
+		},
+		{
+			name:   "font size zero between inline-block columns",
+			body:   `<table><tr><td style="font-size:0"><div style="display:inline-block;font-size:14px"><p>Left</p></div><div style="display:inline-block;font-size:14px"><p>Right</p></div></td></tr></table>`,
+			expect: "Left\n\nRight",
+		},
+		{
+			name:   "max height zero",
+			body:   `<p>Hello</p><div style="max-height:0;overflow:hidden"><p>secret</p></div><div style="max-height:0"><p>World</p></div>`,
+			expect: "Hello\n\nWorld",
+		},
+	})
+
+	runTestCase(t, testCase{
+		name:   "opt out",
+		body:   body,
+		expect: "Hello\n\nFallback content\n\nDebugging markup\n\nWorld secret",
+	}, newConverters(textplain.WithKeepHidden())...)
+}
+
+func TestVML(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name: "bulletproof button",
+			body: `<p>Before</p>
+			<table><tr><td><v:roundrect xmlns:v="urn:schemas-microsoft-com:vml" href="http://example.com/shop" style="height:40px;width:200px;" arcsize="10%" fillcolor="#d62828"><v:fill type="tile" src="bg.png" color="#d62828"/><w:anchorlock/><center style="color:#ffffff;">Shop now</center></v:roundrect></td></tr></table>
+			<p>After</p>`,
+			expect: "Before\n\nShop now ( http://example.com/shop )\nAfter",
+		},
+		{
+			name:   "background image textbox",
+			body:   `<v:rect style="width:600px" fill="true"><v:fill type="frame" src="bg.jpg"/><v:textbox inset="0,0,0,0"><p>Inside textbox</p></v:textbox></v:rect><p>After</p>`,
+			expect: "Inside textbox\n\nAfter",
+		},
+		{
+			name:   "office paragraph markers",
+			body:   `<p>Hello<o:p></o:p> world<o:p>&nbsp;</o:p></p>`,
+			expect: "Hello world",
+		},
+	})
+}
+
+func TestWrapperFlattening(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name: "nested single-cell layout tables",
+			body: `<table class="mcnTextBlock"><tbody class="mcnTextBlockOuter"><tr><td class="mcnTextBlockInner">
+				<table class="mcnTextContentContainer"><tbody><tr><td class="mcnTextContent"><p>Hello</p></td></tr></tbody></table>
+			</td></tr></tbody></table>
+			<table class="mcnTextBlock"><tbody class="mcnTextBlockOuter"><tr><td class="mcnTextBlockInner">
+				<table class="mcnTextContentContainer"><tbody><tr><td class="mcnTextContent"><p>World</p></td></tr></tbody></table>
+			</td></tr></tbody></table>`,
+			expect: "Hello\n\nWorld",
+		},
+		{
+			name:   "wrapper divs",
+			body:   `<div class="wrapper"><div class="inner"><div class="content"><p>Hello</p></div></div></div><div><div><p>World</p></div></div>`,
+			expect: "Hello\n\nWorld",
+		},
+		{
+			name:   "wrapper state is preserved",
+			body:   `<div style="display: none"><div><p>Hidden preheader</p></div></div><div><div><p>Hello</p></div></div>`,
+			expect: "Hello",
+		},
+		{
+			name:   "multi-cell tables are left alone",
+			body:   `<table><tr><td><p>A</p></td><td><p>B</p></td></tr></table>`,
+			expect: "A\n\nB",
+		},
+	})
+}
+
+func TestClassRules(t *testing.T) {
+	body := `<p class="intro">Hello</p>
+		<div class="desktop-only"><p>Wide layout</p></div>
+		<p class="content textplain-hide">Decoration</p>
+		<p class="content">Main story</p>
+		<div class="footer"><p class="content">Legal</p></div>`
+
+	runTestCase(t, testCase{
+		name:   "skip classes",
+		body:   body,
+		expect: "Hello\n\nMain story\n\nLegal",
+	}, newConverters(textplain.WithSkipClasses("textplain-hide", "desktop-only"))...)
+
+	runTestCase(t, testCase{
+		name:   "only classes",
+		body:   body,
+		expect: "Decoration\n\nMain story\n\nLegal",
+	}, newConverters(textplain.WithOnlyClasses("content"))...)
+
+	runTestCase(t, testCase{
+		name:   "skip and only classes",
+		body:   body,
+		expect: "Main story\n\nLegal",
+	}, newConverters(textplain.WithOnlyClasses("content"), textplain.WithSkipClasses("textplain-hide"))...)
+}
+
+func TestExcludeSelector(t *testing.T) {
+	body := `<div id="preheader">Preview text</div>
+	<table class="layout"><tr><td class="content"><p>Hello</p><p class="legal">Fine print</p></td></tr></table>
+	<div class="footer"><p class="legal">Company address</p><p>Goodbye</p></div>`
+
+	runTestCases(t, []testCase{
+		{
+			name:   "selector list",
+			body:   body,
+			expect: "Hello\n\nGoodbye",
+		},
+	}, newConverters(textplain.WithExcludeSelector(".legal, #preheader"))...)
+
+	combinators := "#preheader, td > p.legal, body > .footer > .legal, table p.missing"
+	runTestCases(t, []testCase{
+		{
+			name:   "descendant and child combinators",
+			body:   body,
+			expect: "Hello\n\nGoodbye",
+		},
+	}, newConverters(textplain.WithExcludeSelector(combinators))...)
+
+	unsupported := "p:first-child, p + p, [class=legal], .footer >"
+	runTestCases(t, []testCase{
+		{
+			name:   "unsupported selectors are ignored",
+			body:   `<p class="legal">Hello</p><p>Goodbye</p>`,
+			expect: "Hello\n\nGoodbye",
+		},
+	}, newConverters(textplain.WithExcludeSelector(unsupported))...)
+}
+
+func TestOnlySelector(t *testing.T) {
+	body := `<div class="header"><p>Newsletter</p></div>
+	<table><tr><td id="main-content"><h1>Story</h1><p>Article body</p></td></tr></table>
+	<table><tr><td class="extra"><p>Bonus</p></td></tr></table>
+	<div class="footer"><p>Unsubscribe</p></div>`
+
+	runTestCases(t, []testCase{
+		{
+			name:   "single subtree",
+			body:   body,
+			expect: "*****\nStory\n*****\n\nArticle body",
+		},
+	}, newConverters(textplain.WithOnlySelector("#main-content"))...)
+
+	runTestCases(t, []testCase{
+		{
+			name:   "combined with classes",
+			body:   body,
+			expect: "Article body\n\nBonus",
+		},
+	}, newConverters(textplain.WithOnlySelector("td#main-content > p"), textplain.WithOnlyClasses("extra"))...)
+}
+
+func TestUnsubscribeFooter(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name: "links are moved to the footer",
+			body: `<p>Hello <a href="http://example.com/">world</a></p>
+			<p>Don't want these emails?</p>
+			<p><a href="http://example.com/u?id=1">Unsubscribe</a></p>
+			<p><a href="http://example.com/email-preferences">Update your preferences</a></p>`,
+			expect: "Hello world ( http://example.com/ )\n\nDon't want these emails?\n\n----------\n" +
+				"Unsubscribe ( http://example.com/u?id=1 )\nUpdate your preferences ( http://example.com/email-preferences )",
+		},
+		{
+			name:   "detected by href",
+			body:   `<p>To stop receiving these emails click <a href="*|UNSUB|*">here</a></p>`,
+			expect: "To stop receiving these emails click\n\n----------\nUnsubscribe ( *|UNSUB|* )",
+		},
+		{
+			name:   "mail links",
+			body:   `<p>Reply to <a href="mailto:unsub@example.com">unsubscribe</a></p>`,
+			expect: "Reply to\n\n----------\nunsubscribe ( unsub@example.com )",
+		},
+		{
+			name:   "other preferences",
+			body:   `<p>Update your <a href="http://example.com/travel">travel preferences</a></p><p><a href="http://example.com/preference-center">Preferences</a></p>`,
+			expect: "Update your travel preferences ( http://example.com/travel )\n\n----------\nPreferences ( http://example.com/preference-center )",
+		},
+		{
+			name:   "no unsubscribe links",
+			body:   `<p>Hello</p>`,
+			expect: "Hello",
+		},
+	}, newConverters(textplain.WithUnsubscribeFooter())...)
+}
+
+func TestDedupeSiblings(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name: "light and dark variants",
+			body: `<div class="light"><p>Big sale</p><p><img src="light.png" alt="Shop now"></p></div>
+			<div class="dark"><p>Big sale</p><p><img src="dark.png" alt="Shop now"></p></div>
+			<p>Thanks</p>`,
+			expect: "Big sale\n\nShop now\n\nThanks",
+		},
+		{
+			name:   "near-identical variants",
+			body:   `<p>Our biggest sale of the year starts today, do not miss out on these deals</p><p>Our biggest sale of the year starts today! Do not miss out on these deals.</p>`,
+			expect: "Our biggest sale of the year starts today, do not miss out on\nthese deals",
+		},
+		{
+			name:   "different content is kept",
+			body:   `<p>First story</p><p>Second story</p><p>Second story continued</p>`,
+			expect: "First story\n\nSecond story\n\nSecond story continued",
+		},
+		{
+			name:   "list items are kept",
+			body:   `<ul><li>Yes</li><li>Yes</li></ul>`,
+			expect: "* Yes\n* Yes",
+		},
+	}, newConverters(textplain.WithDedupeSiblings())...)
+}
+
+func TestWhiteSpaceStyles(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name:   "pre",
+			body:   "<p>Totals</p><p style=\"white-space: pre\">Item      Qty\nWidget    2\nGadget    10</p><p>Thanks</p>",
+			expect: "Totals\n\nItem      Qty\nWidget    2\nGadget    10\n\nThanks",
+		},
+		{
+			name:   "pre-wrap is wrapped",
+			body:   `<p style="white-space:pre-wrap">  This   line is long enough that it is wrapped at the configured line length</p>`,
+			expect: "  This   line is long enough that it is wrapped at the configured\nline length",
+		},
+		{
+			name:   "pre is not wrapped",
+			body:   `<p style="white-space:pre">This line is long enough that it would normally be wrapped at the configured line length</p>`,
+			expect: "This line is long enough that it would normally be wrapped at the configured line length",
+		},
+		{
+			name:   "pre-line collapses spaces",
+			body:   "<p style=\"white-space:pre-line\">  First   line  \n  Second  <b>line</b></p>",
+			expect: "First line\nSecond line",
+		},
+		{
+			name:   "pre element",
+			body:   "<p>Output</p><pre>$ make\n  ok    1.2s</pre><p>Done</p>",
+			expect: "Output\n\n$ make\n  ok    1.2s\n\nDone",
+		},
+		{
+			name:   "links and images within pre",
+			body:   `<pre>see <a href="http://example.com/a">link</a> <img alt="pic"></pre>`,
+			expect: "see link ( http://example.com/a ) pic",
+		},
+		{
+			name:   "blank lines and tabs within pre",
+			body:   "<p>Before</p><pre>\n\nfirst\n\n\n  second\tcol\n\n</pre><p>After</p>",
+			expect: "Before\n\nfirst\n\n\n  second        col\n\nAfter",
+		},
+		{
+			name:   "pre element styled otherwise",
+			body:   "<pre style=\"white-space:normal\">one    two</pre>",
+			expect: "one two",
+		},
+	})
+}
+
+func TestNoWrap(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name:   "nowrap style",
+			body:   `<p>For help with your order please call our team any time on <span style="white-space:nowrap">+1 (555) 010 4477</span></p>`,
+			expect: "For help with your order please call our team any time on\n+1 (555) 010 4477",
+		},
+		{
+			name:   "nobr",
+			body:   `<p>Your order comes to a grand total, with all taxes, of exactly <nobr>USD 1 024.00</nobr></p>`,
+			expect: "Your order comes to a grand total, with all taxes, of exactly\nUSD 1 024.00",
+		},
+		{
+			name:   "nested markup",
+			body:   `<p>Your order comes to a grand total, including all taxes, of <nobr><b>USD</b> 1 024.00</nobr></p>`,
+			expect: "Your order comes to a grand total, including all taxes, of\nUSD 1 024.00",
+		},
+	})
+}
+
+func TestTextTransform(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name:   "uppercase",
+			body:   `<p>Hello <a href="http://example.com/shop" style="text-transform: uppercase">shop now</a></p>`,
+			expect: "Hello SHOP NOW ( http://example.com/shop )",
+		},
+		{
+			name:   "inherited lowercase",
+			body:   `<table><tr><td style="text-transform:lowercase"><p>SHOUTING <b>TEXT</b></p></td></tr></table>`,
+			expect: "shouting text",
+		},
+		{
+			name:   "capitalize",
+			body:   `<p style="text-transform:capitalize">the (really) big e-mail sale</p>`,
+			expect: "The (Really) Big E-mail Sale",
+		},
+		{
+			name:   "none resets",
+			body:   `<p style="text-transform:uppercase">Big <span style="text-transform:none">sale</span></p>`,
+			expect: "BIG sale",
+		},
+		{
+			name:   "merge tags are kept",
+			body:   `<p style="text-transform:uppercase">Hi {{ first_name }}</p>`,
+			expect: "HI {{ first_name }}",
+		},
+	})
+}
+
+func TestEmphasisMarkers(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name:   "markup",
+			body:   `<p>This is <strong>important</strong> and <em>subtle</em></p>`,
+			expect: "This is *important* and _subtle_",
+		},
+		{
+			name:   "inline styles",
+			body:   `<p>This is <span style="font-weight: 700">important</span> and <span style="font-style:italic">subtle</span></p>`,
+			expect: "This is *important* and _subtle_",
+		},
+		{
+			name:   "whitespace stays outside",
+			body:   `<p>Now<span style="font-weight:bold"> 50% off </span>everything</p>`,
+			expect: "Now *50% off* everything",
+		},
+		{
+			name:   "bold and italic",
+			body:   `<p><b style="font-style:italic">Both</b></p>`,
+			expect: "*_Both_*",
+		},
+		{
+			name:   "nested emphasis is marked once",
+			body:   `<p><b>Very <strong>very</strong> bold</b> <span style="font-weight:bold">plain <span style="font-weight:normal">normal</span></span></p>`,
+			expect: "*Very very bold* *plain* normal",
+		},
+		{
+			name:   "emphasis reset within emphasis",
+			body:   `<p><b>one <span style="font-weight:400">two <b>three</b></span> four</b> <em>five <span style="font-style:normal">six</span></em></p>`,
+			expect: "*one* two *three* *four* _five_ six",
+		},
+		{
+			name:   "block containers mark their blocks",
+			body:   `<table><tr><td style="font-weight:bold"><p>First</p><p>Second</p></td></tr></table>`,
+			expect: "*First*\n\n*Second*",
+		},
+		{
+			name:   "headings are not marked",
+			body:   `<h2 style="font-weight:bold">Title</h2>`,
+			expect: "-----\nTitle\n-----",
+		},
+	}, newConverters(textplain.WithEmphasisMarkers())...)
+
+	runTestCase(t, testCase{
+		name:   "disabled by default",
+		body:   `<p>This is <span style="font-weight:bold">important</span></p>`,
+		expect: "This is important",
+	})
+}
+
+func TestStyleSheetHiding(t *testing.T) {
+	head := `<html><head><style type="text/css">
+	/* variants */
+	.mobile-only, #legacy { display: none; }
+	td.desktop { DISPLAY:NONE !important }
+	p.note { color: red }
+	.promo:hover { display: none }
+	@media only screen and (max-width: 480px) {
+		.mobile-only { display: block !important; }
+		.desktop { display: none !important; }
+	}
+	</style></head><body>`
+
+	runTestCases(t, []testCase{
+		{
+			name:   "class and id selectors",
+			body:   head + `<p class="intro mobile-only">Mobile</p><p class="intro">Hello</p><p id="legacy">Old</p></body></html>`,
+			expect: "Hello",
+		},
+		{
+			name:   "tag qualified selector",
+			body:   head + `<table><tr><td class="desktop" style="display:block">Desktop</td></tr></table><p class="desktop note">Kept</p></body></html>`,
+			expect: "Kept",
+		},
+		{
+			name:   "inline style takes precedence",
+			body:   head + `<p class="mobile-only" style="display:block">Shown</p></body></html>`,
+			expect: "Shown",
+		},
+		{
+			name:   "unsupported selectors are ignored",
+			body:   head + `<p class="promo">Promo</p></body></html>`,
+			expect: "Promo",
+		},
+	})
+
+	runTestCase(t, testCase{
+		name:   "kept when hidden content is kept",
+		body:   head + `<p>Hello</p><p class="mobile-only">Mobile</p></body></html>`,
+		expect: "Hello\n\nMobile",
+	}, newConverters(textplain.WithKeepHidden())...)
+}
+
+func TestTargetClient(t *testing.T) {
+	body := `<html><head><style>
+	.mobile-only { display: none; }
+	@media only screen and (max-width: 600px), screen and (max-device-width: 37.5em) {
+		.mobile-only { display: block !important; max-height: none !important; }
+		.desktop-nav { display: none !important; }
+	}
+	@media screen and (min-width: 601px) { .wide { display: none } }
+	@media print, (prefers-color-scheme: dark) { .screen { display: none } }
+	</style></head><body>
+	<p class="desktop-nav">Desktop menu</p>
+	<div class="mobile-only" style="display:none;max-height:0;overflow:hidden"><p>Mobile menu</p></div>
+	<p class="screen">Content</p>
+	<p class="hide-on-mobile">Wide banner</p>
+	<p class="show-on-mobile">Tap to call</p>
+	<p class="wide">Narrow screens only</p>
+	</body></html>`
+
+	runTestCase(t, testCase{
+		name:   "any client",
+		body:   body,
+		expect: "Desktop menu\n\nContent\n\nWide banner\n\nTap to call\n\nNarrow screens only",
+	})
+
+	runTestCase(t, testCase{
+		name:   "desktop",
+		body:   body,
+		expect: "Desktop menu\n\nContent\n\nWide banner",
+	}, newConverters(textplain.WithTargetClient(textplain.ClientDesktop))...)
+
+	runTestCase(t, testCase{
+		name:   "mobile",
+		body:   body,
+		expect: "Mobile menu\n\nContent\n\nTap to call\n\nNarrow screens only",
+	}, newConverters(textplain.WithTargetClient(textplain.ClientMobile))...)
+}
+
+func TestTextDirection(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name:   "rtl block",
+			body:   `<p>Hello</p><p dir="rtl">2024 שלום עולם</p>`,
+			expect: "Hello\n\n\u200f2024 שלום עולם",
+		},
+		{
+			name:   "rtl document",
+			body:   `<html dir="rtl"><body><table><tr><td><p>مرحبا</p><p dir="ltr">Hello</p></td></tr></table></body></html>`,
+			expect: "\u200fمرحبا\n\n\u200eHello",
+		},
+		{
+			name:   "ltr document is unmarked",
+			body:   `<html dir="ltr"><body><p>Hello</p></body></html>`,
+			expect: "Hello",
+		},
+		{
+			name:   "inline direction is isolated",
+			body:   `<p>The word <span dir="rtl">שלום</span> means hello</p>`,
+			expect: "The word \u2067שלום\u2069 means hello",
+		},
+		{
+			name:   "bdi",
+			body:   `<p>User <bdi>إيان</bdi>: 3 posts</p>`,
+			expect: "User \u2068إيان\u2069: 3 posts",
+		},
+		{
+			name:   "bdo",
+			body:   `<p><bdo dir="rtl">abc</bdo></p>`,
+			expect: "\u202eabc\u202c",
+		},
+	})
+}
+
+func TestLanguageRules(t *testing.T) {
+	frenchSpacing := strings.NewReplacer(" :", "\u00a0:", " ;", "\u00a0;", " !", "\u00a0!", " ?", "\u00a0?")
+	opts := []textplain.Option{
+		textplain.WithLanguageRules("de", textplain.LanguageRules{Quotes: [2]string{"\u201e", "\u201c"}}),
+		textplain.WithLanguageRules("de-CH", textplain.LanguageRules{Quotes: [2]string{"\u00ab", "\u00bb"}}),
+		textplain.WithLanguageRules("fr", textplain.LanguageRules{Transform: frenchSpacing.Replace}),
+	}
+
+	runTestCases(t, []testCase{
+		{
+			name:   "document language",
+			body:   `<html lang="de"><body><p>Er sagte <q>Hallo</q></p></body></html>`,
+			expect: "Er sagte \u201eHallo\u201c",
+		},
+		{
+			name:   "longest prefix wins",
+			body:   `<html lang="de-CH"><body><p>Er sagte <q>Hallo</q></p></body></html>`,
+			expect: "Er sagte \u00abHallo\u00bb",
+		},
+		{
+			name:   "regions",
+			body:   `<html lang="en"><body><p>He said <q>Hello</q></p><p lang="fr-FR">Vraiment ? Oui !</p><p>Really ?</p></body></html>`,
+			expect: "He said Hello\n\nVraiment\u00a0? Oui\u00a0!\n\nReally ?",
+		},
+	}, newConverters(opts...)...)
+}
+
+func TestLanguageListsAndLineBreaks(t *testing.T) {
+	opts := []textplain.Option{
+		textplain.WithOrderedLists(),
+		textplain.WithLanguageRules("fr", textplain.LanguageRules{ListMarker: "- ", ListNumberSuffix: ") ", NoLineStart: "!?:;\u00bb", NoLineEnd: "\u00ab"}),
+	}
+	words := strings.Repeat("mot ", 15)
+
+	runTestCases(t, []testCase{
+		{
+			name:   "bullets",
+			body:   `<html><body><div lang="fr"><ul><li>un</li><li>deux</li></ul></div></body></html>`,
+			expect: "- un\n- deux",
+		},
+		{
+			name:   "numbers",
+			body:   `<html lang="fr"><body><ol><li>premier</li><li>second</li></ol></body></html>`,
+			expect: "1) premier\n2) second",
+		},
+		{
+			name:   "item outside of a list",
+			body:   `<html lang="fr"><body><li>seul</li></body></html>`,
+			expect: "- seul",
+		},
+		{
+			name:   "bullets outside the region",
+			body:   `<html><body><ul><li>one</li></ul></body></html>`,
+			expect: "* one",
+		},
+		{
+			name:   "punctuation kept on the line",
+			body:   `<html lang="fr"><body><p>` + words + `fini !</p><p>` + words + "a \u00ab fin</p></body></html>",
+			expect: strings.TrimSpace(words) + "\nfini !\n\n" + words + "a\n\u00ab fin",
+		},
+		{
+			name:   "other languages",
+			body:   `<html lang="en"><body><p>` + words + `done !</p></body></html>`,
+			expect: words + "done\n!",
+		},
+	}, newConverters(opts...)...)
+}
+
+func TestHeadingUnderlineWidth(t *testing.T) {
+	runTestCase(t, testCase{
+		name:   "multibyte heading",
+		body:   "<h1>Caf\u00e9 \U0001F1EB\U0001F1F7</h1>",
+		expect: "******\nCaf\u00e9 \U0001F1EB\U0001F1F7\n******",
+	})
+}
+
+func TestHardWrapOption(t *testing.T) {
+	runTestCase(t, testCase{
+		name:   "long link",
+		body:   `<p>Confirm your address at <a href="https://example.com/confirm?token=0123456789abcdefghijklmnopqrstuvwxyz0123456789">this link</a></p>`,
+		expect: "Confirm your address at this link\n( https://example.com/confirm?token=0123456789abcdefghijklmnopqr-\nstuvwxyz0123456789 )",
+	}, newConverters(textplain.WithHardWrap("-"))...)
+}
+
+func TestBreakCharactersOption(t *testing.T) {
+	runTestCase(t, testCase{
+		name:   "hyphenated product name",
+		body:   `<p>Thanks for ordering the Ultra-Compact-Travel-Adapter-With-USB-C-Charging-Port</p>`,
+		expect: "Thanks for ordering the Ultra-Compact-Travel-Adapter-With-USB-C-\nCharging-Port",
+	}, newConverters(textplain.WithBreakCharacters(textplain.BreakCharacters))...)
+
+	runTestCase(t, testCase{
+		name:   "urls are never broken",
+		body:   `<p>Read the guide at https://example.com/docs/getting-started/installation-guide or <a href="https://example.com/a/b">online</a></p>`,
+		expect: "Read the guide at\nhttps://example.com/docs/getting-started/installation-guide or\nonline ( https://example.com/a/b )",
+	}, newConverters(textplain.WithBreakCharacters(textplain.BreakCharacters))...)
+}
+
+func TestMinimumRaggednessOption(t *testing.T) {
+	for _, converter := range newConverters(textplain.WithMinimumRaggedness()) {
+		result, err := converter.Convert(`<p>aaa bb cc ddddd</p><ul><li>aa bb cc ddd</li></ul>`, 6)
+		assert.Nil(t, err)
+		assert.Equal(t, "aaa\nbb cc\nddddd\n\n* aa\n  bb\n  cc\n  ddd", result)
+	}
+}
+
+func TestWrappedListItems(t *testing.T) {
+	runTestCase(t, testCase{
+		name: "hanging indent",
+		body: `<ul><li>This is a rather long list item that will certainly need to be wrapped onto a second line</li><li>Short</li>
+		<li>Read the <a href="https://example.com/some/long/path/to/an/article">full article about wrapping</a> online</li></ul>`,
+		expect: "* This is a rather long list item that will certainly need to be\n  wrapped onto a second line\n* Short\n" +
+			"* Read the full article about wrapping\n  ( https://example.com/some/long/path/to/an/article ) online",
+	})
+}
+
+func TestNormalization(t *testing.T) {
+	body := "<p>Cafe&#x301; \ufb01nance\u00a0team \uff21\uff22</p>"
+
+	runTestCase(t, testCase{
+		name:   "unnormalized",
+		body:   body,
+		expect: "Cafe\u0301 \ufb01nance\u00a0team \uff21\uff22",
+	})
+
+	runTestCase(t, testCase{
+		name:   "nfc",
+		body:   body,
+		expect: "Caf\u00e9 \ufb01nance\u00a0team \uff21\uff22",
+	}, newConverters(textplain.WithNormalization(textplain.NormalizeNFC))...)
+
+	runTestCase(t, testCase{
+		name:   "nfkc",
+		body:   body,
+		expect: "Caf\u00e9 finance team AB",
+	}, newConverters(textplain.WithNormalization(textplain.NormalizeNFKC))...)
+}
+
+func TestInvisibleCharacters(t *testing.T) {
+	family := "\U0001F468\u200d\U0001F469\u200d\U0001F467"
+
+	runTestCases(t, []testCase{
+		{
+			name:   "zero width padding",
+			body:   "<p>Hello\u200b\u200c\u200d World\u00ad&#8203;</p>",
+			expect: "Hello World",
+		},
+		{
+			name:   "joiners between visible characters",
+			body:   "<p>" + family + " \u0645\u06cc\u200c\u062e\u0648\u0627\u0647\u0645</p>",
+			expect: family + " \u0645\u06cc\u200c\u062e\u0648\u0627\u0647\u0645",
+		},
+		{
+			name:   "control characters",
+			body:   "<p>Ding\x07 dong\u0085</p>",
+			expect: "Ding dong",
+		},
+		{
+			name:   "bidi overrides",
+			body:   "<p>invoice\u202egpj.exe</p><p><bdo dir=\"rtl\">abc</bdo></p>",
+			expect: "invoicegpj.exe\n\n\u202eabc\u202c",
+		},
+		{
+			name:   "image alt text",
+			body:   "<p><img src=\"logo.png\" alt=\"\u200bLogo\"></p>",
+			expect: "Logo",
+		},
+	})
+
+	runTestCase(t, testCase{
+		name:   "opt out",
+		body:   "<p>Hello\u200b World</p>",
+		expect: "Hello\u200b World",
+	}, newConverters(textplain.WithKeepInvisibleCharacters())...)
+}
+
+func TestParagraphSeparator(t *testing.T) {
+	body := `<h2>Title</h2><p>First paragraph</p><p>Second<br>paragraph</p><!-- start text/plain -->Verbatim
+
+text<!-- end text/plain -->`
+
+	runTestCase(t, testCase{
+		name:   "single newline",
+		body:   body,
+		expect: "-----\nTitle\n-----\nFirst paragraph\nSecond\nparagraph\nVerbatim\n\ntext",
+	}, newConverters(textplain.WithParagraphSeparator("\n"))...)
+
+	runTestCase(t, testCase{
+		name:   "marker line",
+		body:   body,
+		expect: "-----\nTitle\n-----\n~\nFirst paragraph\n~\nSecond\nparagraph\n~\nVerbatim\n\ntext",
+	}, newConverters(textplain.WithParagraphSeparator("\n~\n"))...)
+}
+
+func TestMaxBlankLines(t *testing.T) {
+	body := `<p>First</p><br><br><br><p>Second<br>line</p><ul><li>a</li><li>b</li></ul>`
+
+	for _, tc := range []struct {
+		name   string
+		max    int
+		expect string
+	}{
+		{"default limit", 1, "First\n\nSecond\nline\n\n* a\n* b"},
+		{"compact", 0, "First\nSecond\nline\n* a\n* b"},
+		{"two blank lines", 2, "First\n\n\nSecond\nline\n\n* a\n* b"},
+		{"unlimited", -1, "First\n\n\n\n\nSecond\nline\n\n* a\n* b"},
+	} {
+		runTestCase(t, testCase{
+			name:   tc.name,
+			body:   body,
+			expect: tc.expect,
+		}, newConverters(textplain.WithMaxBlankLines(tc.max))...)
+	}
+}
+
+func TestKeepSurroundingWhitespace(t *testing.T) {
+	body := `<br><br>Fragment<br>`
+
+	runTestCase(t, testCase{
+		name:   "trimmed by default",
+		body:   body,
+		expect: "Fragment",
+	})
+
+	runTestCase(t, testCase{
+		name:   "kept",
+		body:   body,
+		expect: "\n\nFragment\n",
+	}, newConverters(textplain.WithKeepSurroundingWhitespace())...)
+}
+
+func TestNoTrailingWhitespace(t *testing.T) {
+	documents := []string{
+		html,
+		`<p>Many   spaced   words  ` + strings.Repeat("word ", 30) + `</p><ul><li>item ` + strings.Repeat("word ", 30) + `</li></ul>`,
+		`<!-- start text/plain -->line with spaces   ` + "\n\t" + `line with a tab	<!-- end text/plain -->`,
+		`<p style="white-space:pre">preformatted   ` + "\n" + `text   </p>`,
+		`<p>Thanks</p><p>-- <br>Jane</p>`,
+	}
+
+	converters := append(newConverters(), newConverters(textplain.WithHardWrap(""))...)
+	converters = append(converters, textplain.NewTreeConverter(textplain.WithNormalization(textplain.NormalizeNFKC)))
+
+	for _, converter := range converters {
+		for _, document := range documents {
+			result, err := converter.Convert(document, 20)
+			assert.Nil(t, err)
+			for _, line := range strings.Split(result, "\n") {
+				if line != "-- " {
+					assert.Equal(t, strings.TrimRight(line, " \t"), line)
+				}
+			}
+		}
+	}
+
+	result, err := textplain.NewTreeConverter().Convert(`<p>Thanks</p><p>-- <br>Jane</p>`, textplain.DefaultLineLength)
+	assert.Nil(t, err)
+	assert.Equal(t, "Thanks\n\n-- \nJane", result)
+}
+
+func TestSpacingRules(t *testing.T) {
+	body := `<h2>Lists</h2><p>Two lists</p><ul><li>a</li></ul><ul><li>b</li></ul>`
+
+	runTestCases(t, []testCase{
+		{
+			name:   "default rules",
+			body:   body,
+			expect: "-----\nLists\n-----\n\nTwo lists\n\n* a\n* b",
+		},
+	}, textplain.NewTreeConverter())
+
+	runTestCases(t, []testCase{
+		{
+			name:   "no rules",
+			body:   body,
+			expect: "-----\nLists\n-----\n\nTwo lists\n\n* a\n\n* b",
+		},
+	}, textplain.NewTreeConverter(textplain.WithSpacingRules()))
+
+	runTestCases(t, []testCase{
+		{
+			name:   "headings followed directly by their content",
+			body:   body,
+			expect: "-----\nLists\n-----\nTwo lists\n\n* a\n* b",
+		},
+	}, textplain.NewTreeConverter(textplain.WithSpacingRules(
+		textplain.CompactListRuns(textplain.IsListItem),
+		textplain.BlankLinesAfter(textplain.IsHeadingRule, 0),
+	)))
+}
+
+func TestTypographicFolding(t *testing.T) {
+	body := "<p>\u201cIt\u2019s 9\u20135,\u00a0mostly\u2014and then\u2026\u201d</p><img alt=\"\u2018Logo\u2019\"><!-- start text/plain -->\u00abverbatim\u00bb\u2009text<!-- end text/plain -->"
+
+	runTestCase(t, testCase{
+		name:   "folded",
+		body:   body,
+		expect: "\"It's 9-5, mostly--and then...\"\n\n'Logo'\n\n\"verbatim\" text",
+	}, newConverters(textplain.WithTypographicFolding())...)
+
+	runTestCase(t, testCase{
+		name:   "untouched by default",
+		body:   "<p>\u201cWait\u2026\u201d</p>",
+		expect: "\u201cWait\u2026\u201d",
+	})
+}
+
+func TestEmojiOption(t *testing.T) {
+	body := "<p>Great job \U0001F44D\U0001F3FD and \u2764\ufe0f from \U0001F1E9\U0001F1EA \U0001FAE0</p><p>Rated \u2605\u2605\u2605</p><!-- start text/plain -->Ready \U0001F680<!-- end text/plain -->"
+
+	runTestCase(t, testCase{
+		name:   "shortcodes",
+		body:   body,
+		expect: "Great job :thumbsup: and :heart: from :flag_de:\n\nRated \u2605\u2605\u2605\n\nReady :rocket:",
+	}, newConverters(textplain.WithEmoji(textplain.EmojiShortcodes))...)
+
+	runTestCase(t, testCase{
+		name:   "stripped",
+		body:   body,
+		expect: "Great job and from\n\nRated \u2605\u2605\u2605\n\nReady",
+	}, newConverters(textplain.WithEmoji(textplain.EmojiStrip))...)
+
+	runTestCase(t, testCase{
+		name:   "untouched by default",
+		body:   "<p>Ready \U0001F680</p>",
+		expect: "Ready \U0001F680",
+	})
+}
+
+func TestUnicodeSpaces(t *testing.T) {
+	body := "<p>10&thinsp;000 items&hairsp;&mdash;&hairsp;now &ensp; or&emsp;never,&numsp;&nbsp;ok</p>"
+
+	runTestCase(t, testCase{
+		name:   "collapsed",
+		body:   body,
+		expect: "10 000 items \u2014 now or never, \u00a0ok",
+	})
+
+	runTestCase(t, testCase{
+		name:   "kept",
+		body:   body,
+		expect: "10\u2009000 items\u200a\u2014\u200anow \u2002 or\u2003never,\u2007\u00a0ok",
+	}, newConverters(textplain.WithKeepUnicodeSpaces())...)
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	body := "<p>Caf\xe9 cr\xe8me \xff\xfe</p><img alt=\"Logo\x80\"><!-- start text/plain -->na\xefve<!-- end text/plain -->"
+
+	runTestCase(t, testCase{
+		name:   "replaced",
+		body:   body,
+		expect: "Caf\ufffd cr\ufffdme \ufffd\n\nLogo\ufffd\n\nna\ufffdve",
+	})
+
+	runTestCase(t, testCase{
+		name:   "removed",
+		body:   body,
+		expect: "Caf crme\n\nLogo\n\nnave",
+	}, newConverters(textplain.WithInvalidUTF8Replacement(""))...)
+}
+
+func TestBlockLineLength(t *testing.T) {
+	body := "<h1>A heading far too long to fit on a single line of the default length</h1><p>Body text is wrapped at the default length, which leaves a line of sixty-five characters.</p>"
+
+	runTestCase(t, testCase{
+		name:   "configured blocks",
+		body:   body,
+		expect: "********************************************************************\nA heading far too long to fit on a single line of the default length\n********************************************************************\n\nBody text is wrapped at the default length, which leaves a line\nof sixty-five characters.",
+	}, newConverters(textplain.WithBlockLineLength("h1", 0))...)
+
+	for _, converter := range newConverters(textplain.WithBlockLineLength("small", 20)) {
+		result, err := converter.Convert("<p>Unwrapped text runs on for as long as it needs to.</p><p><small>Wrapped text within the small element</small></p>", 0)
+		assert.Nil(t, err)
+		assert.Equal(t, "Unwrapped text runs on for as long as it needs to.\n\nWrapped text within\nthe small element", result)
+	}
+}
+
+func TestHeadingStyles(t *testing.T) {
+	opts := []textplain.Option{
+		textplain.WithHeadingStyle(1, textplain.HeadingStyle{Rule: "="}),
+		textplain.WithHeadingStyle(2, textplain.HeadingStyle{}),
+		textplain.WithHeadingStyle(3, textplain.HeadingStyle{Rule: "~", Boxed: true}),
+	}
+
+	runTestCase(t, testCase{
+		body:   "<h1>Title</h1><h2>Section</h2><h3>Subsection</h3><h4>Detail</h4>",
+		expect: "Title\n=====\n\nSection\n\n~~~~~~~~~~\nSubsection\n~~~~~~~~~~\n\nDetail\n~~~~~~",
+	}, newConverters(opts...)...)
+}
+
+func TestATXHeadings(t *testing.T) {
+	runTestCase(t, testCase{
+		body:   "<h1>Title</h1><p>Intro</p><h2>Two line<br>section</h2><h6>Detail</h6>",
+		expect: "# Title\n\nIntro\n\n## Two line section\n\n###### Detail",
+	}, newConverters(textplain.WithATXHeadings())...)
+}
+
+func TestDocumentTitle(t *testing.T) {
+	converters := newConverters(textplain.WithDocumentTitle())
+
+	runTestCases(t, []testCase{
+		{
+			name:   "title",
+			body:   "<html><head><title>Spring\n  sale</title></head><body><p>Everything must go</p></body></html>",
+			expect: "***********\nSpring sale\n***********\n\nEverything must go",
+		},
+		{
+			name:   "og:title",
+			body:   "<html><head><meta property=\"og:title\" content=\"Weekly digest\"></head><body><p>This week</p></body></html>",
+			expect: "*************\nWeekly digest\n*************\n\nThis week",
+		},
+		{
+			name:   "repeated by the body",
+			body:   "<html><head><title>Weekly digest</title></head><body><h1>Weekly Digest</h1><p>This week</p></body></html>",
+			expect: "*************\nWeekly Digest\n*************\n\nThis week",
+		},
+		{
+			name:   "untitled",
+			body:   "<html><head><title> </title></head><body><p>This week</p></body></html>",
+			expect: "This week",
+		},
+	}, converters...)
+
+	runTestCase(t, testCase{
+		name:   "ignored by default",
+		body:   "<html><head><title>Weekly digest</title></head><body><p>This week</p></body></html>",
+		expect: "This week",
+	})
+}
+
+func TestContainerBreaks(t *testing.T) {
+	body := "<div>one</div><div>two</div><div><div>nested</div>tail <b>text</b></div><div></div><table><tr><td><div>cell</div></td></tr></table>"
+
+	runTestCase(t, testCase{
+		name:   "line breaks",
+		body:   body,
+		expect: "one\ntwo\nnested\ntail text\ncell",
+	}, textplain.NewTreeConverter())
+
+	runTestCase(t, testCase{
+		name:   "paragraph breaks",
+		body:   body,
+		expect: "one\n\ntwo\n\nnested\n\ntail text\n\ncell",
+	}, textplain.NewTreeConverter(textplain.WithContainerBreak(textplain.ParagraphBreak)))
+
+	runTestCase(t, testCase{
+		name:   "list items",
+		body:   "<ul><li><div>one</div></li><li><div>two</div></li></ul>",
+		expect: "* one\n* two",
+	}, textplain.NewTreeConverter())
+}
+
+func TestElementPolicies(t *testing.T) {
+	opts := []textplain.Option{
+		textplain.WithElementPolicy("nav", textplain.SkipElement),
+		textplain.WithElementPolicy("aside", textplain.DemoteElement),
+		textplain.WithElementPolicy("footer", textplain.SeparateElement),
+	}
+	body := "<header><nav><a href=\"/\">Home</a></nav><p>Newsletter</p></header><main><p>Story one</p><aside><p>Related stories</p></aside><p>Story two</p></main><footer><p>Sent to you by Example</p></footer>"
+
+	runTestCase(t, testCase{
+		name:   "policies",
+		body:   body,
+		expect: "Newsletter\n\nStory one\n\nStory two\n\n----------\n\nSent to you by Example\n\nRelated stories",
+	}, newConverters(opts...)...)
+
+	runTestCase(t, testCase{
+		name:   "separated without preceding content",
+		body:   "<footer><p>Sent to you by Example</p></footer>",
+		expect: "Sent to you by Example",
+	}, newConverters(opts...)...)
+}
+
+func TestSectionBoundaries(t *testing.T) {
+	runTestCase(t, testCase{
+		body:   "<main>Top stories<article>First story<br>Read on</article><article><span>Second story</span></article>Thanks for reading<section>Sponsored</section></main>",
+		expect: "Top stories\n\nFirst story\nRead on\n\nSecond story\n\nThanks for reading\n\nSponsored",
+	})
+}
+
+func TestMainContent(t *testing.T) {
+	body := `<html><body>
+		<div class="site-header"><a href="/">Home</a> <a href="/news">News</a></div>
+		<ul class="menu"><li><a href="/a">Section A</a></li><li><a href="/b">Section B</a></li></ul>
+		<div id="story">
+			<h1>Council approves new park</h1>
+			<p>The city council voted on Tuesday to approve a new park on the site of the old rail yard.</p>
+			<p>Construction is expected to begin next spring, with the first phase, including a playground, opening the following year.</p>
+			<div class="share-bar"><a href="/share/fb">Share</a> <a href="/share/tw">Tweet</a></div>
+		</div>
+		<div class="legal">Copyright 2026 Example News, all rights reserved. Terms, privacy and cookie policy apply.</div>
+	</body></html>`
+
+	runTestCase(t, testCase{
+		name:   "article",
+		body:   body,
+		expect: "*************************\nCouncil approves new park\n*************************\n\nThe city council voted on Tuesday to approve a new park on the\nsite of the old rail yard.\n\nConstruction is expected to begin next spring, with the first\nphase, including a playground, opening the following year.",
+	}, newConverters(textplain.WithMainContent())...)
+
+	runTestCase(t, testCase{
+		name:   "nothing stands out",
+		body:   "<p>Hi Sam,</p><p>See you soon</p>",
+		expect: "Hi Sam,\n\nSee you soon",
+	}, newConverters(textplain.WithMainContent())...)
+}
+
+func TestConvertConcurrently(t *testing.T) {
+	expect, err := textplain.Convert(html, textplain.DefaultLineLength)
+	assert.Nil(t, err)
+
+	results := make(chan string, 8)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			result, _ := textplain.Convert(html, textplain.DefaultLineLength)
+			results <- result
+		}()
+	}
+	for i := 0; i < cap(results); i++ {
+		assert.Equal(t, expect, <-results)
+	}
+}
+
+func TestConverterPool(t *testing.T) {
+	expect, err := textplain.Convert(html, textplain.DefaultLineLength)
+	assert.Nil(t, err)
+
+	var created int32
+	pool := textplain.NewConverterPool(func() textplain.Converter {
+		atomic.AddInt32(&created, 1)
+		return textplain.NewTreeConverter()
+	}, 2)
+
+	results := make(chan string, 8)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			result, _ := pool.Convert(html, textplain.DefaultLineLength)
+			results <- result
+		}()
+	}
+	for i := 0; i < cap(results); i++ {
+		assert.Equal(t, expect, <-results)
+	}
+	assert.LessOrEqual(t, atomic.LoadInt32(&created), int32(2))
+}
+
+// countingConverter counts the conversions that reach the converter it wraps
+type countingConverter struct {
+	textplain.Converter
+	conversions int
+}
+
+func (cc *countingConverter) Convert(document string, lineLength int) (string, error) {
+	cc.conversions++
+	return cc.Converter.Convert(document, lineLength)
+}
+
+func TestCachingConverter(t *testing.T) {
+	counting := &countingConverter{Converter: textplain.NewTreeConverter()}
+	cache := textplain.NewCachingConverter(counting, 2)
+
+	template := `<html><body><p>Hello {{ first_name }}</p></body></html>`
+	for i := 0; i < 3; i++ {
+		result, err := cache.Convert(template, textplain.DefaultLineLength)
+		assert.Nil(t, err)
+		assert.Equal(t, "Hello {{ first_name }}", result)
+	}
+	assert.Equal(t, 1, counting.conversions)
+
+	// A different line length is converted separately
+	_, err := cache.Convert(template, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, counting.conversions)
+
+	// The least recently used result is evicted once the cache is full
+	_, err = cache.Convert(`<html><body>Other</body></html>`, textplain.DefaultLineLength)
+	assert.Nil(t, err)
+	_, err = cache.Convert(template, textplain.DefaultLineLength)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, counting.conversions)
+	_, err = cache.Convert(`<html><body>Other</body></html>`, textplain.DefaultLineLength)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, counting.conversions)
+}
+
+func TestCachingConverterOptions(t *testing.T) {
+	var parses int
+	countParses := textplain.WithStageHook(func(stage textplain.Stage) {
+		if stage.Name == "parse" {
+			parses++
+		}
+	})
+	cache := textplain.NewCachingConverter(textplain.NewTreeConverter(countParses), 8)
+	document := `<html><body><a href="http://example.com">Example</a></body></html>`
+
+	result, err := cache.Convert(document, textplain.DefaultLineLength)
+	assert.Nil(t, err)
+	assert.Equal(t, "Example ( http://example.com )", result)
+
+	// Converters with the same options share their results, whatever their hooks
+	result, err = cache.Wrap(textplain.NewTreeConverter(countParses)).Convert(document, textplain.DefaultLineLength)
+	assert.Nil(t, err)
+	assert.Equal(t, "Example ( http://example.com )", result)
+	assert.Equal(t, 1, parses)
+
+	// Converters with different options don't
+	result, err = cache.Wrap(textplain.NewTreeConverter(countParses, textplain.WithLinkMode(textplain.LinkMarkdown))).Convert(document, textplain.DefaultLineLength)
+	assert.Nil(t, err)
+	assert.Equal(t, "[Example](http://example.com)", result)
+	assert.Equal(t, 2, parses)
+
+	// Nor do converters of other packages
+	counting := &countingConverter{Converter: textplain.NewTreeConverter()}
+	result, err = cache.Wrap(counting).Convert(document, textplain.DefaultLineLength)
+	assert.Nil(t, err)
+	assert.Equal(t, "Example ( http://example.com )", result)
+	assert.Equal(t, 1, counting.conversions)
+}
+
+func TestConvertMerged(t *testing.T) {
+	counting := &countingConverter{Converter: textplain.NewTreeConverter()}
+	cache := textplain.NewCachingConverter(counting, 2)
+
+	template := `<html><body><p>Hello {{ first_name }}, *|FNAME|*</p><p>{{ unknown }}</p></body></html>`
+	for _, name := range []string{"Ada", "Grace"} {
+		result, err := cache.ConvertMerged(template, map[string]string{"first_name": name, "FNAME": "friend"}, textplain.DefaultLineLength)
+		assert.Nil(t, err)
+		assert.Equal(t, "Hello "+name+", friend\n\n{{ unknown }}", result)
+	}
+	assert.Equal(t, 1, counting.conversions)
+}
+
+func TestConvertTo(t *testing.T) {
+	var document strings.Builder
+	document.WriteString("<html><head><title>Digest</title></head><body>")
+	for i := 0; i < 400; i++ {
+		document.WriteString(`<h2>Story</h2><p>The quick brown fox jumps over the lazy dog, then
+			<a href="http://example.com/story">reads more</a> (or doesn't).</p>
+			<ul><li>first item</li><li>second item</li></ul><ul><li>next list</li></ul>
+			{% if subscriber %}<div>Thanks for subscribing</div>{% endif %}
+			<div><span>spans</span> <span>joined</span><br>after a break</div><pre>  kept
+  as is</pre>`)
+	}
+	document.WriteString(`<p><a href="http://example.com/unsubscribe">Unsubscribe</a></p></body></html>`)
+
+	for _, converter := range []textplain.Converter{
+		textplain.NewTreeConverter(),
+		textplain.NewTreeConverter(textplain.WithParagraphSeparator("\n\n~~~\n\n"), textplain.WithDocumentTitle()),
+		textplain.NewTreeConverter(textplain.WithMaxBlankLines(0), textplain.WithUnsubscribeFooter()),
+	} {
+		expect, err := converter.Convert(document.String(), textplain.DefaultLineLength)
+		assert.Nil(t, err)
+
+		var b strings.Builder
+		assert.Nil(t, converter.(*textplain.TreeConverter).ConvertTo(&b, document.String(), textplain.DefaultLineLength))
+		assert.Equal(t, expect, b.String())
+	}
+}
+
+func TestDeeplyNestedBody(t *testing.T) {
+	runTestCase(t, testCase{
+		name:   "fragment nested in many wrappers",
+		body:   strings.Repeat("<div><table><tr><td>", 10) + "Deep content" + strings.Repeat("</td></tr></table></div>", 10),
+		expect: "Deep content",
+	})
+}
+
+func TestDeeplyNestedElements(t *testing.T) {
+	runTestCase(t, testCase{
+		name:   "content nested beyond the depth limit",
+		body:   strings.Repeat("<div><b>", 1000) + "<p>Deep <a href=\"http://example.com\">link</a></p>" + strings.Repeat("</b></div>", 1000) + "<p>After</p>",
+		expect: "Deep link ( http://example.com )\n\nAfter",
+	})
+}
+
+func TestMaxDepth(t *testing.T) {
+	body := "<div><a href=\"http://example.com\">" + strings.Repeat("<span>", 40) + "link" + strings.Repeat("</span>", 40) + "</a></div>"
+
+	runTestCase(t, testCase{
+		name:   "within the limit",
+		body:   body,
+		expect: "link ( http://example.com )",
+	}, newConverters(textplain.WithMaxDepth(0))...)
+
+	runTestCase(t, testCase{
+		name:   "flattened below the limit",
+		body:   body,
+		expect: "link",
+	}, newConverters(textplain.WithMaxDepth(3))...)
+
+	runTestCase(t, testCase{
+		name:   "nested beyond a lower limit",
+		body:   strings.Repeat("<div><b>", 100) + "<p>Deep <a href=\"http://example.com\">link</a></p>" + strings.Repeat("</b></div>", 100) + "<p>After</p>",
+		expect: "Deep link ( http://example.com )\n\nAfter",
+	}, newConverters(textplain.WithMaxDepth(16))...)
+}
+
+func TestTimeout(t *testing.T) {
+	for _, converter := range newConverters(textplain.WithTimeout(time.Nanosecond)) {
+		_, err := converter.Convert(html, textplain.DefaultLineLength)
+		assert.True(t, errors.Is(err, textplain.ErrTimeout))
+
+		var timeout *textplain.TimeoutError
+		if assert.True(t, errors.As(err, &timeout)) {
+			assert.Equal(t, "parse", timeout.Stage)
+		}
+	}
+
+	var b strings.Builder
+	err := textplain.NewTreeConverter(textplain.WithTimeout(time.Nanosecond)).(*textplain.TreeConverter).ConvertTo(&b, html, textplain.DefaultLineLength)
+	assert.True(t, errors.Is(err, textplain.ErrTimeout))
+
+	runTestCase(t, testCase{
+		name:   "within the limit",
+		body:   "<html><body><p>Hello</p></body></html>",
+		expect: "Hello",
+	}, newConverters(textplain.WithTimeout(time.Minute))...)
+}
+
+func TestMemoryLimit(t *testing.T) {
+	for _, converter := range newConverters(textplain.WithMemoryLimit(len(html))) {
+		_, err := converter.Convert(html, textplain.DefaultLineLength)
+		assert.True(t, errors.Is(err, textplain.ErrMemoryLimit))
+
+		var limit *textplain.MemoryLimitError
+		if assert.True(t, errors.As(err, &limit)) {
+			assert.Equal(t, "parse", limit.Stage)
+			assert.Greater(t, limit.Size, len(html))
+		}
+	}
+
+	runTestCase(t, testCase{
+		name:   "within the limit",
+		body:   "<html><body><p>Hello</p></body></html>",
+		expect: "Hello",
+	}, newConverters(textplain.WithMemoryLimit(1<<20))...)
+}
+
+func TestDocument(t *testing.T) {
+	converter := textplain.NewTreeConverter().(*textplain.TreeConverter)
+
+	doc, err := converter.Parse(html)
+	assert.NoError(t, err)
+
+	for _, lineLength := range []int{textplain.DefaultLineLength, 0} {
+		expect, err := converter.Convert(html, lineLength)
+		assert.NoError(t, err)
+
+		text, err := doc.Text(lineLength)
+		assert.NoError(t, err)
+		assert.Equal(t, expect, text)
+	}
+
+	doc, err = converter.Parse(`<html><body><h1>News</h1><p>Read the <a href="http://example.com/story">whole story</a> online</p></body></html>`)
+	assert.NoError(t, err)
+
+	markdown, err := doc.Markdown(textplain.DefaultLineLength)
+	assert.NoError(t, err)
+	assert.Equal(t, "# News\n\nRead the [whole story](http://example.com/story) online", markdown)
+
+	snippet, err := doc.Snippet(20)
+	assert.NoError(t, err)
+	assert.Equal(t, "News Read the whole\u2026", snippet)
+
+	snippet, err = doc.Snippet(200)
+	assert.NoError(t, err)
+	assert.Equal(t, "News Read the whole story online", snippet)
+
+	for _, length := range []int{0, -1} {
+		snippet, err = doc.Snippet(length)
+		assert.NoError(t, err)
+		assert.Equal(t, "", snippet)
+	}
+
+	// rendering leaves the document as it was
+	text, err := doc.Text(textplain.DefaultLineLength)
+	assert.NoError(t, err)
+	assert.Equal(t, "****\nNews\n****\n\nRead the whole story ( http://example.com/story ) online", text)
+}
+
+func TestParallelism(t *testing.T) {
+	var document strings.Builder
+	document.WriteString("<html><body><table><tr><td>")
+	for i := 0; i < 200; i++ {
+		document.WriteString(`<div><h2>Story</h2><p>The quick brown fox jumps over the lazy dog</p></div>
+			trailing text<div></div><p>after an empty block</p><span>spans</span> <span>joined</span>
+			<section><ul><li>first item</li><li>second item</li></ul></section><pre>  kept</pre>`)
+	}
+	document.WriteString("</td></tr></table></body></html>")
+
+	for _, opts := range [][]textplain.Option{
+		nil,
+		{textplain.WithContainerBreak(textplain.ParagraphBreak)},
+		{textplain.WithMaxBlankLines(0)},
+	} {
+		expect, err := textplain.NewTreeConverter(opts...).Convert(document.String(), textplain.DefaultLineLength)
+		assert.Nil(t, err)
+
+		for _, n := range []int{2, 3, 8} {
+			text, err := textplain.NewTreeConverter(append(opts, textplain.WithParallelism(n))...).Convert(document.String(), textplain.DefaultLineLength)
+			assert.Nil(t, err)
+			assert.Equal(t, expect, text)
+		}
+	}
+}
+
+func TestImageAttributes(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name:   "unquoted alt",
+			body:   "<html><body><p>A<img src=logo.png alt=Logo>B</p></body></html>",
+			expect: "ALogoB",
+		},
+		{
+			name:   "alt after a similarly named attribute",
+			body:   `<html><body><p><img data-alt="wrong" alt="Logo"></p></body></html>`,
+			expect: "Logo",
+		},
+		{
+			name:   "empty alt on an inline image",
+			body:   `<html><body><p><img alt="" src="data:image/png;base64,AAAA"></p></body></html>`,
+			expect: "[inline image]",
+		},
+	})
+}
+
+func TestStageHook(t *testing.T) {
+	testStageHook(t, textplain.NewTreeConverter, []string{"parse", "prepare", "convert", "wrap"})
+}
+
+// testStageHook checks that a converter reports each of the named stages, in order
+func testStageHook(t *testing.T, new func(...textplain.Option) textplain.Converter, names []string) {
+	var stages []textplain.Stage
+	converter := new(textplain.WithStageHook(func(s textplain.Stage) {
+		stages = append(stages, s)
+	}))
+
+	text, err := converter.Convert(html, textplain.DefaultLineLength)
+	assert.Nil(t, err)
+
+	var reported []string
+	for _, s := range stages {
+		reported = append(reported, s.Name)
+		assert.GreaterOrEqual(t, s.Duration, time.Duration(0))
+		assert.Greater(t, s.Size, 0)
+	}
+	assert.Equal(t, names, reported)
+	assert.NotEmpty(t, text)
+}
+
+func TestBodyNotFound(t *testing.T) {
+	for _, converter := range newConverters() {
+		for _, document := range []string{"", "<html><head><title>Empty</title></head><body></body></html>"} {
+			text, err := converter.Convert(document, textplain.DefaultLineLength)
+			assert.Equal(t, textplain.ErrBodyNotFound, err)
+			assert.Equal(t, "", text)
+		}
+	}
+
+	var b strings.Builder
+	assert.Equal(t, textplain.ErrBodyNotFound, textplain.ConvertTo(&b, "<html><body></body></html>", textplain.DefaultLineLength))
+	assert.Equal(t, "", b.String())
+}
+
+func TestLinkAttributes(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name:   "unquoted href",
+			body:   "<html><body><p><a class=cta href=http://example.com/offer target=_blank>Offer</a></p></body></html>",
+			expect: "Offer ( http://example.com/offer )",
+		},
+		{
+			name:   "href after a similarly named attribute",
+			body:   `<html><body><p><a data-href="http://example.com/wrong" href="http://example.com/right">Right</a></p></body></html>`,
+			expect: "Right ( http://example.com/right )",
+		},
+		{
+			name:   "anchor without an href before a link",
+			body:   `<html><body><p><a name="top">Top</a> and <a href="http://example.com">a link</a></p></body></html>`,
+			expect: "Top and a link ( http://example.com )",
+		},
+		{
+			name:   "bare href",
+			body:   `<html><body><p><a href>Nowhere</a></p></body></html>`,
+			expect: "Nowhere",
+		},
+	})
+}
+
+func TestInlineFlow(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name: "inline elements on separate lines",
+			body: `<p><a href="http://example.com">Link</a>
+				<strong>bold</strong>
+				<em>emphasis</em></p>`,
+			expect: "Link ( http://example.com ) bold emphasis",
+		},
+		{
+			name: "text on the line after an inline element",
+			body: `<p><b>Sale</b>
+				ends today</p>`,
+			expect: "Sale ends today",
+		},
+		{
+			name:   "line break at the end of a span",
+			body:   `<p><span>First<br></span><span>Second</span></p>`,
+			expect: "First\nSecond",
+		},
+	})
+}
+
+func TestOrderedLists(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name:   "numbered items",
+			body:   "<ol><li>item 1</li><li>item 2</li><li>item 3</li></ol>",
+			expect: "1. item 1\n2. item 2\n3. item 3",
+		},
+		{
+			name:   "start attribute",
+			body:   `<ol start="4"><li>item 4</li><li>item 5</li></ol>`,
+			expect: "4. item 4\n5. item 5",
+		},
+	}, newConverters(textplain.WithOrderedLists())...)
+
+	// nested lists are numbered independently of the lists around them
+	for _, converter := range newConverters(textplain.WithOrderedLists()) {
+		text, err := converter.Convert("<ol><li><p>first</p><ul><li>detail</li></ul></li><li><p>second</p><ol><li>inner</li></ol></li><li>third</li></ol>", textplain.DefaultLineLength)
+		assert.Nil(t, err)
+		for _, line := range []string{"1. first", "* detail", "2. second", "1. inner", "3. third"} {
+			assert.Contains(t, strings.Split(text, "\n"), line)
+		}
+	}
+}
+
+func TestPicture(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name: "sources with a fallback image",
+			body: `<p>Before
+				<picture>
+					<source srcset="logo.webp" type="image/webp">
+					<source srcset="logo-wide.jpg" media="(min-width: 600px)">
+					<img src="logo.jpg" alt="Logo">
+				</picture>
+				after</p>`,
+			expect: "Before Logo after",
+		},
+		{
+			name:   "linked picture without alt text",
+			body:   `<p><a href="http://example.com"><picture><source srcset="logo.webp"><img src="logo.jpg"></picture></a></p>`,
+			expect: "( http://example.com )",
+		},
+		{
+			name:   "inline picture without alt text",
+			body:   `<p><picture><source srcset="logo.webp"><img src="data:image/png;base64,AAAA"></picture></p>`,
+			expect: "[inline image]",
+		},
+	})
+}
+
+func TestEmptyBlocks(t *testing.T) {
+	drop := textplain.WithEmptyBlocks(textplain.DropEmptyBlocks)
+	runTestCases(t, []testCase{
+		{
+			name:   "spacer paragraphs",
+			body:   "<p>One</p><p>&nbsp;</p><p> &nbsp; </p><p>Two</p>",
+			expect: "One\n\nTwo",
+		},
+		{
+			name:   "spacer divs",
+			body:   "<p>One</p><div>&nbsp;</div><div><br></div><div><span>&nbsp;</span><div> </div></div><p>Two</p>",
+			expect: "One\n\nTwo",
+		},
+		{
+			name:   "outlook paragraphs",
+			body:   "<p>One</p><p class=MsoNormal><o:p>&nbsp;</o:p></p><p>Two</p>",
+			expect: "One\n\nTwo",
+		},
+		{
+			name:   "blocks holding images",
+			body:   `<p>One</p><p><img src="logo.png" alt="Logo"></p><p>Two</p>`,
+			expect: "One\n\nLogo\n\nTwo",
+		},
+	}, newConverters(drop)...)
+
+	// empty blocks are kept by default
+	runTestCase(t, testCase{
+		name:   "kept by default",
+		body:   "<p>One</p><p>&nbsp;</p><p>Two</p>",
+		expect: "One\n\n\u00a0\n\nTwo",
+	})
+}
+
+func TestBreakRuns(t *testing.T) {
+	body := "<p>One<br>Two<br><br>Three<br> <br>\n<!-- spacer --><br>Four</p>"
+	runTestCase(t, testCase{
+		name:   "runs leave a blank line by default",
+		body:   body,
+		expect: "One\nTwo\n\nThree\n\nFour",
+	})
+
+	single := textplain.WithBreakRuns(textplain.SingleBreakRuns)
+	runTestCase(t, testCase{
+		name:   "runs capped at a single line break",
+		body:   body,
+		expect: "One\nTwo\nThree\nFour",
+	}, newConverters(single)...)
+
+	exact := textplain.WithBreakRuns(textplain.ExactBreakRuns)
+	runTestCases(t, []testCase{
+		{
+			name:   "runs preserved exactly",
+			body:   body,
+			expect: "One\nTwo\n\nThree\n\n\nFour",
+		},
+		{
+			name:   "runs preserved past the blank line limit",
+			body:   "<p>One<br><br><br><br>Two</p><p>Three</p>",
+			expect: "One\n\n\n\nTwo\n\nThree",
+		},
+	}, newConverters(exact)...)
+}
+
+func TestPrivateUseCharacters(t *testing.T) {
+	// the private use characters the converters use as markers pass through as they are
+	runTestCases(t, []testCase{
+		{
+			name:   "passthrough token",
+			body:   "<p>user said: \ue0000\ue001</p><!-- start text/plain -->SECRET FOOTER<!-- end text/plain -->",
+			expect: "user said: \ue0000\ue001\n\nSECRET FOOTER",
+		},
+		{
+			name:   "no-break marker",
+			body:   "<p>x\ue003y</p>",
+			expect: "x\ue003y",
+		},
+		{
+			name:   "escape token",
+			body:   "<p>\ue0080\ue009 and \ue008</p>",
+			expect: "\ue0080\ue009 and \ue008",
+		},
+		{
+			name:   "block width marker",
+			body:   "<p>wide \U000f0050 text</p>",
+			expect: "wide \U000f0050 text",
+		},
+	})
+}
+
+func TestStripsNonContentTags(t *testing.T) {
+	body := `<html>
+			<body>
+				<script type="text/javascript">
+					alert("haxx");
+				</script>
+				No hacks here
+				<style>
+					p {
+						font-weight: bold;
+					}
+				</style>
+				<p>
+					This is not a bold statement
+				</p>
+			</body>
+		</html>`
+
+	runTestCase(t, testCase{
+		body:   body,
+		expect: "No hacks here\n\nThis is not a bold statement",
+	}, newRegexpConverters()...)
+
+	runTestCase(t, testCase{
+		body:   body,
+		expect: "No hacks here\nThis is not a bold statement",
+	}, textplain.NewTreeConverter())
+}
+
+func TestMultilineTitles(t *testing.T) {
+	runTestCase(t, testCase{
+		body: `<h1>Horse
+		Friends
+						Yeah
+</h1>`,
+		expect: "*******\nHorse\nFriends\nYeah\n*******",
+	}, newRegexpConverters()...)
+
+	runTestCase(t, testCase{
+		body: `<h1>Horse
+		Friends
+						Yeah
+</h1>`,
+		expect: "******************\nHorse Friends Yeah\n******************",
+	}, textplain.NewTreeConverter())
+}
+
+func TestWrappingDoesntAddUnnecessaryLineBreaks(t *testing.T) {
+	runTestCase(t, testCase{
+		body: `.stylesheet {
+			color: white;
+			background-image: url('data:image/png;base64,` + strings.Repeat("A", textplain.DefaultLineLength) + `');
+			font-weight: bold;
+			margin: 0px;
+		}`,
+		expect: `.stylesheet {
+color: white;
+background-image:
+url('data:image/png;base64,` + strings.Repeat("A", textplain.DefaultLineLength) + `');
+font-weight: bold;
+margin: 0px;
+}`,
+	}, newRegexpConverters()...)
+
+	runTestCase(t, testCase{
+		body: `.stylesheet {
+			color: white;
+			background-image: url('data:image/png;base64,` + strings.Repeat("A", textplain.DefaultLineLength) + `');
+			font-weight: bold;
+		}`,
+		expect: `.stylesheet { color: white; background-image:
+url('data:image/png;base64,` + strings.Repeat("A", textplain.DefaultLineLength) + `'); font-weight: bold; }`,
+	}, textplain.NewTreeConverter())
+
+	// the indentation of the stylesheet is kept, its tabs expanded, on the lines wrapped from it
+	indent := strings.Repeat(" ", 24)
+	runTestCase(t, testCase{
+		body: `.stylesheet {
+			color: white;
+			background-image: url('data:image/png;base64,` + strings.Repeat("A", textplain.DefaultLineLength) + `');
+			font-weight: bold;
+			margin: 0px;
+		}`,
+		expect: `.stylesheet {
+` + indent + `color: white;
+` + indent + `background-image:
+` + indent + `url('data:image/png;base64,` + strings.Repeat("A", textplain.DefaultLineLength) + `');
+` + indent + `font-weight: bold;
+` + indent + `margin: 0px;
+                }`,
+	}, newRegexpConverters(textplain.WithPreserveIndent())...)
+
+	runTestCase(t, testCase{
+		body: `<div style="white-space:pre-wrap">func main() {
+    fmt.Println("a rather long line of code that runs past the line length of the text")
+}</div>`,
+		expect: "func main() {\n    fmt.Println(\"a rather long line of code that runs past the\n    line length of the text\")\n}",
+	}, newConverters(textplain.WithPreserveIndent())...)
+}
+
+func TestStrippingComments(t *testing.T) {
+	runTestCases(t, []testCase{
+		{
+			name:   "comment in tag",
+			body:   "<p>in<!--comment 1-->between</p>",
+			expect: "inbetween",
+		},
+		{
+			name:   "comment between tags",
+			body:   `<p>before</p><!--comment 1--><p>after</p>`,
+			expect: "before\n\nafter",
+		},
+		{
+			name:   "commented out tag",
+			body:   `<p>before</p><!--comment 1<div>random</div>--><p>after</p>`,
+			expect: "before\n\nafter",
+		},
+		{
+			name: "multiline comment",
+			body: `<p>before</p>
+			<!--
+				replacing unordered list with an ordered one.
+				uncomment out incase it breaks stuff.
+
+				<h2>An Unordered HTML List</h2>
+				<ul>
+				  <li>Coffee</li>
+				  <li>Tea</li>
+				  <li>Milk</li>
+				</ul>
+			-->
+			<p>after</p>`,
+			expect: "before\n\nafter",
+		},
+		{
+			// comments don't nest, so the first `-->` ends the outer comment and what follows
+			// it is shown, as it is by browsers
+			name: "comment within comment",
+			body: `<p>before</p>
+			<!--
+				some reason to comment out the whole block
+
+				<h2>An Unordered HTML List</h2>
+				<ul>
 				  <li>Coffee</li>
 				  <li>Tea</li>
 				  <li>Milk</li>
@@ -546,6 +2553,21 @@ func TestStrippingComments(t *testing.T) {
 			<p>after</p>`,
 			expect: "before\n\nsweet list\n\n-->\nafter",
 		},
+		{
+			name:   "comment closed with --!>",
+			body:   `<p>before</p><!-- hidden --!><p>after</p>`,
+			expect: "before\n\nafter",
+		},
+		{
+			name:   "unterminated comment",
+			body:   `<p>before</p><!-- hidden<p>never shown</p>`,
+			expect: "before",
+		},
+		{
+			name:   "abruptly closed comment before an ignored region",
+			body:   `<p>before</p><!--><!-- start text/html --><p>hidden</p><!-- end text/html --!><p>after</p>`,
+			expect: "before\n\nafter",
+		},
 	})
 }
 
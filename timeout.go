@@ -0,0 +1,68 @@
+package textplain
+
+import "time"
+
+// WithTimeout limits the time a conversion may take to d. The time taken is checked between
+// the stages of a conversion, so one stage may run past the limit, after which conversion
+// stops with a *TimeoutError
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// TimeoutError is returned by conversions that run past the limit set with WithTimeout. It
+// matches ErrTimeout with errors.Is
+type TimeoutError struct {
+	// Stage is the stage of the conversion that was the last to complete
+	Stage string
+
+	// Partial holds the unwrapped text of the document when conversion stopped after its
+	// markup had been converted, and is empty otherwise
+	Partial string
+}
+
+func (e *TimeoutError) Error() string {
+	return ErrTimeout.Error() + " after the " + e.Stage + " stage"
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// budget tracks the time left for a single conversion, and the time taken by each of its
+// stages where they are reported to a hook
+type budget struct {
+	deadline time.Time
+	hook     func(Stage)
+	last     time.Time
+}
+
+// startBudget starts the clock on a conversion limited by WithTimeout or reported on with
+// WithStageHook
+func (o *options) startBudget() *budget {
+	b := &budget{hook: o.stageHook}
+	if o.timeout <= 0 && o.stageHook == nil {
+		return b
+	}
+	b.last = time.Now()
+	if o.timeout > 0 {
+		b.deadline = b.last.Add(o.timeout)
+	}
+	return b
+}
+
+// check reports the end of a stage, whose text is size bytes long, and returns a
+// *TimeoutError once the time for the conversion has run out, with the text returned by
+// partial, if given
+func (b *budget) check(stage string, size int, partial func() string) error {
+	b.record(stage, size)
+	if b.deadline.IsZero() || time.Now().Before(b.deadline) {
+		return nil
+	}
+	err := &TimeoutError{Stage: stage}
+	if partial != nil {
+		err.Partial = partial()
+	}
+	return err
+}
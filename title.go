@@ -0,0 +1,67 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// insertTitle places the title of the document ahead of the body content as a top-level
+// heading, unless the body already holds it in an h1 heading of its own
+func insertTitle(body *html.Node) {
+	title := documentTitle(body)
+	if title == "" || hasHeading(body, title) {
+		return
+	}
+
+	heading := &html.Node{Type: html.ElementNode, Data: "h1", DataAtom: atom.H1}
+	heading.AppendChild(&html.Node{Type: html.TextNode, Data: title})
+	body.InsertBefore(heading, body.FirstChild)
+}
+
+// documentTitle returns the title declared in the head of the document holding body, by its
+// `<title>` or failing that its `og:title` meta tag
+func documentTitle(body *html.Node) string {
+	if body.Parent == nil {
+		return ""
+	}
+
+	var title, ogTitle string
+	for head := body.Parent.FirstChild; head != nil; head = head.NextSibling {
+		if head.DataAtom != atom.Head {
+			continue
+		}
+		for c := head.FirstChild; c != nil; c = c.NextSibling {
+			switch {
+			case c.DataAtom == atom.Title && title == "":
+				title = nodeText(c)
+			case c.DataAtom == atom.Meta && getAttr(c, "property") == "og:title" && ogTitle == "":
+				ogTitle = strings.Join(strings.Fields(getAttr(c, "content")), " ")
+			}
+		}
+	}
+
+	if title != "" {
+		return title
+	}
+	return ogTitle
+}
+
+// hasHeading reports whether any `<h1>` below n reads text, ignoring case and whitespace
+func hasHeading(n *html.Node, text string) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if c.DataAtom == atom.H1 {
+			if strings.EqualFold(nodeText(c), text) {
+				return true
+			}
+		}
+		if hasHeading(c, text) {
+			return true
+		}
+	}
+	return false
+}
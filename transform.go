@@ -0,0 +1,70 @@
+package textplain
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// applyTextTransform rewrites the text below n according to any inherited or declared
+// `text-transform` inline style, as the html version displays it. Merge tags are left as they
+// are so that templates continue to resolve
+func applyTextTransform(n *html.Node, transform string) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			if transform != "" && transform != "none" {
+				c.Data = transformText(c.Data, transform)
+			}
+		case html.ElementNode:
+			inherited := transform
+			if declared, ok := inlineStyle(c)["text-transform"]; ok {
+				inherited = declared
+			}
+			applyTextTransform(c, inherited)
+		}
+	}
+}
+
+// transformText applies a single text-transform to text, outside of any merge tags
+func transformText(text, transform string) string {
+	var apply func(string) string
+	switch transform {
+	case "uppercase":
+		apply = strings.ToUpper
+	case "lowercase":
+		apply = strings.ToLower
+	case "capitalize":
+		apply = capitalize
+	default:
+		return text
+	}
+
+	var b strings.Builder
+	var last int
+	for _, span := range mergeTagSpans(text) {
+		b.WriteString(apply(text[last:span[0]]))
+		b.WriteString(text[span[0]:span[1]])
+		last = span[1]
+	}
+	b.WriteString(apply(text[last:]))
+	return b.String()
+}
+
+// capitalize upper cases the first letter of every word
+func capitalize(text string) string {
+	wordStart := true
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			wordStart = true
+		case unicode.IsLetter(r) && wordStart:
+			wordStart = false
+			return unicode.ToTitle(r)
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+			wordStart = false
+		}
+		return r
+	}, text)
+}
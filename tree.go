@@ -1,370 +1,396 @@
 package textplain
 
 import (
-	"strconv"
+	"bytes"
 	"strings"
+	"unicode"
 
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
 )
 
-type TreeConverter struct{}
+type TreeConverter struct {
+	options
 
-func NewTreeConverter() Converter {
-	return &TreeConverter{}
+	// fanOut is the node whose children are converted in parallel, set on the copy of the
+	// converter made for a rendering with WithParallelism
+	fanOut *html.Node
+}
+
+func NewTreeConverter(opts ...Option) Converter {
+	return &TreeConverter{
+		options: newOptions(opts),
+	}
 }
 
 func (t *TreeConverter) Convert(document string, lineLength int) (string, error) {
+	budget := t.startBudget()
 
-	root, err := html.Parse(strings.NewReader(document))
+	d, err := t.parse(document, budget)
 	if err != nil {
 		return "", err
 	}
+	return t.render(d, lineLength, budget)
+}
 
-	body := t.findBody(root)
-	if body == nil {
-		return "", nil
+// doConvert writes the text of the children of n to b. Elements whose text is decorated or
+// trimmed as a whole, such as headings, list items and links, are written first and then
+// rewritten in place, so that the whole document is rendered into the one buffer
+func (t *TreeConverter) doConvert(b *bytes.Buffer, n *html.Node) error {
+	if n == nil {
+		return nil
 	}
-
-	lines, err := t.doConvert(body)
-	if err != nil {
-		return "", err
+	if n == t.fanOut {
+		return t.convertParallel(b, n)
 	}
 
-	text := t.fixSpacing(strings.Join(lines, ""))
+	start := b.Len()
 
-	wrapped := WordWrap(strings.TrimSpace(text), lineLength)
-	wrapped = strings.Replace(wrapped, "(\n", "\n( ", -1) // XXX: cheap fix for wrapping open braces. move into WordWrap
-	wrapped = strings.Replace(wrapped, "\n)", " )\n", -1) // XXX: cheap fix for wrapping closed braces. move into WordWrap
-
-	return wrapped, nil
-}
-
-func (t *TreeConverter) findBody(n *html.Node) *html.Node {
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		if n.Type == html.ElementNode && n.DataAtom == atom.Body {
-			return n
-		}
-		if body := t.findBody(c); body != nil {
-			return body
+		if err := t.convertNode(b, start, c); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func (t *TreeConverter) doConvert(n *html.Node) ([]string, error) {
-	if n == nil {
-		return nil, nil
-	}
-
-	var parts []string
-
-	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		switch c.Type {
-		case html.CommentNode:
-			if strings.TrimSpace(c.Data) == "start text/html" {
-				for s := c.NextSibling; s != nil; s = s.NextSibling {
-					if s.Type == html.CommentNode && strings.TrimSpace(s.Data) == "end text/html" {
-						c = s
-						break
-					}
-				}
-			}
-			continue
-		case html.TextNode:
-			parts = append(parts, c.Data)
-		case html.ElementNode:
-			switch c.DataAtom {
-			case atom.Script, atom.Style:
-				continue
-			case atom.P:
-				more, err := t.doConvert(c)
-				if err != nil {
-					return nil, err
-				}
-				if len(parts) > 0 {
-					if p := strings.Trim(parts[len(parts)-1], " \t"); len(p) == 0 || p[len(p)-1] != '\n' {
-						parts = append(parts, "\n")
-					}
-				}
-
-				parts = append(parts, more...)
-				parts = append(parts, "\n\n")
-				continue
-			case atom.Ul:
-				li, err := t.listItems(c, unordered)
-				if err != nil {
-					return nil, err
-				}
-				parts = append(parts, li...)
-				continue
-			case atom.Ol:
-				li, err := t.listItems(c, unordered) // XXX: change to ordered
-				if err != nil {
-					return nil, err
-				}
-				parts = append(parts, li...)
-				continue
-			case atom.Li:
-				item, err := t.listItem(c, "* ")
-				if err != nil {
-					return nil, err
-				}
-				parts = append(parts, item)
-				continue
-			case atom.Span:
-				var more []string
-				var err error
-				c, more, err = t.wrapSpans(c)
-				if err != nil {
-					return nil, err
-				}
-				parts = append(parts, more...)
-
-				if c == nil {
-					return parts, nil
-				}
-				continue
-			case atom.Br:
-				parts = append(parts, "\n")
-				continue
-			case atom.H1:
-				more, err := t.headerBlock(c, "*", true)
-				if err != nil {
-					return nil, err
-				}
-				parts = append(parts, more...)
-				continue
-			case atom.H2:
-				more, err := t.headerBlock(c, "-", true)
-				if err != nil {
-					return nil, err
-				}
-				parts = append(parts, more...)
-				continue
-			case atom.H3, atom.H4, atom.H5, atom.H6:
-				more, err := t.headerBlock(c, "-", false)
-				if err != nil {
-					return nil, err
-				}
-				parts = append(parts, more...)
-				continue
-			case atom.Img, atom.Image:
-				if alt := getAttr(c, "alt"); alt != "" {
-					parts = append(parts, strings.TrimSpace(alt))
-				}
-				continue
-			case atom.A:
-				more, err := t.doConvert(c)
-				if err != nil {
-					return nil, err
-				}
-
-				href := getAttr(c, "href")
-				if href == "" {
-					parts = append(parts, more...)
-					continue
-				}
-				text := strings.TrimSpace(strings.Join(more, ""))
-				if text == "" {
-					if alt := getAttr(c, "alt"); alt != "" {
-						text = strings.TrimSpace(text)
-					}
-				}
-
-				href = strings.TrimPrefix(href, "mailto:")
-
-				if text == href {
-					parts = append(parts, href)
-					continue
-				} else if text == "" {
-					if containsImg(c) {
-						parts = append(parts, "( "+href+" )")
-					}
-					continue
-				}
-
-				parts = append(parts, text, " ( ", strings.TrimSpace(href), " )")
-
-				continue
-			}
+// convertNode writes the text of c to b, where start is the offset from which b holds the text
+// of c's parent
+func (t *TreeConverter) convertNode(b *bytes.Buffer, start int, c *html.Node) error {
+	switch c.Type {
+	case html.CommentNode:
+		return nil
+	case html.TextNode:
+		t.convertText(b, c)
+		return nil
+	case html.ElementNode:
+		if err := t.checkSize("convert", b.Len()); err != nil {
+			return err
 		}
-		more, err := t.doConvert(c)
-		if err != nil {
-			return nil, err
+		if handler, ok := elementHandlers[c.DataAtom]; ok {
+			return handler(t, b, start, c)
 		}
-		parts = append(parts, more...)
 	}
-
-	return parts, nil
+	return t.doConvert(b, c)
 }
 
-func containsImg(n *html.Node) bool {
-	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		if c.DataAtom == atom.Img || c.DataAtom == atom.Image {
-			return true
-		}
-		if containsImg(c) {
-			return true
-		}
+// elementHandler writes the text of an element to b, as convertNode does
+type elementHandler func(t *TreeConverter, b *bytes.Buffer, start int, c *html.Node) error
+
+// elementHandlers convert the elements that the TreeConverter renders as more than the text
+// of their children. They are set up in init, as they refer back to doConvert
+var elementHandlers map[atom.Atom]elementHandler
+
+// sectionElements are always set apart from the content around them with blank lines, while
+// containerElements are separated from it as configured with WithContainerBreak
+var (
+	sectionElements   = []atom.Atom{atom.Main, atom.Article, atom.Section}
+	containerElements = []atom.Atom{atom.Div, atom.Center, atom.Address, atom.Figure, atom.Figcaption, atom.Fieldset, atom.Form, atom.Details, atom.Summary}
+)
+
+func init() {
+	elementHandlers = map[atom.Atom]elementHandler{
+		atom.Script:  ignoreElement,
+		atom.Style:   ignoreElement,
+		atom.P:       (*TreeConverter).paragraph,
+		atom.Pre:     (*TreeConverter).paragraph,
+		atom.Ul:      (*TreeConverter).list,
+		atom.Ol:      (*TreeConverter).list,
+		atom.Li:      (*TreeConverter).bareListItem,
+		atom.Br:      lineBreak,
+		atom.Img:     (*TreeConverter).image,
+		atom.Image:   (*TreeConverter).image,
+		atom.Picture: (*TreeConverter).picture,
+		atom.A:       (*TreeConverter).link,
+	}
+	for _, a := range sectionElements {
+		elementHandlers[a] = (*TreeConverter).section
+	}
+	for _, a := range containerElements {
+		elementHandlers[a] = (*TreeConverter).genericContainer
+	}
+	for _, a := range []atom.Atom{atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6} {
+		elementHandlers[a] = (*TreeConverter).heading
 	}
-	return false
 }
 
-func (t *TreeConverter) headerBlock(n *html.Node, blockChar string, prefix bool) ([]string, error) {
-	content, err := t.doConvert(n)
-	if err != nil {
-		return nil, err
+// convertText writes a text node to b
+func (t *TreeConverter) convertText(b *bytes.Buffer, c *html.Node) {
+	if tags, ok := controlTags(c.Data); ok && betweenBlocks(c) {
+		b.WriteString("\n" + strings.Join(tags, "\n") + "\n")
+		return
 	}
-	headerText := strings.TrimSpace(strings.Join(content, ""))
-	var maxSize int
-	for _, line := range strings.Split(headerText, "\n") {
-		if l := len(strings.TrimSpace(line)); l > maxSize {
-			maxSize = l
-		}
+	if strings.TrimLeft(c.Data, " \t\r\n\f") == "" && betweenBlocks(c) {
+		return
 	}
-	delimiter := strings.Repeat(blockChar, maxSize)
-
-	block := []string{"\n\n"}
-	if prefix {
-		block = append(block, delimiter, "\n")
+	if isPassthroughToken(strings.Trim(c.Data, " \t\r\n\f")) && whiteSpaceMode(c.Parent) == "" {
+		// passthrough regions stand as blocks of their own
+		b.WriteString("\n\n" + strings.Trim(c.Data, " \t\r\n\f") + "\n\n")
+		return
 	}
-
-	return append(block, headerText, "\n", delimiter, "\n\n"), nil
+	b.WriteString(collapseWhitespace(c.Data))
 }
 
-func unordered(idx int) string { return "* " }
-func ordered(idx int) string   { return strconv.Itoa(idx) + ". " }
+func ignoreElement(t *TreeConverter, b *bytes.Buffer, start int, c *html.Node) error {
+	return nil
+}
 
-func (t *TreeConverter) listItems(n *html.Node, prefixer func(int) string) ([]string, error) {
-	var parts []string
-	var idx = 1
-	for c := n.FirstChild; c != nil; c = c.NextSibling {
+func lineBreak(t *TreeConverter, b *bytes.Buffer, start int, c *html.Node) error {
+	b.WriteByte('\n')
+	return nil
+}
 
-		switch c.DataAtom {
-		case atom.Li:
-			prefix := prefixer(idx)
-			idx++
+func (t *TreeConverter) paragraph(b *bytes.Buffer, start int, c *html.Node) error {
+	if b.Len() > start && !endsLine(b.Bytes()[start:]) {
+		b.WriteByte('\n')
+	}
+	if err := t.doConvert(b, c); err != nil {
+		return err
+	}
+	b.WriteString("\n\n")
+	return nil
+}
 
-			item, err := t.listItem(c, prefix)
-			if err != nil {
-				return nil, err
-			}
-			parts = append(parts, item)
-		default:
-			contents, err := t.doConvert(c)
-			if err != nil {
-				return nil, err
-			}
-			parts = append(parts, contents...)
-		}
+func (t *TreeConverter) list(b *bytes.Buffer, start int, c *html.Node) error {
+	bullet, suffix := t.listMarkers(nodeLanguage(c))
+	prefixer := func(int) string { return bullet }
+	if c.DataAtom == atom.Ol && t.orderedLists {
+		first := listStart(getAttr(c, "start"))
+		prefixer = func(idx int) string { return ordered(first+idx-1, suffix) }
+	}
+	if err := t.listItems(b, c, prefixer); err != nil {
+		return err
 	}
+	b.WriteString("\n\n")
+	return nil
+}
 
-	return parts, nil
+// bareListItem writes a list item found outside of a list
+func (t *TreeConverter) bareListItem(b *bytes.Buffer, start int, c *html.Node) error {
+	bullet, _ := t.listMarkers(nodeLanguage(c))
+	return t.listItem(b, c, bullet)
 }
 
-func (t *TreeConverter) listItem(n *html.Node, prefix string) (string, error) {
-	contents, err := t.doConvert(n)
-	if err != nil {
-		return "", err
-	}
+func (t *TreeConverter) section(b *bytes.Buffer, start int, c *html.Node) error {
+	return t.container(b, start, c, ParagraphBreak)
+}
 
-	return strings.TrimSpace(prefix+strings.Join(contents, "")) + "\n", nil
+func (t *TreeConverter) genericContainer(b *bytes.Buffer, start int, c *html.Node) error {
+	return t.container(b, start, c, t.containerBreak)
 }
 
-func (t *TreeConverter) wrapSpans(n *html.Node) (*html.Node, []string, error) {
+func (t *TreeConverter) heading(b *bytes.Buffer, start int, c *html.Node) error {
+	return t.headerBlock(b, c)
+}
 
-	var parts []string
+func (t *TreeConverter) image(b *bytes.Buffer, start int, c *html.Node) error {
+	if alt := getAttr(c, "alt"); alt != "" {
+		b.WriteString(strings.TrimSpace(alt))
+	} else if src := getAttr(c, "src"); isDataURI(src) {
+		b.WriteString(t.dataURIPlaceholder(src))
+	}
+	return nil
+}
 
-	var c *html.Node
-	for c = n; c != nil; c = c.NextSibling {
+func (t *TreeConverter) link(b *bytes.Buffer, start int, c *html.Node) error {
+	textStart := b.Len()
+	if err := t.doConvert(b, c); err != nil {
+		return err
+	}
 
-		if c.Type == html.ElementNode && c.DataAtom != atom.Span {
-			return c.PrevSibling, parts, nil
+	href := getAttr(c, "href")
+	if href == "" || t.linkMode == LinkTextOnly {
+		return nil
+	}
+	text := strings.TrimSpace(string(b.Bytes()[textStart:]))
+	b.Truncate(textStart)
+	if text == "" {
+		if alt := getAttr(c, "alt"); alt != "" {
+			text = strings.TrimSpace(text)
 		}
+	}
 
-		var span string
-		switch c.Type {
-		case html.ElementNode:
-			children, err := t.doConvert(c)
-			if err != nil {
-				return c, nil, err
-			}
+	href = strings.TrimSpace(strings.TrimPrefix(href, "mailto:"))
 
-			span = strings.Join(children, "")
-		case html.TextNode:
-			span = c.Data
+	if isDataURI(href) {
+		if text == "" {
+			text = t.dataURIPlaceholder(href)
 		}
-
-		if trimmed := strings.TrimRight(span, "\n\t "); len(trimmed) != len(span) {
-			span = trimmed + " "
+		b.WriteString(text)
+		return nil
+	} else if text == "" {
+		if containsImg(c) {
+			b.WriteString(t.formatHref(href))
 		}
+		return nil
+	}
+
+	b.WriteString(t.formatLink(text, href))
+
+	return nil
+}
 
-		parts = append(parts, span)
+// fixWrappedOpenBraces moves braces left at the end of a line by wrapping to the start of the
+// next, after any hanging indent
+func fixWrappedOpenBraces(text string) string {
+	if !strings.Contains(text, "(\n") {
+		return text
+	}
+	var b strings.Builder
+	for {
+		idx := strings.Index(text, "(\n")
+		if idx < 0 {
+			b.WriteString(text)
+			return b.String()
+		}
+		rest := text[idx+2:]
+		indent := rest[:len(rest)-len(strings.TrimLeft(rest, " "))]
+		b.WriteString(text[:idx] + "\n" + indent + "( ")
+		text = rest[len(indent):]
 	}
+}
 
-	return c, parts, nil
+func containsImg(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom == atom.Img || c.DataAtom == atom.Image {
+			return true
+		}
+		if containsImg(c) {
+			return true
+		}
+	}
+	return false
 }
 
-func (t *TreeConverter) fixSpacing(text string) string {
+// betweenBlocks reports whether n is only bordered by block elements (or the edges of a
+// block element) rather than being part of inline content
+func betweenBlocks(n *html.Node) bool {
+	prev := n.PrevSibling
+	for prev != nil && prev.Type == html.CommentNode {
+		prev = prev.PrevSibling
+	}
+	next := n.NextSibling
+	for next != nil && next.Type == html.CommentNode {
+		next = next.NextSibling
+	}
+	return isBlockBoundary(prev, n.Parent) && isBlockBoundary(next, n.Parent)
+}
 
-	if len(text) < 2 {
-		return text
+func isBlockBoundary(sibling, parent *html.Node) bool {
+	if sibling == nil {
+		return parent == nil || parent.Type != html.ElementNode || blockElements[parent.Data]
 	}
+	return sibling.Type == html.ElementNode && blockElements[sibling.Data]
+}
 
-	processed := make([]byte, 0, len(text))
-	processed = append(processed, text[:2]...)
-	idx := 1
+// container writes the content of a container element to b, separated by brk from the
+// content around it that was written since start
+func (t *TreeConverter) container(b *bytes.Buffer, start int, n *html.Node, brk BlockBreak) error {
+	contentStart := b.Len()
+	if err := t.doConvert(b, n); err != nil {
+		return err
+	}
+	content := string(b.Bytes()[contentStart:])
+	if strings.TrimSpace(content) == "" {
+		return nil
+	}
 
-	var inList = (processed[0] == '*' && processed[1] == ' ')
+	// containers start on a line of their own, as does any content that follows them
+	if contentStart > start {
+		b.Truncate(contentStart)
+		breakLines(b, start, brk.newlines())
+		b.WriteString(content)
+	}
+	if hasContentAfter(n) {
+		breakLines(b, start, brk.newlines())
+	}
+	return nil
+}
 
-tidyLoop:
-	for i := 2; i < len(text); i++ {
+// breakLines writes the newlines needed for the text written to b since start to end in at
+// least n of them, disregarding any trailing spaces
+func breakLines(b *bytes.Buffer, start, n int) {
+	text := b.Bytes()[start:]
+	var newlines int
+	for i := len(text) - 1; i >= 0 && newlines < n; i-- {
+		if text[i] == '\n' {
+			newlines++
+		} else if text[i] != ' ' && text[i] != '\t' {
+			break
+		}
+	}
+	for ; newlines < n; newlines++ {
+		b.WriteByte('\n')
+	}
+}
 
-		switch processed[idx] {
-		case '\n':
+// endsLine reports whether text ends in a newline, disregarding any trailing spaces
+func endsLine(text []byte) bool {
+	text = bytes.TrimRight(text, " \t")
+	return len(text) > 0 && text[len(text)-1] == '\n'
+}
 
-			if text[i] == '\t' || text[i] == ' ' {
-				continue
+// hasContentAfter reports whether any sibling following n holds more than whitespace
+func hasContentAfter(n *html.Node) bool {
+	for c := n.NextSibling; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			if strings.TrimSpace(c.Data) != "" {
+				return true
 			}
+		case html.ElementNode:
+			return true
+		}
+	}
+	return false
+}
 
-			if processed[idx-1] == '\n' && text[i] == '\n' {
-				continue
-			}
+func (t *TreeConverter) headerBlock(b *bytes.Buffer, n *html.Node) error {
+	start := b.Len()
+	if err := t.doConvert(b, n); err != nil {
+		return err
+	}
+	headerText := string(bytes.TrimSpace(b.Bytes()[start:]))
+	b.Truncate(start)
+	b.WriteString("\n\n")
+	b.WriteString(t.formatHeading(headingLevel(n.Data), headerText))
+	b.WriteString("\n\n")
+	return nil
+}
 
-			if inList && text[i] == '\n' {
-				// lookahead through any whitespace to make sure we are still in a list
-				for j := i; j < len(text); j++ {
-					if text[j] == '\t' || text[j] == ' ' || text[j] == '\n' {
-						continue
-					}
-					if text[j] == '*' && j+1 < len(text) && text[j+1] == ' ' {
-						continue tidyLoop
-					}
-				}
-			}
+func (t *TreeConverter) listItems(b *bytes.Buffer, n *html.Node, prefixer func(int) string) error {
+	var idx = 1
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
 
-			if text[i-1] == '*' && text[i] == ' ' {
-				inList = true
-			} else {
-				inList = false
-			}
+		switch c.DataAtom {
+		case atom.Li:
+			prefix := prefixer(idx)
+			idx++
 
-		case ' ':
-			if text[i] == ' ' {
-				continue
+			if err := t.listItem(b, c, prefix); err != nil {
+				return err
 			}
-			if text[i] == '\t' || text[i] == '\n' {
-				processed[idx] = '\n'
-				continue
+		default:
+			if err := t.doConvert(b, c); err != nil {
+				return err
 			}
 		}
-
-		processed = append(processed, text[i])
-		idx++
 	}
 
-	return string(processed)
+	return nil
+}
+
+// listItem writes the text of a list item to b, after prefix. The text is written in place
+// behind the prefix, which starts with no whitespace to trim, so that only its end is trimmed
+func (t *TreeConverter) listItem(b *bytes.Buffer, n *html.Node, prefix string) error {
+	start := b.Len()
+	b.WriteString(prefix)
+	if err := t.doConvert(b, n); err != nil {
+		return err
+	}
+	b.Truncate(start + len(bytes.TrimRightFunc(b.Bytes()[start:], unicode.IsSpace)))
+	b.WriteByte('\n')
+	return nil
 }
 
 func getAttr(n *html.Node, name string) string {
@@ -375,3 +401,12 @@ func getAttr(n *html.Node, name string) string {
 	}
 	return ""
 }
+
+func hasAttr(n *html.Node, name string) bool {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// footerRule separates the unsubscribe footer from the rest of the text
+const footerRule = "----------"
+
+var (
+	// unsubscribeHrefs are fragments of the urls (and esp merge tags) used for unsubscribe links
+	unsubscribeHrefs = []string{"unsubscribe", "unsub", "optout", "opt-out", "opt_out", "list-manage.com/profile"}
+	// preferenceHrefs are fragments of the urls used for subscription preference centres
+	preferenceHrefs = []string{"preferences", "preference-center", "preference_center", "manage-subscription", "subscription-center"}
+	// unsubscribeTexts are phrases found in the text of unsubscribe and preference links
+	unsubscribeTexts = []string{"unsubscribe", "opt out", "opt-out", "manage subscription", "manage your subscription"}
+	// preferenceTexts are phrases found in the text of preference links, and of many others,
+	// so they're only taken to mark a preference link alongside one of the preferenceHrefs
+	preferenceTexts = []string{"preferences"}
+)
+
+// extractUnsubscribeLinks removes unsubscribe and preference centre links from the document,
+// returning them formatted for the footer section of the text
+func (o *options) extractUnsubscribeLinks(body *html.Node) []string {
+	var anchors []*html.Node
+	var find func(*html.Node)
+	find = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.A && getAttr(c, "href") != "" {
+				anchors = append(anchors, c)
+				continue
+			}
+			find(c)
+		}
+	}
+	find(body)
+
+	var links []string
+	for _, a := range anchors {
+		href := strings.TrimSpace(getAttr(a, "href"))
+		var text strings.Builder
+		writeTextContent(&text, a)
+		label := strings.Join(strings.Fields(text.String()), " ")
+
+		lowerHref, lowerLabel := strings.ToLower(href), strings.ToLower(label)
+		switch {
+		case containsAny(lowerLabel, unsubscribeTexts):
+		case containsAny(lowerLabel, preferenceTexts) && containsAny(lowerHref, preferenceHrefs):
+		case containsAny(lowerHref, unsubscribeHrefs):
+			label = "Unsubscribe"
+		case containsAny(lowerHref, preferenceHrefs):
+			label = "Manage preferences"
+		default:
+			continue
+		}
+
+		a.Parent.RemoveChild(a)
+		links = append(links, o.formatLink(label, strings.TrimSpace(strings.TrimPrefix(href, "mailto:"))))
+	}
+
+	return links
+}
+
+func containsAny(s string, fragments []string) bool {
+	for _, fragment := range fragments {
+		if strings.Contains(s, fragment) {
+			return true
+		}
+	}
+	return false
+}
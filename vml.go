@@ -0,0 +1,43 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// unwrapVML replaces the namespaced VML/Office elements (`v:roundrect`, `v:fill`, `o:p`, ...)
+// used for Outlook bulletproof buttons and backgrounds with their children. VML shapes that
+// carry an href become regular anchors so the button text keeps its link
+func unwrapVML(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		unwrapVML(c)
+
+		if c.Type == html.ElementNode && strings.Contains(c.Data, ":") {
+			if href := getAttr(c, "href"); href != "" && !containsAnchor(c) {
+				c.Data, c.DataAtom, c.Namespace = "a", atom.A, ""
+				c.Attr = []html.Attribute{{Key: "href", Val: href}}
+			} else {
+				// the parser doesn't honour self-closing syntax for unknown elements, so
+				// hoisting the children also undoes any content that got nested within them
+				for gc := c.FirstChild; gc != nil; gc = c.FirstChild {
+					c.RemoveChild(gc)
+					n.InsertBefore(gc, c)
+				}
+				n.RemoveChild(c)
+			}
+		}
+		c = next
+	}
+}
+
+func containsAnchor(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom == atom.A || containsAnchor(c) {
+			return true
+		}
+	}
+	return false
+}
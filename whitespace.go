@@ -0,0 +1,184 @@
+package textplain
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// preformattedElements render their whitespace as `white-space:pre` unless styled otherwise
+var preformattedElements = map[string]bool{
+	"pre": true, "textarea": true, "listing": true, "xmp": true, "plaintext": true,
+}
+
+// tabWidth is the number of columns between the tab stops of preformatted text
+const tabWidth = 8
+
+// preserveWhitespace keeps the whitespace of `<pre>` and other preformatted elements, as well
+// as of elements styled with `white-space:pre`, `pre-wrap` or `pre-line`, from collapsing, so
+// that their line breaks and (bar pre-line) runs of spaces reach the text version as they
+// appear in the html version. Their content is converted like any other, with the line breaks
+// of its text replaced by `<br>` elements and the spaces to keep by no-break markers. Only
+// `white-space:pre` content is kept from wrapping, as the others wrap in browsers too
+func preserveWhitespace(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+
+		mode := whiteSpaceMode(c)
+		if mode == "" {
+			preserveWhitespace(c)
+			continue
+		}
+
+		// as with passthrough regions, blank lines at the edges of the content are dropped
+		var texts []*html.Node
+		collectText(c, &texts)
+		if len(texts) > 0 {
+			first, last := texts[0], texts[len(texts)-1]
+			first.Data = first.Data[leadingBlankLines(first.Data):]
+			last.Data = last.Data[:len(last.Data)-trailingBlankLines(last.Data)]
+		}
+		keepWhitespace(c, mode)
+	}
+}
+
+// keepWhitespace rewrites the text below n, whose whitespace is rendered as the given
+// `white-space` mode, leaving any descendants styled otherwise to preserveWhitespace
+func keepWhitespace(n *html.Node, mode string) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.TextNode:
+			if c.Parent.DataAtom != atom.Script && c.Parent.DataAtom != atom.Style {
+				replaceText(c, keptLines(c.Data, mode))
+			}
+		case html.ElementNode:
+			if _, ok := inlineStyle(c)["white-space"]; !ok {
+				keepWhitespace(c, mode)
+			} else if own := whiteSpaceMode(c); own != "" {
+				keepWhitespace(c, own)
+			} else {
+				preserveWhitespace(c)
+			}
+		}
+		c = next
+	}
+}
+
+// keptLines returns the lines of text with their whitespace rewritten to survive conversion as
+// the given `white-space` mode renders it. Blank lines between others are marked, so that they
+// aren't collapsed with the blank lines around blocks
+func keptLines(text, mode string) []string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		switch {
+		case mode == "pre-line":
+			line = collapseWhitespace(line)
+		case strings.Trim(line, " \t\r\f") == "":
+			line = strings.Repeat(noBreakMarker, columns(line))
+		default:
+			line = keptSpaces(line, mode == "pre-wrap")
+		}
+		if line == "" && i > 0 && i < len(lines)-1 {
+			line = blankLineMarker
+		}
+		lines[i] = line
+	}
+	return lines
+}
+
+// keptSpaces replaces the spaces and tabs of line with no-break markers, expanding tabs to the
+// next tab stop. Where breakable is set, the last space of each run within the line is kept as
+// a regular space for the line to wrap at
+func keptSpaces(line string, breakable bool) string {
+	var b strings.Builder
+	var column int
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ', '\t', '\r', '\f':
+			width := 1
+			if line[i] == '\t' {
+				width = tabWidth - column%tabWidth
+			}
+			column += width
+
+			more := i+1 < len(line) && strings.IndexByte(" \t\r\f", line[i+1]) >= 0
+			if breakable && !more && b.Len() > 0 && i+1 < len(line) {
+				b.WriteString(strings.Repeat(noBreakMarker, width-1) + " ")
+			} else {
+				b.WriteString(strings.Repeat(noBreakMarker, width))
+			}
+		default:
+			b.WriteByte(line[i])
+			if line[i] < 0x80 || line[i] >= 0xc0 {
+				column++
+			}
+		}
+	}
+	return b.String()
+}
+
+// columns returns the number of columns a run of spaces and tabs takes
+func columns(blank string) int {
+	var column int
+	for i := 0; i < len(blank); i++ {
+		if blank[i] == '\t' {
+			column += tabWidth - column%tabWidth
+		} else {
+			column++
+		}
+	}
+	return column
+}
+
+// replaceText replaces the text node n with the given lines, separated by `<br>` elements
+func replaceText(n *html.Node, lines []string) {
+	parent := n.Parent
+	for i, line := range lines {
+		if i > 0 {
+			parent.InsertBefore(&html.Node{Type: html.ElementNode, DataAtom: atom.Br, Data: "br"}, n)
+		}
+		if line != "" {
+			parent.InsertBefore(&html.Node{Type: html.TextNode, Data: line}, n)
+		}
+	}
+	parent.RemoveChild(n)
+}
+
+// leadingBlankLines returns the length of the lines at the start of text that consist only of
+// whitespace
+func leadingBlankLines(text string) int {
+	content := strings.IndexFunc(text, func(r rune) bool { return !strings.ContainsRune(" \t\r\n\f", r) })
+	if content < 0 {
+		content = len(text)
+	}
+	return strings.LastIndexByte(text[:content], '\n') + 1
+}
+
+// trailingBlankLines returns the length of the lines at the end of text that consist only of
+// whitespace, along with the line break before them
+func trailingBlankLines(text string) int {
+	content := len(strings.TrimRight(text, " \t\r\n\f"))
+	idx := strings.IndexByte(text[content:], '\n')
+	if idx < 0 {
+		return 0
+	}
+	return len(text) - content - idx
+}
+
+// whiteSpaceMode returns the preserving `white-space` mode of n, or an empty string where its
+// whitespace collapses
+func whiteSpaceMode(n *html.Node) string {
+	mode, ok := inlineStyle(n)["white-space"]
+	if !ok && preformattedElements[n.Data] {
+		return "pre"
+	}
+	switch mode {
+	case "pre", "pre-wrap", "pre-line":
+		return mode
+	}
+	return ""
+}
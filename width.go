@@ -0,0 +1,94 @@
+package textplain
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// Block width markers carry the line length configured for a block through conversion to
+// wrapping. Each is a single character from the supplementary private use area, offset from
+// widthMarkerBase by the line length it sets, where zero leaves lines unwrapped
+const (
+	widthMarkerBase = 0xf0000
+	maxMarkedWidth  = 0xfffd
+)
+
+// widthMarker returns the marker setting the line length of a block
+func widthMarker(lineLength int) string {
+	switch {
+	case lineLength < 0:
+		lineLength = 0
+	case lineLength > maxMarkedWidth:
+		lineLength = maxMarkedWidth
+	}
+	return string(rune(widthMarkerBase + lineLength))
+}
+
+func isWidthMarker(r rune) bool {
+	return r >= widthMarkerBase && r <= widthMarkerBase+maxMarkedWidth
+}
+
+// hasWidthMarkers reports whether text holds any block width markers
+func hasWidthMarkers(text string) bool {
+	return strings.IndexFunc(text, isWidthMarker) >= 0
+}
+
+// lineWidth removes any block width markers from line, returning it along with the line
+// length set by the first of them
+func lineWidth(line string) (string, int, bool) {
+	idx := strings.IndexFunc(line, isWidthMarker)
+	if idx < 0 {
+		return line, 0, false
+	}
+	r, _ := utf8.DecodeRuneInString(line[idx:])
+	return stripWidthMarkers(line), int(r) - widthMarkerBase, true
+}
+
+// stripWidthMarkers removes any block width markers from text
+func stripWidthMarkers(text string) string {
+	if !hasWidthMarkers(text) {
+		return text
+	}
+	return strings.Map(func(r rune) rune {
+		if isWidthMarker(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// firstWidthMarker returns the first block width marker in text, or an empty string if it has
+// none, so that decorations such as heading underlines can share the line length of their
+// block
+func firstWidthMarker(text string) string {
+	idx := strings.IndexFunc(text, isWidthMarker)
+	if idx < 0 {
+		return ""
+	}
+	_, size := utf8.DecodeRuneInString(text[idx:])
+	return text[idx : idx+size]
+}
+
+// markBlockWidths places a width marker in the text below each element with a configured line
+// length, after the leading whitespace of every text node so that lines broken within the
+// block, eg. by `<br>`, carry it as well. Nested blocks take their own line length
+func (o *options) markBlockWidths(n *html.Node, marker string) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			trimmed := strings.TrimLeft(c.Data, " \t\r\n\f")
+			if marker == "" || trimmed == "" || isPassthroughToken(strings.TrimSpace(trimmed)) {
+				continue
+			}
+			c.Data = c.Data[:len(c.Data)-len(trimmed)] + marker + trimmed
+		case html.ElementNode:
+			inner := marker
+			if lineLength, ok := o.blockWidths[c.Data]; ok {
+				inner = widthMarker(lineLength)
+			}
+			o.markBlockWidths(c, inner)
+		}
+	}
+}
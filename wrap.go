@@ -2,44 +2,298 @@ package textplain
 
 import "strings"
 
-// WordWrap searches for logical breakpoints in each line (whitespace) and tries to trim each
-// line to the specified length
+// WordWrap searches for logical breakpoints in each line (whitespace, or between Chinese and
+// Japanese characters following the kinsoku rules) and tries to trim each line to the
+// specified length, measured in user-perceived characters (grapheme clusters) rather than
+// bytes. Template merge tags such as `{{ name }}` are never split, nor are any regions marked
+// with NoWrap. The lines wrapped from an indented line, such as indented code or css, keep its
+// indentation
 // Note: this diverges from the regex approach in premailer, which I found to be significantly
 // slower in cases with long unbroken lines
 // https://github.com/premailer/premailer/blob/7c94e7a/lib/premailer/html_to_plain_text.rb#L116
 func WordWrap(txt string, lineLength int) string {
+	return WordWrapOpts(txt, WrapOptions{Width: lineLength, PreserveIndent: true})
+}
+
+// HardWrap wraps like WordWrap, but force-breaks words longer than lineLength at the limit
+// rather than letting them overflow, for consumers with strict column limits. The optional
+// continuation marker, eg. `-` or `\`, ends every line that was broken mid-word and counts
+// towards its length. Merge tags are still never split, while NoWrap regions are only broken
+// where they are too long to fit on a line of their own
+func HardWrap(txt string, lineLength int, continuation string) string {
+	return WordWrapOpts(txt, WrapOptions{Width: lineLength, BreakLongWords: true, Continuation: continuation, PreserveIndent: true})
+}
+
+// WordWrapPrefixed wraps like WordWrap, starting every line with prefix, eg. `> ` or an
+// indent, and counting it towards the line length. Blank lines are given the prefix without
+// its trailing whitespace
+func WordWrapPrefixed(txt, prefix string, lineLength int) string {
+	return WordWrapOpts(txt, WrapOptions{Width: lineLength, Prefix: prefix, PreserveIndent: true})
+}
+
+// BreakCharacters are the characters, beyond spaces, after which WordWrapAt breaks lines by
+// default: hyphens, en and em dashes and slashes
+const BreakCharacters = "-\u2013\u2014/"
+
+// WordWrapAt wraps like WordWrap, but may also break lines after any of the characters in
+// breakAfter, see BreakCharacters, so that long hyphenated or path-like words can be wrapped
+func WordWrapAt(txt string, lineLength int, breakAfter string) string {
+	return WordWrapOpts(txt, WrapOptions{Width: lineLength, BreakChars: breakAfter, PreserveIndent: true})
+}
+
+// WrapOptions configures WordWrapOpts, which combines the behaviours of the other wrapping
+// functions
+type WrapOptions struct {
+	// Width is the maximum length of a line, including any Prefix, in user-perceived
+	// characters. A width of zero or less disables wrapping
+	Width int
+
+	// BreakLongWords force-breaks words that don't fit on a line, as HardWrap does, ending each
+	// line broken mid-word with Continuation
+	BreakLongWords bool
+	Continuation   string
+
+	// BreakChars are the characters, beyond spaces, after which lines may break, see
+	// BreakCharacters
+	BreakChars string
+
+	// MinimumRaggedness chooses the breaks of each paragraph so as to even out the length of
+	// its lines, rather than filling every line in turn, for more pleasing long-form text. It
+	// has no effect where BreakLongWords is set
+	MinimumRaggedness bool
+
+	// PreserveIndent keeps the lines wrapped from an indented line at its indentation, rather
+	// than starting them at the first column
+	PreserveIndent bool
+
+	// Prefix starts every line, eg. `> ` to quote the text, see WordWrapPrefixed
+	Prefix string
+}
+
+// WordWrapOpts wraps txt as configured by o
+func WordWrapOpts(txt string, o WrapOptions) string {
+	w := wrapper{
+		hard:           o.BreakLongWords,
+		continuation:   o.Continuation,
+		breakAfter:     o.BreakChars,
+		preserveIndent: o.PreserveIndent,
+		balanced:       o.MinimumRaggedness,
+	}
+	if o.Prefix == "" {
+		return w.wrap(txt, o.Width)
+	}
 
-	// A line length of zero or less indicates no wrapping
-	if lineLength <= 0 {
-		return txt
+	width := o.Width
+	if width > 0 {
+		if width -= len(graphemes(o.Prefix)); width < 1 {
+			width = 1
+		}
+	}
+
+	lines := strings.Split(w.wrap(txt, width), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = strings.TrimRight(o.Prefix, " \t")
+		} else {
+			lines[i] = o.Prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// wrapper holds the configuration of a wrapping style. hanging indents the continuation
+// lines of list items to align under the item text, using indent in place of spaces if set,
+// while preserveIndent keeps the continuation lines of any indented line at its indentation.
+// balanced selects minimum raggedness line breaking over the greedy default
+type wrapper struct {
+	hard           bool
+	continuation   string
+	breakAfter     string
+	hanging        bool
+	indent         string
+	preserveIndent bool
+	balanced       bool
+}
+
+func (w wrapper) wrap(txt string, lineLength int) string {
+
+	// A line length of zero or less indicates no wrapping, bar blocks with a line length of
+	// their own
+	if lineLength <= 0 && !hasWidthMarkers(txt) {
+		return stripNoWrap(txt)
 	}
 
 	var final []string
 	for _, line := range strings.Split(txt, "\n") {
-		var startIndex, endIndex int
-		for (len(line)-endIndex) > lineLength && startIndex < len(line) {
-			endIndex += lineLength
-			if endIndex >= len(line) {
-				endIndex = len(line) - 1
-			} else if endIndex < startIndex {
-				endIndex = startIndex
+		lineLength := lineLength
+		if marked, width, ok := lineWidth(line); ok {
+			line, lineLength = marked, width
+		}
+		if lineLength <= 0 {
+			final = append(final, stripNoWrap(line))
+			continue
+		}
+
+		// the marker mustn't leave the broken lines without any room
+		continuation := w.continuation
+		if len(graphemes(continuation)) >= lineLength {
+			continuation = ""
+		}
+
+		// lead starts the first line and indent the ones wrapped from it
+		var lead, indent string
+		switch bullet := listBullet(line); {
+		case w.hanging && bullet != "":
+			// continuation lines of list items align under the item text
+			lead, indent = bullet, strings.Repeat(" ", len(bullet))
+			if w.indent != "" {
+				indent = strings.Repeat(w.indent, len(bullet))
 			}
+		case w.preserveIndent:
+			lead = line[:len(line)-len(strings.TrimLeft(line, " \t"+noBreakMarker+hangingIndentMarker))]
+			indent = lead
+		}
+		width := len(graphemes(lead))
+		if lead == "" || lineLength <= width+1 {
+			final = append(final, w.wrapLine(line, lineLength, continuation)...)
+			continue
+		}
 
-			newIndex := strings.LastIndex(line[startIndex:endIndex+1], " ")
-			if newIndex <= 0 {
-				continue
+		for i, wrapped := range w.wrapLine(line[len(lead):], lineLength-width, continuation) {
+			switch {
+			case i == 0:
+				final = append(final, lead+wrapped)
+			case wrapped == "":
+				final = append(final, wrapped)
+			default:
+				final = append(final, indent+wrapped)
 			}
+		}
+	}
+
+	return strings.Join(final, "\n")
+}
+
+// wrapLine wraps a single line of text
+func (w wrapper) wrapLine(line string, lineLength int, continuation string) []string {
+	var final []string
+	line, protected := noWrapSpans(line)
+	clusters := graphemes(line)
+	tags := graphemeSpans(clusters, mergeTagSpans(line))
+	unbreakable := append(graphemeSpans(clusters, protected), tags...)
+	if w.balanced && !w.hard {
+		return balancedLines(line, clusters, unbreakable, lineLength, w.breakAfter)
+	}
+
+	offsets := clusterOffsets(clusters)
 
-			final = append(final, line[startIndex:startIndex+newIndex])
+	var startIndex, endIndex int
+	for (len(clusters)-endIndex) > lineLength && startIndex < len(clusters) {
+		endIndex += lineLength
+		if endIndex >= len(clusters) {
+			endIndex = len(clusters) - 1
+		} else if endIndex < startIndex {
+			endIndex = startIndex
+		}
+
+		newIndex := lastBreak(clusters, startIndex, endIndex+1, unbreakable, w.breakAfter)
+		switch {
+		case newIndex <= 0 && w.hard:
+			// no-wrap regions too long for any line are broken all the same, merge tags never
+			cut := forceBreak(startIndex, lineLength-len(graphemes(continuation)), tags)
+			broken := strings.TrimRight(line[offsets[startIndex]:offsets[cut]], " ")
+			if cut < len(clusters) && clusters[cut] != " " {
+				broken += continuation
+			}
+			final = append(final, broken)
+			startIndex, endIndex = cut, cut
+		case newIndex <= 0:
+			continue
+		default:
+			// a run of spaces leaves all but the last ahead of the break
+			final = append(final, strings.TrimRight(line[offsets[startIndex]:offsets[startIndex+newIndex]], " "))
 			startIndex += newIndex
 			endIndex = startIndex
+		}
+
+		// clear any extra space
+		for ; startIndex < len(clusters) && clusters[startIndex] == " "; startIndex++ {
+		}
+	}
+	return append(final, line[offsets[startIndex]:])
+}
 
-			// clear any extra space
-			for ; startIndex < len(line) && line[startIndex] == ' '; startIndex++ {
+// forceBreak returns the offset at which to break a word starting at start that doesn't fit
+// within width, moving the break out of any merge tag that it would split
+func forceBreak(start, width int, spans [][2]int) int {
+	cut := start + width
+	for _, span := range spans {
+		if cut > span[0] && cut < span[1] {
+			if span[0] > start {
+				return span[0]
 			}
+			return span[1]
 		}
-		final = append(final, line[startIndex:])
 	}
+	return cut
+}
 
-	return strings.Join(final, "\n")
+// mergeTagDelimiters are the opening and closing delimiters of the templating languages
+// (Handlebars, Liquid, ERB and Mailchimp) whose merge tags must never be split by wrapping
+var mergeTagDelimiters = [][2]string{
+	{"{{", "}}"},
+	{"{%", "%}"},
+	{"<%", "%>"},
+	{"*|", "|*"},
+}
+
+// mergeTagSpans returns the [start, end) offsets of every merge tag in the line
+func mergeTagSpans(line string) [][2]int {
+	var spans [][2]int
+	for i := 0; i < len(line)-1; i++ {
+		for _, delim := range mergeTagDelimiters {
+			if !strings.HasPrefix(line[i:], delim[0]) {
+				continue
+			}
+			if end := strings.Index(line[i+len(delim[0]):], delim[1]); end >= 0 {
+				end += i + len(delim[0]) + len(delim[1])
+				spans = append(spans, [2]int{i, end})
+				i = end - 1
+			}
+			break
+		}
+	}
+	return spans
+}
+
+// lastBreak finds the last break opportunity in clusters[start:end] that doesn't fall within
+// one of the supplied spans, returning the length of the line up to it or -1. Lines break at
+// spaces, which are dropped, after any of the breakAfter characters that follow a word and
+// between CJK characters, following the kinsoku rules
+func lastBreak(clusters []string, start, end int, spans [][2]int, breakAfter string) int {
+	for i := end - 1; i > start; i-- {
+		cut := -1
+		switch {
+		case clusters[i] == " ":
+			cut = i
+		case breakAfter != "" && i < end-1 && clusters[i-1] != " " && strings.Contains(breakAfter, clusters[i]):
+			cut = i + 1
+		case clusters[i-1] != " " && cjkBreak(clusters[i-1], clusters[i]):
+			cut = i
+		}
+		if cut >= 0 && !withinSpan(cut, spans) {
+			return cut - start
+		}
+	}
+	return -1
+}
+
+// withinSpan reports whether offset falls strictly inside one of the spans
+func withinSpan(offset int, spans [][2]int) bool {
+	for _, span := range spans {
+		if offset > span[0] && offset < span[1] {
+			return true
+		}
+	}
+	return false
 }
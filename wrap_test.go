@@ -1,6 +1,7 @@
 package textplain_test
 
 import (
+	"bytes"
 	"strings"
 	"testing"
 
@@ -24,7 +25,10 @@ func TestWrappingTrailingWhitespace(t *testing.T) {
 	body := "1 23 45\n67\n1234567890 1   "
 
 	wrapped := textplain.WordWrap(body, 13)
-	assert.Equal(t, "1 23 45\n67\n1234567890 1 \n", wrapped)
+	assert.Equal(t, "1 23 45\n67\n1234567890 1\n", wrapped)
+
+	wrapped = textplain.WordWrap("* item   ", 7)
+	assert.Equal(t, "* item\n", wrapped)
 
 	wrapped = textplain.WordWrap("1234567890"+strings.Repeat(" ", 20), 10)
 	assert.Equal(t, "1234567890\n", wrapped)
@@ -36,3 +40,136 @@ func TestWrappingShorterThanLimit(t *testing.T) {
 	wrapped := textplain.WordWrap(body, 3)
 	assert.Equal(t, "1\n12\n12\n1", wrapped)
 }
+
+func TestWrappingMergeTags(t *testing.T) {
+	body := "Dear {{ first_name }}, <%= greeting %> *|LNAME|* {% endif %}"
+
+	wrapped := textplain.WordWrap(body, 10)
+	assert.Equal(t, "Dear\n{{ first_name }},\n<%= greeting %>\n*|LNAME|*\n{% endif %}", wrapped)
+}
+
+func TestWrappingMultibyte(t *testing.T) {
+	body := "cédille garçon façade élève"
+
+	wrapped := textplain.WordWrap(body, 14)
+	assert.Equal(t, "cédille garçon\nfaçade élève", wrapped)
+
+	wrapped = textplain.WordWrap("日本語の テキスト", 5)
+	assert.Equal(t, "日本語の\nテキスト", wrapped)
+}
+
+func TestWrappingGraphemeClusters(t *testing.T) {
+	family := "\U0001F468\u200d\U0001F469\u200d\U0001F467\u200d\U0001F466"
+	flag := "\U0001F1EB\U0001F1F7"
+	accented := "e\u0301te\u0301"
+
+	wrapped := textplain.WordWrap("ab "+family+" "+flag+" "+accented, 6)
+	assert.Equal(t, "ab "+family+" "+flag+"\n"+accented, wrapped)
+
+	wrapped = textplain.WordWrap(flag+flag+flag+" "+accented+" x", 4)
+	assert.Equal(t, flag+flag+flag+"\n"+accented+"\nx", wrapped)
+}
+
+func TestHardWrap(t *testing.T) {
+	body := "Visit https://example.com/a/very/long/path today"
+
+	assert.Equal(t, "Visit\nhttps://ex\nample.com/\na/very/lon\ng/path\ntoday", textplain.HardWrap(body, 10, ""))
+	assert.Equal(t, "Visit\nhttps://e\\\nxample.co\\\nm/a/very/\\\nlong/path\ntoday", textplain.HardWrap(body, 10, "\\"))
+	assert.Equal(t, "Visit\nhttps://example.com/a/very/long/path\ntoday", textplain.WordWrap(body, 10))
+
+	assert.Equal(t, "Hi\n{{ first_name }}\nabcd\nef", textplain.HardWrap("Hi {{ first_name }} abcdef", 4, ""))
+}
+
+func TestWordWrapAt(t *testing.T) {
+	body := "Order the Super-Deluxe-Widget-Pro from shop/widgets/deluxe today"
+
+	assert.Equal(t, "Order the\nSuper-Deluxe-Widget-Pro\nfrom\nshop/widgets/deluxe\ntoday", textplain.WordWrap(body, 12))
+	assert.Equal(t, "Order the\nSuper-\nDeluxe-\nWidget-Pro\nfrom shop/\nwidgets/\ndeluxe today", textplain.WordWrapAt(body, 12, textplain.BreakCharacters))
+	assert.Equal(t, "pages 10\u2013\n20", textplain.WordWrapAt("pages 10\u201320", 9, textplain.BreakCharacters))
+	assert.Equal(t, "a\n-bc", textplain.WordWrapAt("a -bc", 3, "-"))
+}
+
+func TestWrappingKinsoku(t *testing.T) {
+	assert.Equal(t, "今日は良い天気で\nす。", textplain.WordWrap("今日は良い天気です。", 9))
+	assert.Equal(t, "明日は\n「晴れ」", textplain.WordWrap("明日は「晴れ」", 4))
+	assert.Equal(t, "ニュースレ\nターを購読", textplain.WordWrap("ニュースレターを購読", 6))
+	assert.Equal(t, "Hello\nworld", textplain.WordWrap("Hello world", 7))
+}
+
+func TestWordWrapWriter(t *testing.T) {
+	text := "1 12 12 1\nDear {{ first_name }}, <%= greeting %> *|LNAME|* {% endif %}\n" +
+		strings.Repeat("word ", 100) + "finish"
+
+	var buf bytes.Buffer
+	w := textplain.WordWrapWriter(&buf, 10)
+	for i := 0; i < len(text); i += 7 {
+		end := i + 7
+		if end > len(text) {
+			end = len(text)
+		}
+		_, err := w.Write([]byte(text[i:end]))
+		assert.Nil(t, err)
+	}
+
+	assert.NotContains(t, buf.String(), "finish")
+	assert.Greater(t, buf.Len(), 0)
+
+	assert.Nil(t, w.Close())
+	assert.Equal(t, textplain.WordWrap(text, 10), buf.String())
+}
+
+func TestWordWrapPrefixed(t *testing.T) {
+	assert.Equal(t, "> 1 23\n> 45\n>\n> 67", textplain.WordWrapPrefixed("1 23 45\n\n67", "> ", 7))
+	assert.Equal(t, "    one\n    two", textplain.WordWrapPrefixed("one two", "    ", 8))
+	assert.Equal(t, "\u00bb one\n\u00bb two", textplain.WordWrapPrefixed("one two", "\u00bb ", 5))
+}
+
+func TestWrappingNoWrapRegions(t *testing.T) {
+	body := "see " + textplain.NoWrap("( https://example.com/a b )") + " now"
+
+	assert.Equal(t, "see\n( https://example.com/a b )\nnow", textplain.WordWrap(body, 10))
+	assert.Equal(t, "see ( https://example.com/a b ) now", textplain.WordWrap(body, -1))
+	assert.Equal(t, "see\nhttps://example.com/a/b", textplain.WordWrapAt("see "+textplain.NoWrap("https://example.com/a/b"), 10, "/"))
+
+	// regions may span lines
+	assert.Equal(t, "a\nb c d\ne f\ng", textplain.WordWrap("a "+textplain.NoWrapStart+"b c d\ne f"+textplain.NoWrapEnd+" g", 3))
+
+	// hard wrapping only breaks regions too long for a line of their own
+	assert.Equal(t, "see\nlong-\nword", textplain.HardWrap("see "+textplain.NoWrap("longword"), 5, "-"))
+}
+
+func TestWordWrapOpts(t *testing.T) {
+	assert.Equal(t, "1 23\n45", textplain.WordWrapOpts("1 23 45", textplain.WrapOptions{Width: 4}))
+
+	assert.Equal(t, "  .rule {\n    color: red;\n    background:\n    none;\n  }",
+		textplain.WordWrapOpts("  .rule {\n    color: red;\n    background: none;\n  }", textplain.WrapOptions{Width: 16, PreserveIndent: true}))
+
+	assert.Equal(t, "> see\n> https:/\n> /exampl-\n> e.com",
+		textplain.WordWrapOpts("see https://example.com", textplain.WrapOptions{
+			Width:          10,
+			BreakLongWords: true,
+			Continuation:   "-",
+			BreakChars:     "/",
+			Prefix:         "> ",
+		}))
+}
+
+func TestWrappingMinimumRaggedness(t *testing.T) {
+	balanced := textplain.WrapOptions{Width: 6, MinimumRaggedness: true}
+
+	assert.Equal(t, "aaa bb\ncc\nddddd", textplain.WordWrap("aaa bb cc ddddd", 6))
+	assert.Equal(t, "aaa\nbb cc\nddddd", textplain.WordWrapOpts("aaa bb cc ddddd", balanced))
+
+	// the last line is free to be short, long words overflow and merge tags stay whole
+	assert.Equal(t, "aaaa bb\ncccc d", textplain.WordWrapOpts("aaaa bb cccc d", textplain.WrapOptions{Width: 7, MinimumRaggedness: true}))
+	assert.Equal(t, "a\nlongword\nb", textplain.WordWrapOpts("a longword b", balanced))
+	assert.Equal(t, "a\n{{ x y }}\nb", textplain.WordWrapOpts("a {{ x y }} b", balanced))
+	assert.Equal(t, "a b\n\nc", textplain.WordWrapOpts("a b\n\nc", balanced))
+}
+
+func TestWrappingPreservesIndentation(t *testing.T) {
+	body := ".stylesheet {\n\t\tbackground-image: url('data:image/png;base64,AAAA');\n}"
+
+	assert.Equal(t, ".stylesheet {\n\t\tbackground-image:\n\t\turl('data:image/png;base64,AAAA');\n}", textplain.WordWrap(body, 40))
+	assert.Equal(t, "> def f():\n>     return some_value +\n>     other_value", textplain.QuoteText("def f():\n    return some_value + other_value", 25))
+}
@@ -0,0 +1,76 @@
+package textplain
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// WordWrapWriter returns a writer that word wraps the text written to it, as WordWrap does,
+// before passing it on to w. Text is wrapped a line at a time, so a line is only written once
+// its newline has been, or once it has grown long enough that its leading wrapped lines are
+// settled. Close writes any remaining text, without closing w
+func WordWrapWriter(w io.Writer, lineLength int) io.WriteCloser {
+	return &wrapWriter{w: w, lineLength: lineLength, wrapping: wrapper{preserveIndent: true}}
+}
+
+type wrapWriter struct {
+	w          io.Writer
+	lineLength int
+	wrapping   wrapper
+	line       []byte
+}
+
+// settleLength is the number of line lengths of text that a line without a newline may
+// buffer before its leading wrapped lines are written
+const settleLength = 8
+
+func (ww *wrapWriter) Write(p []byte) (int, error) {
+	for written := 0; written < len(p); {
+		idx := bytes.IndexByte(p[written:], '\n')
+		if idx < 0 {
+			ww.line = append(ww.line, p[written:]...)
+			if err := ww.settle(); err != nil {
+				return len(p), err
+			}
+			return len(p), nil
+		}
+
+		ww.line = append(ww.line, p[written:written+idx]...)
+		if _, err := io.WriteString(ww.w, ww.wrapping.wrap(string(ww.line), ww.lineLength)+"\n"); err != nil {
+			return written, err
+		}
+		ww.line = ww.line[:0]
+		written += idx + 1
+	}
+	return len(p), nil
+}
+
+// settle writes the leading wrapped lines of an overlong buffered line, keeping back the
+// last, which may yet grow
+func (ww *wrapWriter) settle() error {
+	if ww.lineLength <= 0 || len(ww.line) < settleLength*ww.lineLength {
+		return nil
+	}
+
+	wrapped := ww.wrapping.wrap(string(ww.line), ww.lineLength)
+	idx := strings.LastIndexByte(wrapped, '\n')
+	if idx < 0 {
+		return nil
+	}
+	if _, err := io.WriteString(ww.w, wrapped[:idx+1]); err != nil {
+		return err
+	}
+	ww.line = append(ww.line[:0], wrapped[idx+1:]...)
+	return nil
+}
+
+// Close writes any buffered text that hasn't been terminated by a newline
+func (ww *wrapWriter) Close() error {
+	if len(ww.line) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(ww.w, ww.wrapping.wrap(string(ww.line), ww.lineLength))
+	ww.line = ww.line[:0]
+	return err
+}